@@ -16,10 +16,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
 )
 
 func run() error {
@@ -38,12 +41,18 @@ func run() error {
 		tidySubcommand(ctx),
 		vendorSubcommand(ctx),
 		unvendorSubcommand(ctx),
+		migrateSubcommand(ctx),
+		verifySubcommand(ctx),
+		checkSubcommand(ctx),
 	)
 	return cmd.Execute()
 }
 
 func main() {
 	err := run()
+	if errors.Is(err, npmmod.ErrChangesPending) {
+		os.Exit(2)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
@@ -0,0 +1,37 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hardfinhq/npm-mod/pkg/migratecmd"
+)
+
+func migrateSubcommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "migrate",
+		Short:         "Upgrade .npm-mod.tidy.json to the current version",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return migratecmd.Run(ctx)
+		},
+	}
+
+	return cmd
+}
@@ -23,15 +23,26 @@ import (
 )
 
 func tidySubcommand(ctx context.Context) *cobra.Command {
+	opts := tidycmd.Options{}
+	keepVersions := false
 	cmd := &cobra.Command{
 		Use:           "tidy",
 		Short:         "Make sure the offline dependencies match the package.json",
 		SilenceErrors: true,
 		SilenceUsage:  true,
-		RunE: func(_ *cobra.Command, _ []string) error {
-			return tidycmd.Run(ctx)
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if cmd.Flags().Changed("keep-versions") {
+				opts.KeepVersions = &keepVersions
+			}
+			return tidycmd.Run(ctx, opts)
 		},
 	}
 
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Print a diff of the changes instead of writing them; exits 2 if there are changes")
+	cmd.Flags().BoolVar(&opts.Strict, "strict", false, "Fail if any dependency would still require a fetch from the registry")
+	cmd.Flags().BoolVar(&keepVersions, "keep-versions", false, "Keep the real package versions in package-lock.json (remembered for future runs)")
+	cmd.Flags().StringVar(&opts.Mode, "mode", "", "How to pin packages: vendor (file:vendor/... references), registry (resolved URLs rewritten to --mirror-url) or pin (package.json ranges replaced with the exact resolved versions); remembered for future runs")
+	cmd.Flags().StringVar(&opts.MirrorURL, "mirror-url", "", "Registry base URL that resolved URLs are rewritten to in registry mode (remembered for future runs)")
+
 	return cmd
 }
@@ -23,15 +23,18 @@ import (
 )
 
 func unvendorSubcommand(ctx context.Context) *cobra.Command {
+	opts := unvendorcmd.Options{}
 	cmd := &cobra.Command{
 		Use:           "unvendor",
 		Short:         "Restore package and package lock to state before vendoring",
 		SilenceErrors: true,
 		SilenceUsage:  true,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			return unvendorcmd.Run(ctx)
+			return unvendorcmd.Run(ctx, opts)
 		},
 	}
 
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Print a diff of the changes instead of writing them; exits 2 if there are changes")
+
 	return cmd
 }
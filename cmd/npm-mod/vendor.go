@@ -23,15 +23,26 @@ import (
 )
 
 func vendorSubcommand(ctx context.Context) *cobra.Command {
+	opts := vendorcmd.DefaultOptions()
 	cmd := &cobra.Command{
 		Use:           "vendor",
 		Short:         "Store npm packages offline and point package and package lock to local package archives",
 		SilenceErrors: true,
 		SilenceUsage:  true,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			return vendorcmd.Run(ctx)
+			return vendorcmd.Run(ctx, opts)
 		},
 	}
 
+	cmd.Flags().BoolVar(&opts.Prune, "prune", false, "Remove package archives from vendor/ that are not tracked in the tidy file")
+	cmd.Flags().IntVar(&opts.Retries, "retries", opts.Retries, "Number of times to retry a transient download failure (e.g. a 5xx response or a connection reset)")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "With --prune, only list the package archives that would be removed; exits 2 if there are any")
+	cmd.Flags().IntVar(&opts.Jobs, "jobs", opts.Jobs, "Number of package archives to fetch concurrently")
+	cmd.Flags().IntVar(&opts.JobsPerHost, "jobs-per-host", opts.JobsPerHost, "Number of concurrent requests to the same host")
+	cmd.Flags().Float64Var(&opts.RequestsPerSecond, "requests-per-second", 0, "Limit on the rate of requests across all hosts (0 means unlimited)")
+	cmd.Flags().Float64Var(&opts.BytesPerSecond, "bytes-per-second", 0, "Limit on the download rate across all hosts (0 means unlimited)")
+	cmd.Flags().StringArrayVar(&opts.Rewrites, "rewrite", nil, "Rewrite package archive URLs at download time, e.g. 'https://registry.npmjs.org/ => https://npm.internal/' (repeatable; the first match applies)")
+	cmd.Flags().StringArrayVar(&opts.Mirrors, "mirror", nil, "Mirror to try if a download fails, of the form 'FROM => TO' (repeatable; tried in order)")
+
 	return cmd
 }
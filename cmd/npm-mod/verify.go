@@ -0,0 +1,41 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hardfinhq/npm-mod/pkg/verifycmd"
+)
+
+func verifySubcommand(ctx context.Context) *cobra.Command {
+	opts := verifycmd.Options{}
+	cmd := &cobra.Command{
+		Use:           "verify",
+		Short:         "Check that vendor/, package.json and package-lock.json agree with the tidy file",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return verifycmd.Run(ctx, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.Quick, "quick", false, "Skip reading the package archives if the cached vendor/ hash matches and no archive has changed size or modification time")
+	cmd.Flags().BoolVar(&opts.JSON, "json", false, "Write the report to stdout as JSON")
+
+	return cmd
+}
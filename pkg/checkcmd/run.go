@@ -0,0 +1,98 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkcmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+// Options configures the `npm-mod check` command.
+type Options struct {
+	// JSON determines if the drift found should be written to stdout as JSON.
+	JSON bool
+}
+
+// Run executes the `npm-mod check` command.
+func Run(_ context.Context, opts Options) error {
+	here, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	root, err := npmmod.Locate(here)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Checking doesn't write anything, so it doesn't need to hold the
+	//       lock.
+	return check(root, opts)
+}
+
+func check(root string, opts Options) error {
+	tf, err := npmmod.ReadTidyFile(root)
+	if err != nil && os.IsNotExist(err) {
+		return fmt.Errorf(".npm-mod.tidy.json does not exist; run npm-mod tidy to create it")
+	}
+	if err != nil {
+		return err
+	}
+
+	pj, err := npmmod.ReadPackageJSON(root)
+	if err != nil {
+		return err
+	}
+	pl, err := npmmod.ReadPackageLock(root)
+	if err != nil {
+		return err
+	}
+
+	drift, err := npmmod.FindDrift(tf, pj, pl)
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(drift)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(drift) == 0 {
+		if !opts.JSON {
+			fmt.Println("package.json and package-lock.json agree with .npm-mod.tidy.json")
+		}
+		return nil
+	}
+	if opts.JSON {
+		return fmt.Errorf("check found %s", npmmod.ProblemCount(len(drift)))
+	}
+
+	lines := make([]string, len(drift))
+	for i, d := range drift {
+		lines[i] = fmt.Sprintf("- %s", d)
+	}
+	return fmt.Errorf("check found %s:\n%s", npmmod.ProblemCount(len(drift)), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,318 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Graph is a set of named tasks with dependencies between them. Running the
+// graph runs every task after all of its prerequisites, with as many tasks in
+// parallel as the dependencies (and the concurrency) allow.
+type Graph struct {
+	Policy Policy
+
+	nodes map[string]*graphNode
+	order []string
+}
+
+// graphNode is a task in a graph, along with its edges.
+type graphNode struct {
+	Name          string
+	Task          *Task
+	Prerequisites []*graphNode
+	Dependents    []*graphNode
+}
+
+// SkippedError is stored on a task in a graph that was never run because one
+// of its (direct or transitive) prerequisites failed.
+type SkippedError struct {
+	Name         string
+	Prerequisite string
+}
+
+// Error describes the skipped task.
+func (se *SkippedError) Error() string {
+	return fmt.Sprintf("%s was skipped; prerequisite %s failed", se.Name, se.Prerequisite)
+}
+
+// CycleError describes a dependency cycle in a graph.
+type CycleError struct {
+	// Cycle is the path around the cycle, where each task depends on the next
+	// one; the first and last names are the same.
+	Cycle []string
+}
+
+// Error describes the dependency cycle, e.g. `dependency cycle; a -> b -> a`
+// if `a` depends on `b` and `b` depends on `a`.
+func (ce *CycleError) Error() string {
+	return fmt.Sprintf("dependency cycle; %s", strings.Join(ce.Cycle, " -> "))
+}
+
+// NewGraph initializes a new (empty) graph. As with `Pool`, the graph uses
+// the `CollectAll` policy unless `Policy` is set before calling `Run()`; with
+// `CollectAll`, a failed task only causes its dependents to be skipped.
+func NewGraph() *Graph {
+	return &Graph{Policy: CollectAll, nodes: map[string]*graphNode{}}
+}
+
+// Add adds a named task to the graph.
+func (g *Graph) Add(name string, task *Task) error {
+	if _, ok := g.nodes[name]; ok {
+		return fmt.Errorf("task %q is already in the graph", name)
+	}
+
+	g.nodes[name] = &graphNode{Name: name, Task: task}
+	g.order = append(g.order, name)
+	return nil
+}
+
+// Depend records that the task `name` can only run after each of the
+// `prerequisites` has succeeded. All of the tasks must already be in the
+// graph.
+func (g *Graph) Depend(name string, prerequisites ...string) error {
+	n, ok := g.nodes[name]
+	if !ok {
+		return fmt.Errorf("task %q is not in the graph", name)
+	}
+
+	for _, prerequisite := range prerequisites {
+		p, ok := g.nodes[prerequisite]
+		if !ok {
+			return fmt.Errorf("prerequisite %q of task %q is not in the graph", prerequisite, name)
+		}
+
+		n.Prerequisites = append(n.Prerequisites, p)
+		p.Dependents = append(p.Dependents, n)
+	}
+
+	return nil
+}
+
+// Check returns a `*CycleError` describing the first dependency cycle in the
+// graph (if there is one).
+func (g *Graph) Check() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[*graphNode]int{}
+	path := []*graphNode{}
+
+	var visit func(n *graphNode) error
+	visit = func(n *graphNode) error {
+		state[n] = visiting
+		path = append(path, n)
+		for _, p := range n.Prerequisites {
+			if state[p] == visiting {
+				return newCycleError(path, p)
+			}
+			if state[p] == unvisited {
+				err := visit(p)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[n] = visited
+		return nil
+	}
+
+	for _, name := range g.order {
+		n := g.nodes[name]
+		if state[n] != unvisited {
+			continue
+		}
+
+		err := visit(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newCycleError describes the cycle at the end of `path` (which follows
+// prerequisites) that starts and ends at `start`.
+func newCycleError(path []*graphNode, start *graphNode) *CycleError {
+	i := len(path) - 1
+	for path[i] != start {
+		i--
+	}
+
+	cycle := []string{}
+	for _, n := range path[i:] {
+		cycle = append(cycle, n.Name)
+	}
+	return &CycleError{Cycle: append(cycle, start.Name)}
+}
+
+// completion is sent by a worker once it has run a task in a graph.
+type completion struct {
+	Node   *graphNode
+	Worker int
+}
+
+// Run runs every task in the graph, at most `concurrency` at a time, and
+// blocks until it's finished. A task is run once all of its prerequisites
+// have succeeded; if a prerequisite fails, the task (and all of its
+// dependents) are skipped with a `*SkippedError`. Tasks become ready in the
+// order they were added to the graph.
+//
+// Upon completion, the errors of the tasks that failed will be collected into
+// a multi-error (in the order the tasks were added); with `FailFast`, only the
+// first task error is returned. If the graph has a cycle, no task is run and
+// a `*CycleError` is returned.
+func (g *Graph) Run(ctx context.Context, concurrency int) error {
+	err := g.Check()
+	if err != nil {
+		return err
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pending := map[*graphNode]int{}
+	queue := []*graphNode{}
+	for _, name := range g.order {
+		n := g.nodes[name]
+		pending[n] = len(n.Prerequisites)
+		if pending[n] == 0 {
+			queue = append(queue, n)
+		}
+	}
+
+	free := make([]int, concurrency)
+	for i := range free {
+		free[i] = concurrency - 1 - i
+	}
+	finished := map[*graphNode]bool{}
+	done := make(chan completion)
+	running := 0
+	var failed error
+
+	// NOTE: Use a bounded for loop to avoid an accidental infinite loop; each
+	//       iteration either finishes a task or exits.
+	loopComplete := false
+	for i := 0; i <= 2*len(g.order); i++ {
+		for len(queue) > 0 && len(free) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			if taskCtx.Err() != nil {
+				n.Task.skip(taskCtx.Err())
+				finished[n] = true
+				continue
+			}
+
+			worker := free[len(free)-1]
+			free = free[:len(free)-1]
+			running++
+			go func() {
+				n.Task.Run(taskCtx, worker)
+				done <- completion{Node: n, Worker: worker}
+			}()
+		}
+
+		if running == 0 {
+			loopComplete = true
+			break
+		}
+
+		c := <-done
+		running--
+		free = append(free, c.Worker)
+		finished[c.Node] = true
+
+		if c.Node.Task.Error != nil {
+			if g.Policy == FailFast && failed == nil {
+				failed = c.Node.Task.Error
+				cancel()
+			}
+			skipDependents(c.Node, c.Node.Name, finished)
+			continue
+		}
+
+		for _, d := range c.Node.Dependents {
+			pending[d]--
+			if pending[d] == 0 && !finished[d] {
+				queue = append(queue, d)
+			}
+		}
+	}
+
+	if !loopComplete {
+		return errors.New("loop over graph tasks never terminated")
+	}
+
+	// Tasks that were waiting on a task skipped due to cancellation are
+	// skipped as well.
+	for _, name := range g.order {
+		n := g.nodes[name]
+		if !finished[n] {
+			n.Task.skip(taskCtx.Err())
+		}
+	}
+
+	if failed != nil {
+		return failed
+	}
+	return g.errors(ctx.Err())
+}
+
+// skipDependents marks every (transitive) dependent of `n` as skipped, unless
+// it has already finished.
+func skipDependents(n *graphNode, prerequisite string, finished map[*graphNode]bool) {
+	for _, d := range n.Dependents {
+		if finished[d] {
+			continue
+		}
+
+		d.Task.skip(&SkippedError{Name: d.Name, Prerequisite: prerequisite})
+		finished[d] = true
+		skipDependents(d, prerequisite, finished)
+	}
+}
+
+// errors collects the errors for the tasks in the graph that failed. If any
+// task was skipped because the context was cancelled, the context error is
+// included (once).
+func (g *Graph) errors(ctxErr error) error {
+	encountered := []error{}
+	cancelled := false
+	for _, name := range g.order {
+		t := g.nodes[name].Task
+		if !t.skipped {
+			encountered = append(encountered, t.Error)
+			continue
+		}
+		if _, ok := t.Error.(*SkippedError); !ok {
+			cancelled = true
+		}
+	}
+	if cancelled {
+		encountered = append(encountered, ctxErr)
+	}
+	return maybeMultiError(encountered...)
+}
@@ -0,0 +1,176 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package concurrency_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/concurrency"
+)
+
+func TestGraph_Run(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	// `b` and `c` both depend on `a` and can only complete if they run at
+	// the same time; `d` depends on both.
+	r := &recorder{}
+	barrier := sync.WaitGroup{}
+	barrier.Add(2)
+	g := concurrency.NewGraph()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		name := name // Copy to local to avoid closure around loop variable
+		err := g.Add(name, concurrency.NewTask(func(_ int) error {
+			if name == "b" || name == "c" {
+				barrier.Done()
+				barrier.Wait()
+			}
+			r.record(name)
+			return nil
+		}))
+		assert.Nil(err)
+	}
+	assert.Nil(g.Depend("b", "a"))
+	assert.Nil(g.Depend("c", "a"))
+	assert.Nil(g.Depend("d", "b", "c"))
+
+	err := g.Run(context.TODO(), 2)
+	assert.Nil(err)
+	assert.Len(r.Names, 4)
+	assert.Equal("a", r.Names[0])
+	assert.ElementsMatch([]string{"b", "c"}, r.Names[1:3])
+	assert.Equal("d", r.Names[3])
+}
+
+func TestGraph_Run_SkipDependents(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	known := errors.New("WRENCH a")
+	r := &recorder{}
+	tasks := map[string]*concurrency.Task{}
+	g := concurrency.NewGraph()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		name := name // Copy to local to avoid closure around loop variable
+		tasks[name] = concurrency.NewTask(func(_ int) error {
+			r.record(name)
+			if name == "a" {
+				return known
+			}
+			return nil
+		})
+		assert.Nil(g.Add(name, tasks[name]))
+	}
+	assert.Nil(g.Depend("b", "a"))
+	assert.Nil(g.Depend("c", "b"))
+
+	err := g.Run(context.TODO(), 1)
+	assert.Equal(known, err)
+	assert.Equal([]string{"a", "d"}, r.Names)
+	assert.Equal("b was skipped; prerequisite a failed", fmt.Sprintf("%v", tasks["b"].Error))
+	assert.Equal("c was skipped; prerequisite a failed", fmt.Sprintf("%v", tasks["c"].Error))
+	assert.Nil(tasks["d"].Error)
+}
+
+func TestGraph_Run_FailFast(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	known := errors.New("WRENCH a")
+	started := make(chan struct{})
+	tasks := []*concurrency.Task{
+		concurrency.NewContextTask(func(_ context.Context, _ int) error {
+			<-started
+			return known
+		}),
+		concurrency.NewContextTask(func(ctx context.Context, _ int) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		concurrency.NewTask(func(_ int) error {
+			return errors.New("never run")
+		}),
+	}
+	g := concurrency.NewGraph()
+	g.Policy = concurrency.FailFast
+	for i, task := range tasks {
+		assert.Nil(g.Add(fmt.Sprintf("%d", i), task))
+	}
+
+	err := g.Run(context.TODO(), 2)
+	assert.Equal(known, err)
+	assert.Equal(context.Canceled, tasks[1].Error)
+	assert.Equal(context.Canceled, tasks[2].Error)
+}
+
+func TestGraph_Run_Cycle(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	r := &recorder{}
+	g := concurrency.NewGraph()
+	for _, name := range []string{"d", "a", "b", "c"} {
+		name := name // Copy to local to avoid closure around loop variable
+		assert.Nil(g.Add(name, concurrency.NewTask(func(_ int) error {
+			r.record(name)
+			return nil
+		})))
+	}
+	assert.Nil(g.Depend("d", "a"))
+	assert.Nil(g.Depend("a", "b"))
+	assert.Nil(g.Depend("b", "c"))
+	assert.Nil(g.Depend("c", "a"))
+
+	err := g.Run(context.TODO(), 2)
+	assert.Equal("dependency cycle; a -> b -> c -> a", fmt.Sprintf("%v", err))
+	var ce *concurrency.CycleError
+	assert.True(errors.As(err, &ce))
+	assert.Equal([]string{"a", "b", "c", "a"}, ce.Cycle)
+	assert.Nil(r.Names)
+}
+
+func TestGraph_Error(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	g := concurrency.NewGraph()
+	assert.Nil(g.Add("a", concurrency.NewTask(func(_ int) error { return nil })))
+
+	err := g.Add("a", concurrency.NewTask(func(_ int) error { return nil }))
+	assert.Equal(`task "a" is already in the graph`, fmt.Sprintf("%v", err))
+	err = g.Depend("b", "a")
+	assert.Equal(`task "b" is not in the graph`, fmt.Sprintf("%v", err))
+	err = g.Depend("a", "b")
+	assert.Equal(`prerequisite "b" of task "a" is not in the graph`, fmt.Sprintf("%v", err))
+}
+
+// recorder records the order in which tasks complete.
+type recorder struct {
+	Names []string
+	mutex sync.Mutex
+}
+
+func (r *recorder) record(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Names = append(r.Names, name)
+}
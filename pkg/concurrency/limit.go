@@ -0,0 +1,179 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// KeyedLimiter limits how many callers can hold a slot for the same key
+// (e.g. the same host) at the same time.
+type KeyedLimiter struct {
+	limit int
+	mutex sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewKeyedLimiter initializes a new keyed limiter that allows at most `limit`
+// slots per key.
+func NewKeyedLimiter(limit int) *KeyedLimiter {
+	if limit < 1 {
+		limit = 1
+	}
+	return &KeyedLimiter{limit: limit, slots: map[string]chan struct{}{}}
+}
+
+// Acquire blocks until a slot for `key` is available (or the context is
+// done). The returned function releases the slot.
+func (kl *KeyedLimiter) Acquire(ctx context.Context, key string) (func(), error) {
+	slots := kl.slotsFor(key)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case slots <- struct{}{}:
+	}
+
+	once := sync.Once{}
+	return func() { once.Do(func() { <-slots }) }, nil
+}
+
+func (kl *KeyedLimiter) slotsFor(key string) chan struct{} {
+	kl.mutex.Lock()
+	defer kl.mutex.Unlock()
+
+	slots, ok := kl.slots[key]
+	if !ok {
+		slots = make(chan struct{}, kl.limit)
+		kl.slots[key] = slots
+	}
+	return slots
+}
+
+// TokenBucket is a rate limiter that allows an average of `Rate` tokens per
+// second with bursts of up to `Burst` tokens. A token can stand for anything,
+// e.g. a request or a byte.
+type TokenBucket struct {
+	Rate  float64
+	Burst float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket initializes a new (full) token bucket. If `burst` is less
+// than one second worth of tokens (or less than 1), one second worth (at
+// least 1) is used.
+func NewTokenBucket(rate, burst float64) (*TokenBucket, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("token bucket rate must be positive; %v", rate)
+	}
+	if burst < rate {
+		burst = rate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{Rate: rate, Burst: burst, tokens: burst, last: time.Now()}, nil
+}
+
+// Wait blocks until `n` tokens are available (or the context is done) and
+// takes them. Waiting for more than `Burst` tokens waits for them in chunks.
+func (tb *TokenBucket) Wait(ctx context.Context, n float64) error {
+	for n > 0 {
+		chunk := n
+		if chunk > tb.Burst {
+			chunk = tb.Burst
+		}
+
+		err := tb.wait(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		n -= chunk
+	}
+
+	return nil
+}
+
+// wait reserves `n` tokens (which may leave the bucket in debt) and then
+// sleeps until the debt is paid off. If the context is done first, the
+// tokens are returned.
+func (tb *TokenBucket) wait(ctx context.Context, n float64) error {
+	tb.mutex.Lock()
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.Rate
+	if tb.tokens > tb.Burst {
+		tb.tokens = tb.Burst
+	}
+	tb.last = now
+	tb.tokens -= n
+	delay := time.Duration(-tb.tokens / tb.Rate * float64(time.Second))
+	tb.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		tb.refund(n)
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// refund returns `n` unused tokens to the bucket.
+func (tb *TokenBucket) refund(n float64) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	tb.tokens += n
+}
+
+// RateLimitedReader limits the rate at which bytes can be read from the
+// wrapped reader to the rate of a token bucket (one token per byte).
+type RateLimitedReader struct {
+	Context context.Context
+	Reader  io.Reader
+	Bucket  *TokenBucket
+}
+
+// Read reads from the wrapped reader, after waiting for a token for every
+// byte that may be read. The tokens for bytes that weren't read are returned
+// to the bucket.
+func (rlr *RateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > int(rlr.Bucket.Burst) {
+		p = p[:int(rlr.Bucket.Burst)]
+	}
+
+	err := rlr.Bucket.Wait(rlr.Context, float64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := rlr.Reader.Read(p)
+	if n < len(p) {
+		rlr.Bucket.refund(float64(len(p) - n))
+	}
+	return n, err
+}
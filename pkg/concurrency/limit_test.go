@@ -0,0 +1,105 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package concurrency_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/concurrency"
+)
+
+func TestKeyedLimiter_Acquire(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	kl := concurrency.NewKeyedLimiter(2)
+	ctx := context.TODO()
+	releaseA1, err := kl.Acquire(ctx, "a.example.com")
+	assert.Nil(err)
+	_, err = kl.Acquire(ctx, "a.example.com")
+	assert.Nil(err)
+
+	// The limit is per key.
+	_, err = kl.Acquire(ctx, "b.example.com")
+	assert.Nil(err)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = kl.Acquire(timeoutCtx, "a.example.com")
+	assert.Equal(context.DeadlineExceeded, err)
+
+	// Releasing more than once only frees a single slot.
+	releaseA1()
+	releaseA1()
+	_, err = kl.Acquire(ctx, "a.example.com")
+	assert.Nil(err)
+	timeoutCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = kl.Acquire(timeoutCtx, "a.example.com")
+	assert.Equal(context.DeadlineExceeded, err)
+}
+
+func TestTokenBucket_Wait(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	_, err := concurrency.NewTokenBucket(0, 1)
+	assert.Equal("token bucket rate must be positive; 0", fmt.Sprintf("%v", err))
+
+	tb, err := concurrency.NewTokenBucket(200, 0)
+	assert.Nil(err)
+	assert.Equal(200.0, tb.Burst)
+
+	// The bucket starts out full, after which tokens are only available at
+	// the configured rate.
+	ctx := context.TODO()
+	start := time.Now()
+	err = tb.Wait(ctx, 200)
+	assert.Nil(err)
+	assert.Less(time.Since(start), 100*time.Millisecond)
+
+	start = time.Now()
+	err = tb.Wait(ctx, 50)
+	assert.Nil(err)
+	assert.GreaterOrEqual(time.Since(start), 200*time.Millisecond)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = tb.Wait(cancelled, 100)
+	assert.Equal(context.Canceled, err)
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	tb, err := concurrency.NewTokenBucket(2000, 0)
+	assert.Nil(err)
+	data := bytes.Repeat([]byte("x"), 2500)
+	rlr := &concurrency.RateLimitedReader{Context: context.TODO(), Reader: bytes.NewReader(data), Bucket: tb}
+
+	start := time.Now()
+	read, err := io.ReadAll(rlr)
+	assert.Nil(err)
+	assert.Equal(data, read)
+	assert.GreaterOrEqual(time.Since(start), 200*time.Millisecond)
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Result is the outcome of running the work function of a `ResultPool` on a
+// single input.
+type Result[Out any] struct {
+	// Index is the position of the input in `ResultPool.Inputs`.
+	Index int
+	Value Out
+	Error error
+	// Skipped is set if the work function was never run for the input
+	// because the pool was cancelled first; `Error` holds the context error.
+	Skipped bool
+	// Worker is the worker that ran the work function.
+	Worker int
+	// Started is when the work function started and Duration is how long it
+	// took.
+	Started  time.Time
+	Duration time.Duration
+}
+
+// ResultPool is a worker group that runs a work function for each of a number
+// of inputs at a configured concurrency and gathers a typed result for each
+// input, so that work functions don't need to report results through shared
+// state.
+type ResultPool[In, Out any] struct {
+	Inputs []In
+	Policy Policy
+
+	f           func(ctx context.Context, in In) (Out, error)
+	concurrency int
+	err         error
+}
+
+// NewResultPool initializes a new result pool with the given inputs and work
+// function and at the given concurrency. As with `Pool`, the `CollectAll`
+// policy is used unless `Policy` is set before calling `Run()` or `Stream()`.
+func NewResultPool[In, Out any](inputs []In, concurrency int, f func(ctx context.Context, in In) (Out, error)) *ResultPool[In, Out] {
+	return &ResultPool[In, Out]{
+		Inputs:      inputs,
+		Policy:      CollectAll,
+		f:           f,
+		concurrency: concurrency,
+	}
+}
+
+// Run runs the work function for every input and blocks until it's finished.
+// The results are returned in input order, along with the same error `Pool`
+// would return for the same task errors.
+func (p *ResultPool[In, Out]) Run(ctx context.Context) ([]Result[Out], error) {
+	results := make([]Result[Out], len(p.Inputs))
+	for r := range p.Stream(ctx) {
+		results[r.Index] = r
+	}
+	return results, p.Err()
+}
+
+// Stream runs the work function for every input and sends each result over
+// the returned channel in completion order; inputs that are skipped are sent
+// last. The channel is closed once every input has a result, after which
+// `Err()` returns the error for the pool.
+//
+// The channel is buffered for every input, so the workers never block on a
+// slow (or absent) reader.
+func (p *ResultPool[In, Out]) Stream(ctx context.Context) <-chan Result[Out] {
+	results := make(chan Result[Out], len(p.Inputs))
+	go func() {
+		p.err = p.run(ctx, results)
+		close(results)
+	}()
+	return results
+}
+
+// Err returns the error for the pool; it is only meaningful once the channel
+// returned by `Stream()` has been closed.
+func (p *ResultPool[In, Out]) Err() error {
+	return p.err
+}
+
+func (p *ResultPool[In, Out]) run(ctx context.Context, results chan<- Result[Out]) error {
+	tasks := make([]*Task, len(p.Inputs))
+	for i := range p.Inputs {
+		i := i // Copy to local to avoid closure around loop variable
+		tasks[i] = NewContextTask(func(ctx context.Context, worker int) error {
+			r := p.runOne(ctx, i, worker)
+			results <- r
+			return r.Error
+		})
+	}
+
+	pool := NewPool(tasks, p.concurrency)
+	pool.Policy = p.Policy
+	err := pool.Run(ctx)
+
+	for i, task := range tasks {
+		if task.skipped {
+			results <- Result[Out]{Index: i, Error: task.Error, Skipped: true}
+		}
+	}
+
+	return err
+}
+
+// runOne runs the work function for a single input. A panic in the work
+// function is recovered and stored as an error.
+func (p *ResultPool[In, Out]) runOne(ctx context.Context, i, worker int) (r Result[Out]) {
+	r = Result[Out]{Index: i, Worker: worker, Started: time.Now()}
+	defer func() {
+		r.Duration = time.Since(r.Started)
+		rec := recover()
+		if rec != nil {
+			r.Error = fmt.Errorf("task panicked; %v", rec)
+		}
+	}()
+
+	r.Value, r.Error = p.f(ctx, p.Inputs[i])
+	return r
+}
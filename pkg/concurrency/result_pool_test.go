@@ -0,0 +1,115 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package concurrency_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/concurrency"
+)
+
+func TestResultPool_Run(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	inputs := []int{2, 5, 16, -4, 202}
+	pool := concurrency.NewResultPool(inputs, 3, func(_ context.Context, in int) (string, error) {
+		return fmt.Sprintf("%d", 2*in), nil
+	})
+	results, err := pool.Run(context.TODO())
+	assert.Nil(err)
+
+	values := []string{}
+	for i, r := range results {
+		assert.Equal(i, r.Index)
+		assert.Nil(r.Error)
+		assert.False(r.Skipped)
+		assert.False(r.Started.IsZero())
+		assert.GreaterOrEqual(r.Worker, 0)
+		assert.Less(r.Worker, 3)
+		values = append(values, r.Value)
+	}
+	assert.Equal([]string{"4", "10", "32", "-8", "404"}, values)
+}
+
+func TestResultPool_Stream(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	// The first input only completes after the result for the second input
+	// has been streamed.
+	release := make(chan struct{})
+	pool := concurrency.NewResultPool([]int{0, 1}, 2, func(_ context.Context, in int) (int, error) {
+		if in == 0 {
+			<-release
+		}
+		return in, nil
+	})
+
+	order := []int{}
+	for r := range pool.Stream(context.TODO()) {
+		order = append(order, r.Index)
+		if r.Index == 1 {
+			close(release)
+		}
+	}
+	assert.Nil(pool.Err())
+	assert.Equal([]int{1, 0}, order)
+}
+
+func TestResultPool_Run_FailFast(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	known := errors.New("WRENCH f() 1")
+	pool := concurrency.NewResultPool([]int{1, 2, 3}, 1, func(_ context.Context, in int) (int, error) {
+		if in == 1 {
+			return 0, known
+		}
+		return in, nil
+	})
+	pool.Policy = concurrency.FailFast
+	results, err := pool.Run(context.TODO())
+	assert.Equal(known, err)
+
+	assert.Equal(known, results[0].Error)
+	assert.False(results[0].Skipped)
+	for _, r := range results[1:] {
+		assert.Equal(context.Canceled, r.Error)
+		assert.True(r.Skipped)
+		assert.Equal(0, r.Value)
+	}
+}
+
+func TestResultPool_Run_Panic(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	pool := concurrency.NewResultPool([]int{1, 2}, 1, func(_ context.Context, in int) (int, error) {
+		if in == 1 {
+			panic("WRENCH f()")
+		}
+		return in, nil
+	})
+	results, err := pool.Run(context.TODO())
+	assert.Equal("task panicked; WRENCH f()", fmt.Sprintf("%v", err))
+	assert.Equal("task panicked; WRENCH f()", fmt.Sprintf("%v", results[0].Error))
+	assert.Equal(2, results[1].Value)
+}
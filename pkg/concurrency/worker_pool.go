@@ -15,69 +15,117 @@
 package concurrency
 
 import (
+	"context"
+	"fmt"
 	"sync"
 )
 
 // H/T: https://brandur.org/go-worker-pool
 
+// Policy determines how a pool handles a task that fails.
+type Policy int
+
+const (
+	// CollectAll runs every task (unless the context is cancelled) and
+	// collects all of the task errors.
+	CollectAll Policy = iota
+	// FailFast stops dispatching tasks and cancels the context of the tasks
+	// that are still running once the first task fails.
+	FailFast
+)
+
 // Task encapsulates a work item that should go in a worker pool.
 //
 // If a task is run more than once, the `Error` will be overwritten.
 type Task struct {
 	// Error holds an error that occurred during a task. Its
 	// result is only meaningful after Run has been called
-	// for the pool that holds it.
+	// for the pool that holds it. A task that was never run (because the
+	// pool was cancelled first) holds the context error.
 	Error error
 
-	f func(i int) error
+	f       func(ctx context.Context, i int) error
+	skipped bool
 }
 
 // NewTask creates a new task based on a given work function.
 func NewTask(f func(i int) error) *Task {
+	return &Task{f: func(_ context.Context, i int) error { return f(i) }}
+}
+
+// NewContextTask creates a new task based on a given work function that can
+// be cancelled via its context (e.g. once another task fails with
+// `FailFast`).
+func NewContextTask(f func(ctx context.Context, i int) error) *Task {
 	return &Task{f: f}
 }
 
-// Run runs a Task, stores any error and marks the passed in work group as done.
+// Run runs a Task and stores any error. A panic in the work function is
+// recovered and stored as an error.
 //
 // This modifies the current task in a way that is not concurrency safe; the
 // expectation is that a given task will be handled by / run in a single
 // goroutine.
-func (t *Task) Run(wg *sync.WaitGroup, i int) {
-	defer wg.Done()
-	t.Error = t.f(i)
+func (t *Task) Run(ctx context.Context, i int) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			t.Error = fmt.Errorf("task panicked; %v", r)
+		}
+	}()
+
+	t.Error = t.f(ctx, i)
+}
+
+// skip marks a task that was never run because the pool was cancelled.
+func (t *Task) skip(err error) {
+	t.skipped = true
+	t.Error = err
 }
 
 // Pool is a worker group that runs a number of tasks at a configured
 // concurrency.
 type Pool struct {
-	Tasks []*Task
+	Tasks  []*Task
+	Policy Policy
 
 	concurrency int
 	tasksChan   chan *Task
 	wg          sync.WaitGroup
+	mutex       sync.Mutex
+	failed      error
+	cancel      context.CancelFunc
 }
 
 // NewPool initializes a new pool with the given tasks and at the given
-// concurrency.
+// concurrency. The pool uses the `CollectAll` policy unless `Policy` is set
+// before calling `Run()`.
 func NewPool(tasks []*Task, concurrency int) *Pool {
 	return &Pool{
 		Tasks:       tasks,
+		Policy:      CollectAll,
 		concurrency: concurrency,
 		tasksChan:   make(chan *Task),
 	}
 }
 
 // Run runs all work within the pool and blocks until it's finished. Upon
-// completion, the task errors will be collected into a multi-error.
-func (p *Pool) Run() error {
+// completion, the task errors will be collected into a multi-error; with
+// `FailFast`, only the first task error is returned.
+//
+// Once `ctx` is cancelled (or, with `FailFast`, once a task fails) no more
+// tasks are dispatched; the tasks that are already running are expected to
+// return early when their context is done.
+func (p *Pool) Run(ctx context.Context) error {
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p.cancel = cancel
+
 	for i := 0; i < p.concurrency; i++ {
-		go p.work(i)
+		go p.work(taskCtx, i)
 	}
 
-	p.wg.Add(len(p.Tasks))
-	for _, task := range p.Tasks {
-		p.tasksChan <- task
-	}
+	dispatched := p.dispatch(taskCtx)
 
 	// Close channel just so as not to orphan resources; note since
 	// `p.tasksChan` is an unbuffered channel, all of the `p.tasksChan <- task`
@@ -89,22 +137,81 @@ func (p *Pool) Run() error {
 
 	p.wg.Wait()
 
-	return p.errors()
+	for _, task := range p.Tasks[dispatched:] {
+		task.skip(taskCtx.Err())
+	}
+
+	if p.failed != nil {
+		return p.failed
+	}
+	return p.errors(ctx.Err())
+}
+
+// dispatch sends tasks to the workers until every task has been sent or the
+// context is done. It returns the number of tasks sent.
+func (p *Pool) dispatch(ctx context.Context) int {
+	for i, task := range p.Tasks {
+		if ctx.Err() != nil {
+			return i
+		}
+
+		p.wg.Add(1)
+		select {
+		case <-ctx.Done():
+			p.wg.Done()
+			return i
+		case p.tasksChan <- task:
+		}
+	}
+
+	return len(p.Tasks)
 }
 
 // work runs the work loop; expected to be run in a single goroutine in
 // the worker pool.
-func (p *Pool) work(i int) {
+func (p *Pool) work(ctx context.Context, i int) {
 	for task := range p.tasksChan {
-		task.Run(&p.wg, i)
+		// NOTE: A task may still be received after cancellation, since
+		//       `dispatch()` can't prefer `ctx.Done()` over a ready worker.
+		if ctx.Err() != nil {
+			task.skip(ctx.Err())
+			p.wg.Done()
+			continue
+		}
+
+		task.Run(ctx, i)
+		if task.Error != nil && p.Policy == FailFast {
+			p.fail(task.Error)
+		}
+		p.wg.Done()
 	}
 }
 
-// errors collects the errors for all tasks in the pool.
-func (p *Pool) errors() error {
-	encountered := make([]error, len(p.Tasks))
-	for i, task := range p.Tasks {
-		encountered[i] = task.Error
+// fail records the first task error and cancels the remaining tasks.
+func (p *Pool) fail(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.failed == nil {
+		p.failed = err
+		p.cancel()
+	}
+}
+
+// errors collects the errors for all tasks in the pool that were run. If any
+// task was skipped, the context error is included (once).
+func (p *Pool) errors(ctxErr error) error {
+	encountered := []error{}
+	skipped := false
+	for _, task := range p.Tasks {
+		if task.skipped {
+			skipped = true
+			continue
+		}
+		encountered = append(encountered, task.Error)
+	}
+	if skipped {
+		encountered = append(encountered, ctxErr)
 	}
 	return maybeMultiError(encountered...)
 }
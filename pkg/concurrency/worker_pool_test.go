@@ -15,6 +15,7 @@
 package concurrency_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sort"
@@ -45,7 +46,7 @@ func TestPool_Run_WithoutError(t *testing.T) {
 	}
 
 	pool := concurrency.NewPool(tasks, 3)
-	err := pool.Run()
+	err := pool.Run(context.TODO())
 	assert.Nil(err)
 
 	expected := []*taskFunc{
@@ -94,7 +95,7 @@ func TestPool_Run_WorkerAssignment(t *testing.T) {
 	}
 
 	pool := concurrency.NewPool(tasks, poolSize)
-	err := pool.Run()
+	err := pool.Run(context.TODO())
 	assert.Nil(err)
 
 	// We can't control the order in which the first `poolSize` get processed,
@@ -133,7 +134,7 @@ func TestPool_Run_OneError(t *testing.T) {
 	}
 
 	pool := concurrency.NewPool(tasks, 1)
-	err := pool.Run()
+	err := pool.Run(context.TODO())
 	assert.Equal(known, err)
 
 	expected := []*taskFunc{
@@ -159,7 +160,7 @@ func TestPool_Run_MultiError(t *testing.T) {
 	}
 
 	pool := concurrency.NewPool(tasks, 1)
-	err := pool.Run()
+	err := pool.Run(context.TODO())
 	assert.NotNil(err)
 	assert.Equal("2 errors occurred:\n\t* WRENCH Do() 1337\n\t* WRENCH Do() 42\n\n", fmt.Sprintf("%v", err))
 
@@ -170,6 +171,86 @@ func TestPool_Run_MultiError(t *testing.T) {
 	assert.Equal(expected, funcs)
 }
 
+func TestPool_Run_FailFast(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	// The first task fails while the second is still running; the second
+	// task is cancelled and the remaining tasks are never dispatched.
+	known := errors.New("WRENCH Do() 0")
+	started := make(chan struct{})
+	cancelled := errors.New("never")
+	tasks := []*concurrency.Task{
+		concurrency.NewContextTask(func(_ context.Context, _ int) error {
+			<-started
+			return known
+		}),
+		concurrency.NewContextTask(func(ctx context.Context, _ int) error {
+			close(started)
+			<-ctx.Done()
+			cancelled = ctx.Err()
+			return cancelled
+		}),
+	}
+	funcs := []*taskFunc{{Input: 1}, {Input: 2}, {Input: 3}}
+	for _, f := range funcs {
+		tasks = append(tasks, concurrency.NewTask(f.Do))
+	}
+
+	pool := concurrency.NewPool(tasks, 2)
+	pool.Policy = concurrency.FailFast
+	err := pool.Run(context.TODO())
+	assert.Equal(known, err)
+	assert.Equal(context.Canceled, cancelled)
+
+	expected := []*taskFunc{{Input: 1}, {Input: 2}, {Input: 3}}
+	assert.Equal(expected, funcs)
+	for _, task := range tasks[2:] {
+		assert.Equal(context.Canceled, task.Error)
+	}
+}
+
+func TestPool_Run_Cancelled(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	funcs := []*taskFunc{{Input: 1}, {Input: 2}}
+	tasks := make([]*concurrency.Task, len(funcs))
+	for i, f := range funcs {
+		tasks[i] = concurrency.NewTask(f.Do)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pool := concurrency.NewPool(tasks, 1)
+	err := pool.Run(ctx)
+	assert.Equal(context.Canceled, err)
+
+	expected := []*taskFunc{{Input: 1}, {Input: 2}}
+	assert.Equal(expected, funcs)
+	for _, task := range tasks {
+		assert.Equal(context.Canceled, task.Error)
+	}
+}
+
+func TestPool_Run_Panic(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	f := &taskFunc{Input: 4}
+	tasks := []*concurrency.Task{
+		concurrency.NewTask(func(_ int) error {
+			panic("WRENCH Do()")
+		}),
+		concurrency.NewTask(f.Do),
+	}
+
+	pool := concurrency.NewPool(tasks, 1)
+	err := pool.Run(context.TODO())
+	assert.Equal("task panicked; WRENCH Do()", fmt.Sprintf("%v", err))
+	assert.Equal(&taskFunc{Input: 4, Output: 8}, f)
+}
+
 type taskFunc struct {
 	Input  int
 	Output int
@@ -0,0 +1,81 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migratecmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+// Run executes the `npm-mod migrate` command.
+func Run(_ context.Context) error {
+	here, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	root, err := npmmod.Locate(here)
+	if err != nil {
+		return err
+	}
+
+	release, err := npmmod.Lock(root)
+	if err != nil {
+		return err
+	}
+
+	err = migrate(root)
+	releaseErr := release()
+	if err != nil {
+		return err
+	}
+	return releaseErr
+}
+
+// migrate upgrades the `.npm-mod.tidy.json` in place (if it is not already at
+// the current version) and writes the matching `npm.sum`.
+func migrate(root string) error {
+	tf, err := npmmod.ReadTidyFile(root)
+	if err != nil {
+		return err
+	}
+
+	if tf.MigratedFrom == "" {
+		fmt.Printf("Already at version %s\n", tf.Version)
+		return nil
+	}
+
+	txn := npmmod.NewTransaction()
+	err = tf.Persist(txn)
+	if err != nil {
+		return err
+	}
+
+	err = tf.PersistSum(txn)
+	if err != nil {
+		return err
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Migrated .npm-mod.tidy.json from version %s to %s\n", tf.MigratedFrom, tf.Version)
+	return nil
+}
@@ -0,0 +1,308 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+	"github.com/hardfinhq/npm-mod/pkg/semver"
+)
+
+// NOTE: Ensure that
+//       * `CheckDependencies{}.Visit` satisfies `VisitorFunc`.
+//       * `CheckDependency{}.Visit` satisfies `VisitorFunc`.
+//       * `dependencySpecs{}.Visit` satisfies `VisitorFunc`.
+var (
+	_ VisitorFunc = (&CheckDependencies{}).Visit
+	_ VisitorFunc = (&CheckDependency{}).Visit
+	_ VisitorFunc = (dependencySpecs{}).Visit
+)
+
+// Disagreement describes a dependency where a `package-lock.json` disagrees
+// with the `package.json` (or with itself). These are the dependencies that
+// `npm ci` would not install exactly as described by the `package-lock.json`,
+// e.g. because it would re-resolve them from the registry.
+type Disagreement struct {
+	// Location is the location of the package in the `package-lock.json`
+	// packages map that has the dependency, i.e. `""` for the root package.
+	Location string
+	Name     string
+	Spec     string
+	Reason   string
+}
+
+// String describes the disagreement, e.g.
+// `packages["node_modules/a"] "b": "^1.0.0" (reason)`.
+func (d Disagreement) String() string {
+	return fmt.Sprintf("packages[%q] %q: %q (%s)", d.Location, d.Name, d.Spec, d.Reason)
+}
+
+// ValidatePackageLockJSON checks that a `package-lock.json` agrees with a
+// `package.json` in the same way that `npm` does before it trusts the
+// `package-lock.json`:
+// - The dependencies of the root package (i.e. `packages[""]`) must match the
+//   `package.json` dependencies exactly
+// - Every dependency of every package (including the root package) must
+//   resolve (via the `node_modules` lookup) to a package in the
+//   `package-lock.json` that satisfies the dependency specifier; optional and
+//   peer dependencies may be absent
+//
+// Specifiers other than version ranges, aliases and `file:` archives (e.g.
+// dist-tags or git URLs) are not checked. A `lockfileVersion: 1` lockfile
+// has no packages map and is never checked.
+func ValidatePackageLockJSON(packageJSON, packageLock []byte) ([]Disagreement, error) {
+	pj := ordered.NewOrderedMap()
+	err := json.Unmarshal(packageJSON, &pj)
+	if err != nil {
+		return nil, err
+	}
+
+	pl := ordered.NewOrderedMap()
+	err = json.Unmarshal(packageLock, &pl)
+	if err != nil {
+		return nil, err
+	}
+
+	packages, err := lockPackages(pl)
+	if err != nil || packages == nil {
+		return nil, err
+	}
+
+	root, err := lockPackage(pl, "")
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		d := Disagreement{Reason: `package-lock.json has no root package`}
+		return []Disagreement{d}, nil
+	}
+
+	disagreements, err := compareRoot(pj, root)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: The root package edges come from `package.json` (not from
+	//       `packages[""]`), just like in `npm`.
+	cd := CheckDependency{Packages: packages}
+	for _, key := range packageJSONDependencyKeys {
+		cd.ParentKey = key
+		err = walkPackageJSON(pj, key, cd.Visit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	disagreements = append(disagreements, cd.Disagreements...)
+
+	cds := CheckDependencies{Packages: packages}
+	err = walkPackageLockPackages(pl, cds.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(disagreements, cds.Disagreements...), nil
+}
+
+// CheckDependencies produces a visitor function that checks the dependencies
+// of a (non-root) package in the `package-lock.json` packages map.
+type CheckDependencies struct {
+	Packages *ordered.OrderedMap
+	// Disagreements collects every dependency that is not satisfied.
+	Disagreements []Disagreement
+}
+
+// Visit is a visitor function that **checks** that every dependency of a
+// package resolves to a package that satisfies the dependency specifier.
+func (cds *CheckDependencies) Visit(_ *ordered.OrderedMap, k string, v any) error {
+	location := k
+	if location == "" {
+		return nil
+	}
+
+	m, ok := v.(*ordered.OrderedMap)
+	if !ok {
+		return fmt.Errorf("package %q does not point at a map", location)
+	}
+	if isLink(m) {
+		return nil
+	}
+
+	cd := CheckDependency{Packages: cds.Packages, Location: location, Dir: requestorDir(location, m)}
+	for _, key := range lockDependencyKeys {
+		cd.ParentKey = key
+		err := walkPackageJSON(m, key, cd.Visit)
+		if err != nil {
+			return err
+		}
+	}
+
+	cds.Disagreements = append(cds.Disagreements, cd.Disagreements...)
+	return nil
+}
+
+// CheckDependency produces a visitor function that checks a dependency of the
+// package at `Location` in the `package-lock.json` packages map.
+type CheckDependency struct {
+	Packages *ordered.OrderedMap
+	Location string
+	// Dir is the directory (relative to the project root) that `npm` resolves
+	// `file:` references against for the package at `Location`.
+	Dir       string
+	ParentKey string
+	// Disagreements collects every dependency that is not satisfied.
+	Disagreements []Disagreement
+}
+
+// Visit is a visitor function that **checks** that a dependency resolves to a
+// package that satisfies the dependency specifier.
+func (cd *CheckDependency) Visit(_ *ordered.OrderedMap, k string, v any) error {
+	name := k
+	spec, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("dependency %q is not a string", name)
+	}
+
+	target, m, ok := lookupPackage(cd.Packages, cd.Location, name)
+	if !ok {
+		if cd.ParentKey == "optionalDependencies" || cd.ParentKey == "peerDependencies" {
+			return nil
+		}
+		cd.disagree(name, spec, "not in package-lock.json")
+		return nil
+	}
+
+	if isLink(m) {
+		return nil
+	}
+
+	reason, err := checkSpec(target, m, spec, cd.Dir)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		cd.disagree(name, spec, reason)
+	}
+	return nil
+}
+
+func (cd *CheckDependency) disagree(name, spec, reason string) {
+	d := Disagreement{Location: cd.Location, Name: name, Spec: spec, Reason: reason}
+	cd.Disagreements = append(cd.Disagreements, d)
+}
+
+// checkSpec determines if the package at `target` satisfies a dependency
+// specifier. If it does not, a reason is returned.
+func checkSpec(target string, m *ordered.OrderedMap, spec, dir string) (string, error) {
+	if strings.HasPrefix(spec, "file:") {
+		resolved, _ := m.Get("resolved").(string)
+		expected := "file:" + path.Join(dir, strings.TrimPrefix(spec, "file:"))
+		if resolved != expected {
+			return fmt.Sprintf("refers to %s but %s is resolved from %s", expected, target, resolved), nil
+		}
+		return "", nil
+	}
+
+	r, err := semver.ParseRange(rangeFromSpecifier(spec))
+	if err != nil {
+		return "", nil
+	}
+
+	version, _ := m.Get("version").(string)
+	v, err := semver.Parse(version)
+	if err != nil || !r.Contains(v) {
+		return fmt.Sprintf("%s has version %s", target, version), nil
+	}
+
+	return "", nil
+}
+
+// compareRoot compares the dependencies of the root package in a
+// `package-lock.json` with the `package.json` dependencies.
+func compareRoot(packageJSON, root *ordered.OrderedMap) ([]Disagreement, error) {
+	disagreements := []Disagreement{}
+	for _, key := range packageJSONDependencyKeys {
+		expected, err := dependencyMap(packageJSON, key)
+		if err != nil {
+			return nil, err
+		}
+
+		actual, err := dependencyMap(root, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range actual.sortedNames() {
+			spec, ok := expected[name]
+			if !ok {
+				d := Disagreement{Name: name, Spec: actual[name], Reason: fmt.Sprintf("not in package.json %s", key)}
+				disagreements = append(disagreements, d)
+				continue
+			}
+			if spec != actual[name] {
+				d := Disagreement{Name: name, Spec: actual[name], Reason: fmt.Sprintf("package.json %s has %q", key, spec)}
+				disagreements = append(disagreements, d)
+			}
+		}
+
+		for _, name := range expected.sortedNames() {
+			if _, ok := actual[name]; !ok {
+				d := Disagreement{Name: name, Spec: expected[name], Reason: fmt.Sprintf("not in package-lock.json %s", key)}
+				disagreements = append(disagreements, d)
+			}
+		}
+	}
+
+	return disagreements, nil
+}
+
+// dependencySpecs collects the entries of a dependencies map.
+type dependencySpecs map[string]string
+
+// Visit is a visitor function that **collects** a dependency specifier.
+func (ds dependencySpecs) Visit(_ *ordered.OrderedMap, k string, v any) error {
+	spec, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("dependency %q is not a string", k)
+	}
+
+	ds[k] = spec
+	return nil
+}
+
+// dependencyMap collects the `key` dependencies map of a `package.json` (or
+// of a package in a `package-lock.json`).
+func dependencyMap(m *ordered.OrderedMap, key string) (dependencySpecs, error) {
+	ds := dependencySpecs{}
+	err := walkPackageJSON(m, key, ds.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+func (ds dependencySpecs) sortedNames() []string {
+	names := []string{}
+	for name := range ds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
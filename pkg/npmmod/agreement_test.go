@@ -0,0 +1,117 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestValidatePackageLockJSON(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Name        string
+		PackageJSON string
+		PackageLock string
+		Expected    []string
+	}
+
+	cases := []testCase{
+		{
+			Name:        "agree",
+			PackageJSON: `{"dependencies": {"a": "file:vendor/a-1.0.0.tgz"}}`,
+			PackageLock: `{"packages": {
+				"": {"dependencies": {"a": "file:vendor/a-1.0.0.tgz"}},
+				"node_modules/a": {"version": "1.0.0", "resolved": "file:vendor/a-1.0.0.tgz", "dependencies": {"b": "file:b-1.0.3.tgz", "c": "^2.0.0"}, "optionalDependencies": {"fsevents": "^2.3.0"}},
+				"node_modules/b": {"version": "1.0.3", "resolved": "file:vendor/b-1.0.3.tgz"},
+				"node_modules/c": {"version": "2.1.0", "resolved": "https://registry.npmjs.org/c/-/c-2.1.0.tgz"}
+			}}`,
+			Expected: []string{},
+		},
+		{
+			Name:        "root",
+			PackageJSON: `{"dependencies": {"a": "file:vendor/a-1.0.0.tgz"}, "devDependencies": {"c": "^2.0.0"}}`,
+			PackageLock: `{"packages": {
+				"": {"dependencies": {"a": "^1.0.0", "b": "^1.0.0"}},
+				"node_modules/a": {"version": "1.0.0", "resolved": "file:vendor/a-1.0.0.tgz"},
+				"node_modules/b": {"version": "1.0.3", "resolved": "file:vendor/b-1.0.3.tgz"},
+				"node_modules/c": {"version": "2.1.0", "resolved": "https://registry.npmjs.org/c/-/c-2.1.0.tgz"}
+			}}`,
+			Expected: []string{
+				`packages[""] "a": "^1.0.0" (package.json dependencies has "file:vendor/a-1.0.0.tgz")`,
+				`packages[""] "b": "^1.0.0" (not in package.json dependencies)`,
+				`packages[""] "c": "^2.0.0" (not in package-lock.json devDependencies)`,
+			},
+		},
+		{
+			Name:        "packages",
+			PackageJSON: `{"dependencies": {"a": "^1.0.0"}}`,
+			PackageLock: `{"packages": {
+				"": {"dependencies": {"a": "^1.0.0"}},
+				"node_modules/a": {"version": "1.0.0", "resolved": "file:vendor/a-1.0.0.tgz", "dependencies": {"b": "^1.0.0", "c": "file:vendor/c-2.1.0.tgz", "d": "^4.0.0"}},
+				"node_modules/a/node_modules/b": {"version": "file:vendor/b-1.0.3.tgz", "resolved": "file:vendor/b-1.0.3.tgz", "peerDependencies": {"c": "^1.0.0"}},
+				"node_modules/c": {"version": "2.1.0", "resolved": "file:vendor/c-2.1.0.tgz"}
+			}}`,
+			Expected: []string{
+				`packages["node_modules/a"] "b": "^1.0.0" (node_modules/a/node_modules/b has version file:vendor/b-1.0.3.tgz)`,
+				`packages["node_modules/a"] "c": "file:vendor/c-2.1.0.tgz" (refers to file:vendor/vendor/c-2.1.0.tgz but node_modules/c is resolved from file:vendor/c-2.1.0.tgz)`,
+				`packages["node_modules/a"] "d": "^4.0.0" (not in package-lock.json)`,
+				`packages["node_modules/a/node_modules/b"] "c": "^1.0.0" (node_modules/c has version 2.1.0)`,
+			},
+		},
+		{
+			Name:        "lockfile-v1",
+			PackageJSON: `{"dependencies": {"a": "^1.0.0"}}`,
+			PackageLock: `{"dependencies": {"a": {"version": "file:vendor/a-1.0.0.tgz"}}}`,
+			Expected:    []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			disagreements, err := npmmod.ValidatePackageLockJSON([]byte(tc.PackageJSON), []byte(tc.PackageLock))
+			assert.Nil(err)
+			actual := []string{}
+			for _, d := range disagreements {
+				actual = append(actual, d.String())
+			}
+			assert.Equal(tc.Expected, actual)
+		})
+	}
+}
+
+func TestValidatePackageLockJSON_Fixture(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	packageJSON, err := os.ReadFile(filepath.Join("testdata", "ranges", "package.json"))
+	assert.Nil(err)
+	packageLock, err := os.ReadFile(filepath.Join("testdata", "ranges", "package-lock.json"))
+	assert.Nil(err)
+
+	disagreements, err := npmmod.ValidatePackageLockJSON(packageJSON, packageLock)
+	assert.Nil(err)
+	assert.Empty(disagreements)
+}
@@ -0,0 +1,50 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package npmmod
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// chownLike changes the owner and group of `filename` to match `existing`.
+// This is best-effort: only a privileged user can give a file away, so a
+// permission error (e.g. when a group-writable file owned by someone else is
+// replaced) leaves `filename` owned by the current user.
+func chownLike(filename string, existing os.FileInfo) error {
+	stat, ok := existing.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	current, ok := fi.Sys().(*syscall.Stat_t)
+	if ok && current.Uid == stat.Uid && current.Gid == stat.Gid {
+		return nil
+	}
+
+	err = os.Chown(filename, int(stat.Uid), int(stat.Gid))
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+		return nil
+	}
+	return err
+}
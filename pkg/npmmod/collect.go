@@ -16,6 +16,7 @@ package npmmod
 
 import (
 	"fmt"
+	neturl "net/url"
 
 	"github.com/hardfinhq/npm-mod/pkg/ordered"
 )
@@ -53,6 +54,13 @@ func (cp *CollectPackages) Visit(deps *ordered.OrderedMap, k string, v any) erro
 		return fmt.Errorf(`package %q "resolved" is not a string`, name)
 	}
 
+	// NOTE: Credentials belong in `.npmrc`; a URL with credentials would
+	//       end up in `.npm-mod.tidy.json` (and in error messages).
+	u, err := neturl.Parse(resolved)
+	if err == nil && u.User != nil {
+		return fmt.Errorf(`package %q "resolved" URL contains credentials; %s`, name, u.Redacted())
+	}
+
 	integrityAny := packageMap.Get("integrity")
 	integrity, ok := integrityAny.(string)
 	if !ok {
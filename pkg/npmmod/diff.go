@@ -0,0 +1,97 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+var (
+	// ErrChangesPending is used to signal that a dry run found changes that
+	// would be written.
+	ErrChangesPending = errors.New("changes pending")
+)
+
+// Diff produces a unified diff between the contents of every staged write (or
+// removal) and the file currently on disk. Paths in the diff are relative to `root`. An
+// empty diff means that committing the transaction would not change anything.
+func (t *Transaction) Diff(root string) (string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, sf := range t.staged {
+		existing, err := os.ReadFile(sf.Filename)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		existed := err == nil
+		if sf.Remove && !existed {
+			continue
+		}
+
+		relative, err := filepath.Rel(root, sf.Filename)
+		if err != nil {
+			return "", err
+		}
+		relative = filepath.ToSlash(relative)
+
+		fromFile := "a/" + relative
+		if !existed {
+			fromFile = "/dev/null"
+		}
+		toFile := "b/" + relative
+		if sf.Remove {
+			toFile = "/dev/null"
+		}
+		ud := difflib.UnifiedDiff{
+			A:        splitLines(existing),
+			B:        splitLines(sf.Data),
+			FromFile: fromFile,
+			ToFile:   toFile,
+			Context:  3,
+		}
+		diff, err := difflib.GetUnifiedDiffString(ud)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(diff)
+	}
+
+	return b.String(), nil
+}
+
+// splitLines splits file contents into lines (each ending in `\n`) for
+// diffing; an empty file has no lines.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	last := len(lines) - 1
+	if lines[last] == "" {
+		return lines[:last]
+	}
+	lines[last] += "\n"
+	return lines
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestTransaction_Diff(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := tempDir(t, assert)
+	existing := filepath.Join(root, "existing.txt")
+	err := os.WriteFile(existing, []byte("a\nb\nc\n"), 0644)
+	assert.Nil(err)
+	unchanged := filepath.Join(root, "unchanged.txt")
+	err = os.WriteFile(unchanged, []byte("same\n"), 0644)
+	assert.Nil(err)
+
+	txn := npmmod.NewTransaction()
+	err = txn.WriteFile(existing, []byte("a\nB\nc\n"))
+	assert.Nil(err)
+	err = txn.WriteFile(unchanged, []byte("same\n"))
+	assert.Nil(err)
+	err = txn.WriteFile(filepath.Join(root, "created.txt"), []byte("new"))
+	assert.Nil(err)
+	err = txn.RemoveFile(unchanged)
+	assert.Nil(err)
+	err = txn.RemoveFile(filepath.Join(root, "missing.txt"))
+	assert.Nil(err)
+
+	diff, err := txn.Diff(root)
+	assert.Nil(err)
+	expected := `--- a/existing.txt
++++ b/existing.txt
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+--- a/unchanged.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-same
+--- /dev/null
++++ b/created.txt
+@@ -0,0 +1 @@
++new
+`
+	assert.Equal(expected, diff)
+
+	// Computing a diff doesn't write anything.
+	assert.Equal([]string{"existing.txt", "unchanged.txt"}, dirNames(assert, root))
+
+	txn = npmmod.NewTransaction()
+	err = txn.WriteFile(unchanged, []byte("same\n"))
+	assert.Nil(err)
+	diff, err = txn.Diff(root)
+	assert.Nil(err)
+	assert.Equal("", diff)
+}
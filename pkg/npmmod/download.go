@@ -16,50 +16,276 @@ package npmmod
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/concurrency"
 )
 
-// Fetch downloads a package from `npm`, validates the checksum and then
-// writes it to disk.
+// Fetcher downloads package archives from `npm`, retrying transient failures.
+//
+// The limits are optional; a `nil` limit means requests (or bytes) aren't
+// limited.
+type Fetcher struct {
+	Client *http.Client
+	Retry  RetryPolicy
+	// Hosts limits the number of concurrent requests to each host.
+	Hosts *concurrency.KeyedLimiter
+	// Requests limits the rate of requests (across all hosts), in requests
+	// per second.
+	Requests *concurrency.TokenBucket
+	// Bytes limits the rate at which response bodies are read (across all
+	// hosts), in bytes per second.
+	Bytes *concurrency.TokenBucket
+	// Rewrites are applied (the first match only) to a package archive URL
+	// before it is fetched.
+	Rewrites []Rewrite
+	// Mirrors are tried in order (for the URLs they match) if fetching the
+	// (rewritten) URL fails.
+	Mirrors []Rewrite
+}
+
+// NewFetcher creates a fetcher that uses the default HTTP client and the
+// default retry policy.
+func NewFetcher() *Fetcher {
+	return &Fetcher{Client: http.DefaultClient, Retry: DefaultRetryPolicy()}
+}
+
+// Fetch downloads a package from `npm` (with a default `Fetcher`), validates
+// the checksum and then writes it to disk.
 func Fetch(ctx context.Context, url, algorithm, hash, filename string) error {
-	data, err := download(ctx, url)
+	_, err := NewFetcher().Fetch(ctx, url, algorithm, hash, filename)
+	return err
+}
+
+// Fetch downloads a package from `npm`, validates the checksum and then
+// writes it to disk. It returns the URL that actually served the package
+// archive, i.e. `url` after rewrites or one of the mirrors.
+//
+// If fetching from one URL fails (after retries), including if the checksum
+// doesn't match, the next mirror is tried.
+func (f *Fetcher) Fetch(ctx context.Context, url, algorithm, hash, filename string) (string, error) {
+	candidates := candidateURLs(url, f.Rewrites, f.Mirrors)
+	errs := []error{}
+	for _, candidate := range candidates {
+		err := f.fetch(ctx, candidate, algorithm, hash, filename)
+		if err == nil {
+			return candidate, nil
+		}
+		if len(candidates) == 1 || ctx.Err() != nil {
+			return "", err
+		}
+
+		errs = append(errs, fmt.Errorf("%s; %w", candidate, err))
+	}
+
+	return "", maybeMultiError(errs...)
+}
+
+// fetch downloads a package archive from a single URL.
+//
+// The package archive is streamed to a temporary file in the same directory
+// as `filename` (hashing it along the way) and is only renamed into place
+// once the checksum is validated, so a failed or interrupted download never
+// leaves a partial file at `filename`. If a transient failure interrupts the
+// download, the retry resumes from the end of the temporary file with an HTTP
+// `Range` request.
+func (f *Fetcher) fetch(ctx context.Context, url, algorithm, hash, filename string) error {
+	h, err := newIntegrityHash(algorithm)
 	if err != nil {
 		return err
 	}
 
-	err = ValidateIntegrity(data, algorithm, hash)
+	dir, base := filepath.Split(filename)
+	w, err := os.CreateTemp(dir, "."+base+".npm-mod-*")
 	if err != nil {
 		return err
 	}
+	temporary := w.Name()
 
-	err = os.WriteFile(filename, data, 0644)
-	return err
+	err = f.download(ctx, url, w, h)
+	if err == nil {
+		err = w.Sync()
+	}
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temporary, defaultFileMode)
+	}
+	if err == nil {
+		err = validateDigest(algorithm, hash, h.Sum(nil))
+	}
+	if err == nil {
+		err = os.Rename(temporary, filename)
+	}
+	if err != nil {
+		return maybeMultiError(err, os.Remove(temporary))
+	}
+
+	return nil
+}
+
+// download writes the response body for `url` to `w` (and `h`), retrying
+// transient failures based on the retry policy.
+func (f *Fetcher) download(ctx context.Context, url string, w *os.File, h hash.Hash) error {
+	attempts := f.Retry.attempts()
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err := f.downloadOnce(ctx, url, w, h)
+		if err == nil {
+			return nil
+		}
+
+		var te *transientError
+		if !errors.As(err, &te) || attempt == attempts || ctx.Err() != nil {
+			return fmt.Errorf("%w (attempt %d of %d)", err, attempt, attempts)
+		}
+
+		d, delayErr := f.Retry.delay(attempt, te.RetryAfter)
+		if delayErr != nil {
+			return fmt.Errorf("%w; %v (attempt %d of %d)", err, delayErr, attempt, attempts)
+		}
+
+		err = sleep(ctx, d)
+		if err != nil {
+			return fmt.Errorf("%w (attempt %d of %d)", err, attempt, attempts)
+		}
+	}
+
+	return errors.New("retry loop never terminated")
 }
 
-func download(ctx context.Context, url string) ([]byte, error) {
+// downloadOnce makes a single request for `url`. If `w` already has part of
+// the response body (from an earlier attempt), only the remainder is
+// requested. Failures that are worth retrying are returned as a
+// `*transientError`.
+func (f *Fetcher) downloadOnce(ctx context.Context, url string, w *os.File, h hash.Hash) error {
+	offset, err := w.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	release, err := f.acquire(ctx, req.URL.Host)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer release()
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return classifyNetworkError(err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			err = fmt.Errorf("unexpected content range; %q", resp.Header.Get("Content-Range"))
+			return &transientError{Err: maybeMultiError(err, restart(w, h))}
+		}
+	} else if resp.StatusCode == http.StatusOK {
+		// NOTE: Either this is the first attempt or the server ignored the
+		//       `Range` header and is sending the whole package archive.
+		err = restart(w, h)
+		if err != nil {
+			return err
+		}
+	} else {
 		err = fmt.Errorf("request failed; response code: %d", resp.StatusCode)
-		return nil, err
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return &transientError{Err: maybeMultiError(err, restart(w, h))}
+		}
+		if isTransientStatus(resp.StatusCode) {
+			return &transientError{Err: err, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		}
+		return err
+	}
+
+	// NOTE: Only errors reading the response body are transient; an error
+	//       writing to disk (or waiting on the rate limit) is not.
+	var body io.Reader = transientReader{Reader: resp.Body}
+	if f.Bytes != nil {
+		body = &concurrency.RateLimitedReader{Context: ctx, Reader: body, Bucket: f.Bytes}
 	}
+	_, err = io.Copy(io.MultiWriter(w, h), body)
+	return err
+}
 
-	data, err := io.ReadAll(resp.Body)
+// acquire waits until a request can be sent to `host`, based on the
+// per-host and request rate limits. The returned function must be called
+// once the response has been read.
+func (f *Fetcher) acquire(ctx context.Context, host string) (func(), error) {
+	release := func() {}
+	if f.Hosts != nil {
+		var err error
+		release, err = f.Hosts.Acquire(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Requests != nil {
+		err := f.Requests.Wait(ctx, 1)
+		if err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
+
+// restart discards a partial download so that the package archive can be
+// downloaded from the start.
+func restart(w *os.File, h hash.Hash) error {
+	h.Reset()
+	err := w.Truncate(0)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	_, err = w.Seek(0, io.SeekStart)
+	return err
+}
+
+// classifyNetworkError marks an error from sending a request as transient,
+// unless it is certain that retrying won't help (e.g. the host doesn't
+// exist).
+func classifyNetworkError(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return err
 	}
+	return &transientError{Err: err}
+}
+
+// transientReader marks every error (other than `io.EOF`) from reading the
+// wrapped reader as transient, e.g. a connection reset while reading a
+// response body.
+type transientReader struct {
+	Reader io.Reader
+}
 
-	return data, nil
+// Read reads from the wrapped reader.
+func (tr transientReader) Read(p []byte) (int, error) {
+	n, err := tr.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		return n, &transientError{Err: err}
+	}
+	return n, err
 }
@@ -17,14 +17,18 @@ package npmmod_test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	testifyassert "github.com/stretchr/testify/assert"
 
+	"github.com/hardfinhq/npm-mod/pkg/concurrency"
 	"github.com/hardfinhq/npm-mod/pkg/npmmod"
 )
 
@@ -73,3 +77,229 @@ func TestFetch(t *testing.T) {
 	assert.Nil(err)
 	assert.True(bytes.Equal(expected, data))
 }
+
+func TestFetcher_Fetch(outer *testing.T) {
+	outer.Parallel()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "builtins-1.0.3.tgz"))
+	if err != nil {
+		outer.Fatal(err)
+	}
+	half := len(data) / 2
+
+	cases := []struct {
+		Name   string
+		Faults []fault
+		Hash   string
+		Error  string
+		Ranges []string
+		// Delay is the least time the fetch should take, i.e. the delay
+		// requested with `Retry-After`.
+		Delay time.Duration
+	}{
+		{
+			Name:   "bad gateway",
+			Faults: []fault{{Status: http.StatusBadGateway}, {Status: http.StatusBadGateway}},
+			Ranges: []string{"", "", ""},
+		},
+		{
+			Name:   "too many requests",
+			Faults: []fault{{Status: http.StatusTooManyRequests, RetryAfter: "1"}},
+			Ranges: []string{"", ""},
+			Delay:  time.Second,
+		},
+		{
+			Name:   "retry after too long",
+			Faults: []fault{{Status: http.StatusTooManyRequests, RetryAfter: "3600"}},
+			Error:  "request failed; response code: 429; server asked to retry after 1h0m0s, longer than the limit of 2s (attempt 1 of 3)",
+			Ranges: []string{""},
+		},
+		{
+			Name:   "resumed",
+			Faults: []fault{{Truncate: true}, {Truncate: true}},
+			Ranges: []string{"", fmt.Sprintf("bytes=%d-", half), fmt.Sprintf("bytes=%d-", half+(len(data)-half)/2)},
+		},
+		{
+			Name:   "range ignored",
+			Faults: []fault{{Truncate: true}, {IgnoreRange: true}},
+			Ranges: []string{"", fmt.Sprintf("bytes=%d-", half)},
+		},
+		{
+			Name:   "service unavailable",
+			Faults: []fault{{Status: http.StatusServiceUnavailable}, {Status: http.StatusServiceUnavailable}, {Status: http.StatusServiceUnavailable}},
+			Error:  "request failed; response code: 503 (attempt 3 of 3)",
+			Ranges: []string{"", "", ""},
+		},
+		{
+			Name:   "truncated",
+			Faults: []fault{{Truncate: true, IgnoreRange: true}, {Truncate: true, IgnoreRange: true}, {Truncate: true, IgnoreRange: true}},
+			Error:  "unexpected EOF (attempt 3 of 3)",
+			Ranges: []string{"", fmt.Sprintf("bytes=%d-", half), fmt.Sprintf("bytes=%d-", half)},
+		},
+		{
+			Name:   "not found",
+			Faults: []fault{{Status: http.StatusNotFound}},
+			Error:  "request failed; response code: 404 (attempt 1 of 3)",
+			Ranges: []string{""},
+		},
+		{
+			Name:   "mismatch",
+			Hash:   "AAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+			Error:  "sha1 hashes do not match; expected: AAAAAAAAAAAAAAAAAAAAAAAAAAA=; actual: y5T662HIaWRR2zZTThQi+U8K7og=",
+			Ranges: []string{""},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			fs := &faultServer{Data: data, Faults: tc.Faults}
+			server := httptest.NewServer(http.HandlerFunc(fs.ServeHTTP))
+			t.Cleanup(server.Close)
+
+			hash := tc.Hash
+			if hash == "" {
+				hash = "y5T662HIaWRR2zZTThQi+U8K7og="
+			}
+			f := npmmod.NewFetcher()
+			f.Retry = npmmod.RetryPolicy{Attempts: 3, InitialDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, MaxRetryAfter: 2 * time.Second}
+			destination := tempDir(t, assert)
+			filename := filepath.Join(destination, "builtins-1.0.3.tgz")
+			url := server.URL + "/builtins/-/builtins-1.0.3.tgz"
+			start := time.Now()
+			_, err := f.Fetch(context.TODO(), url, "sha1", hash, filename)
+			// NOTE: `Retry-After` is honored even though it is longer than
+			//       `MaxDelay`.
+			assert.GreaterOrEqual(time.Since(start), tc.Delay)
+			assert.Equal(tc.Ranges, fs.Ranges)
+			if tc.Error != "" {
+				// Neither the archive nor a temporary file is left behind.
+				assert.Equal(tc.Error, fmt.Sprintf("%v", err))
+				assert.Equal([]string{}, dirNames(assert, destination))
+				return
+			}
+
+			assert.Nil(err)
+			assert.Equal([]string{"builtins-1.0.3.tgz"}, dirNames(assert, destination))
+			actual, err := os.ReadFile(filename)
+			assert.Nil(err)
+			assert.True(bytes.Equal(data, actual))
+		})
+	}
+}
+
+func TestFetcher_Fetch_limits(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	data, err := os.ReadFile(filepath.Join("testdata", "builtins-1.0.3.tgz"))
+	assert.Nil(err)
+
+	// NOTE: Track the most requests the server is handling at once.
+	mutex := sync.Mutex{}
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write(data)
+
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	f := npmmod.NewFetcher()
+	f.Hosts = concurrency.NewKeyedLimiter(1)
+	f.Bytes, err = concurrency.NewTokenBucket(float64(2*len(data)), 0)
+	assert.Nil(err)
+	destination := tempDir(t, assert)
+
+	wg := sync.WaitGroup{}
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			filename := filepath.Join(destination, fmt.Sprintf("builtins-%d.tgz", i))
+			_, errs[i] = f.Fetch(context.TODO(), server.URL, "sha1", "y5T662HIaWRR2zZTThQi+U8K7og=", filename)
+		}(i)
+	}
+
+	// NOTE: The first two package archives are covered by the (full) token
+	//       bucket, the other two take half a second each.
+	start := time.Now()
+	wg.Wait()
+	assert.Equal([]error{nil, nil, nil, nil}, errs)
+	assert.Equal(1, maxInFlight)
+	assert.GreaterOrEqual(time.Since(start), time.Second)
+}
+
+// fault describes how `faultServer` should fail a single request.
+type fault struct {
+	// Status is the (error) response code, if any.
+	Status     int
+	RetryAfter string
+	// Truncate determines if the connection should be closed after sending
+	// half of the (requested part of the) body.
+	Truncate bool
+	// IgnoreRange determines if a `Range` header should be ignored, i.e. if
+	// the whole body should be sent.
+	IgnoreRange bool
+}
+
+// faultServer serves `Data` (honoring `Range` headers), injecting the n-th
+// fault into the n-th request. It records the `Range` header of each
+// request.
+type faultServer struct {
+	Data   []byte
+	Faults []fault
+	Ranges []string
+	mutex  sync.Mutex
+}
+
+func (fs *faultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mutex.Lock()
+	f := fault{}
+	if len(fs.Ranges) < len(fs.Faults) {
+		f = fs.Faults[len(fs.Ranges)]
+	}
+	fs.Ranges = append(fs.Ranges, r.Header.Get("Range"))
+	fs.mutex.Unlock()
+
+	if f.Status != 0 {
+		if f.RetryAfter != "" {
+			w.Header().Set("Retry-After", f.RetryAfter)
+		}
+		http.Error(w, "", f.Status)
+		return
+	}
+
+	start := 0
+	if r.Header.Get("Range") != "" && !f.IgnoreRange {
+		_, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+		if err != nil {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+	}
+
+	body := fs.Data[start:]
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	if start > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(fs.Data)-1, len(fs.Data)))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if f.Truncate {
+		body = body[:len(body)/2]
+	}
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,249 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+	"github.com/hardfinhq/npm-mod/pkg/semver"
+)
+
+// NOTE: Ensure that
+//       * `FindRegistryResolved{}.Visit` satisfies `VisitorFunc`.
+//       * `FindRegistryRanges{}.Visit` satisfies `VisitorFunc`.
+var (
+	_ VisitorFunc = (&FindRegistryResolved{}).Visit
+	_ VisitorFunc = (&FindRegistryRanges{}).Visit
+)
+
+const (
+	fixTidy       = "npm-mod tidy"
+	fixTidyVendor = "npm-mod tidy && npm-mod vendor"
+)
+
+// Drift describes a way in which `package.json` or `package-lock.json` no
+// longer agree with `.npm-mod.tidy.json` (e.g. after a plain `npm install`),
+// along with the command that fixes it.
+type Drift struct {
+	File    string `json:"file"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+	Fix     string `json:"fix"`
+}
+
+// String describes the drift, e.g.
+// `package.json "react": "^18.0.0" is not vendored (fix: npm-mod tidy)`.
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %q: %s (fix: %s)", d.File, d.Subject, d.Message, d.Fix)
+}
+
+// FindDrift compares `package.json` and `package-lock.json` to the tidy file
+// and describes every
+//
+//   - package in `package-lock.json` resolved from the registry rather than
+//     `vendor/`
+//   - version range in `package.json` that is not vendored
+//   - `file:vendor/...` reference that is not tracked in the tidy file
+//   - package archive in the tidy file that neither manifest refers to
+//
+// In `ModeRegistry`, a package in `package-lock.json` is expected to be
+// resolved from its mirrored URL (and version ranges are expected), so only
+// packages resolved from anywhere else are described. In `ModePin`, only the
+// version ranges in `package.json` that are not pinned are described.
+func FindDrift(tf *TidyFile, packageJSON, packageLock *ordered.OrderedMap) ([]Drift, error) {
+	if tf.RegistryMode() {
+		return findMirrorDrift(tf, packageLock)
+	}
+	if tf.PinMode() {
+		return findPinDrift(packageJSON, packageLock)
+	}
+
+	drift := []Drift{}
+
+	frr := FindRegistryResolved{}
+	err := walkPackageLockPackages(packageLock, frr.Visit)
+	if err != nil {
+		return nil, err
+	}
+	err = walkPackageLockDependencies(packageLock, frr.Visit)
+	if err != nil {
+		return nil, err
+	}
+	registryURLs := map[string]bool{}
+	for _, u := range frr.Resolved {
+		registryURLs[u.Specifier] = true
+		message := fmt.Sprintf("is resolved from the registry (%s)", u.Specifier)
+		drift = append(drift, Drift{File: u.File, Subject: u.Name, Message: message, Fix: fixTidyVendor})
+	}
+
+	frg := FindRegistryRanges{}
+	for _, key := range packageJSONDependencyKeys {
+		err = walkPackageJSON(packageJSON, key, frg.Visit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, u := range frg.Ranges {
+		message := fmt.Sprintf("%q is not vendored", u.Specifier)
+		drift = append(drift, Drift{File: u.File, Subject: u.Name, Message: message, Fix: fixTidy})
+	}
+
+	pjFilenames, err := PackageJSONVendoredFilenames(packageJSON)
+	if err != nil {
+		return nil, err
+	}
+	plFilenames, err := PackageLockVendoredFilenames(packageLock)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := TrackedFilenames(tf.Packages)
+	for _, file := range []string{"package.json", "package-lock.json"} {
+		filenames := pjFilenames
+		if file == "package-lock.json" {
+			filenames = plFilenames
+		}
+		for _, filename := range sortedKeys(filenames) {
+			if tracked[filename] {
+				continue
+			}
+			message := "is not tracked in .npm-mod.tidy.json"
+			drift = append(drift, Drift{File: file, Subject: vendorPrefix + filename, Message: message, Fix: fixTidy})
+		}
+	}
+
+	for _, p := range tf.Packages {
+		// NOTE: A package that is resolved from the registry again has
+		//       already been reported above.
+		if pjFilenames[p.Archive] || plFilenames[p.Archive] || registryURLs[p.URL] {
+			continue
+		}
+		message := "is not referenced by package.json or package-lock.json"
+		drift = append(drift, Drift{File: ".npm-mod.tidy.json", Subject: p.Archive, Message: message, Fix: fixTidy})
+	}
+
+	return drift, nil
+}
+
+// findMirrorDrift describes every package in `package-lock.json` that is not
+// resolved from its mirrored URL and every package in the tidy file that
+// `package-lock.json` doesn't refer to.
+func findMirrorDrift(tf *TidyFile, packageLock *ordered.OrderedMap) ([]Drift, error) {
+	drift := []Drift{}
+
+	frr := FindRegistryResolved{}
+	err := walkPackageLockPackages(packageLock, frr.Visit)
+	if err != nil {
+		return nil, err
+	}
+	err = walkPackageLockDependencies(packageLock, frr.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	byMirrored := packagesByMirrored(tf.Packages)
+	referenced := map[string]bool{}
+	for _, u := range frr.Resolved {
+		if original, ok := byMirrored[u.Specifier]; ok {
+			referenced[original] = true
+			continue
+		}
+		// NOTE: A package that is resolved from its original URL again is
+		//       not reported as unreferenced below.
+		referenced[u.Specifier] = true
+		message := fmt.Sprintf("is not resolved from the mirror %s (%s)", tf.Mirror, u.Specifier)
+		drift = append(drift, Drift{File: u.File, Subject: u.Name, Message: message, Fix: fixTidy})
+	}
+
+	for _, p := range tf.Packages {
+		if referenced[p.URL] {
+			continue
+		}
+		message := "is not referenced by package-lock.json"
+		drift = append(drift, Drift{File: ".npm-mod.tidy.json", Subject: p.URL, Message: message, Fix: fixTidy})
+	}
+
+	return drift, nil
+}
+
+// FindRegistryResolved produces a visitor function that collects the packages
+// in either the `package-lock.json` packages or dependencies map that are
+// resolved from the registry. Each URL is only recorded once, even if it is
+// resolved more than once in the package lock.
+type FindRegistryResolved struct {
+	Resolved []Unmatched
+}
+
+// Visit is a visitor function that **collects** a package with a registry
+// (i.e. `http://` or `https://`) `resolved` URL.
+func (frr *FindRegistryResolved) Visit(_ *ordered.OrderedMap, k string, v any) error {
+	name := k
+	if name == "" {
+		return nil
+	}
+
+	m, ok := v.(*ordered.OrderedMap)
+	if !ok {
+		return fmt.Errorf("package %q does not point at a map", name)
+	}
+
+	resolved, _ := m.Get("resolved").(string)
+	if !strings.HasPrefix(resolved, "https://") && !strings.HasPrefix(resolved, "http://") {
+		return nil
+	}
+
+	for _, u := range frr.Resolved {
+		if u.Specifier == resolved {
+			return nil
+		}
+	}
+
+	u := Unmatched{File: "package-lock.json", Name: name, Specifier: resolved}
+	frr.Resolved = append(frr.Resolved, u)
+	return nil
+}
+
+// FindRegistryRanges produces a visitor function that collects the
+// dependencies in `package.json` that have a version range (rather than a
+// `file:vendor/...` reference), i.e. that will be installed from the
+// registry.
+type FindRegistryRanges struct {
+	Ranges []Unmatched
+}
+
+// Visit is a visitor function that **collects** a dependency with a version
+// range. Other specifiers (e.g. `file:`, `git+` or dist-tags like `latest`)
+// are not vendored by `npm-mod tidy` and are ignored.
+func (frg *FindRegistryRanges) Visit(_ *ordered.OrderedMap, k string, v any) error {
+	packageName := k
+	packageVersion, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("dependency %q is not a string", packageName)
+	}
+
+	if strings.HasPrefix(packageVersion, "file:") {
+		return nil
+	}
+	_, err := semver.ParseRange(rangeFromSpecifier(packageVersion))
+	if err != nil {
+		return nil
+	}
+
+	u := Unmatched{File: "package.json", Name: packageName, Specifier: packageVersion}
+	frg.Ranges = append(frg.Ranges, u)
+	return nil
+}
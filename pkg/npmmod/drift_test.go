@@ -0,0 +1,80 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+)
+
+func TestFindDrift(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := copyProject(t, assert, "project")
+	_ = tidyProject(assert, root)
+
+	tf, err := npmmod.ReadTidyFile(root)
+	assert.Nil(err)
+	pj := readProjectJSON(assert, root, "package.json")
+	pl := readProjectJSON(assert, root, "package-lock.json")
+	drift, err := npmmod.FindDrift(tf, pj, pl)
+	assert.Nil(err)
+	assert.Equal([]npmmod.Drift{}, drift)
+
+	// Simulate `npm install left-pad` (which resolves `shebang-regex` from
+	// the registry again) and a hand-edited reference to an archive that
+	// was never tidied.
+	deps := pj.Get("dependencies").(*ordered.OrderedMap)
+	deps.Set("left-pad", "^1.3.0")
+	devDeps := pj.Get("devDependencies").(*ordered.OrderedMap)
+	devDeps.Set("shebang-regex", "file:vendor/shebang-regex-3.0.1.tgz")
+	packages := pl.Get("packages").(*ordered.OrderedMap)
+	shebangRegex := packages.Get("node_modules/shebang-regex").(*ordered.OrderedMap)
+	shebangRegex.Set("resolved", "https://registry.npmjs.org/shebang-regex/-/shebang-regex-3.0.0.tgz")
+	legacy := pl.Get("dependencies").(*ordered.OrderedMap)
+	shebangRegex = legacy.Get("shebang-regex").(*ordered.OrderedMap)
+	shebangRegex.Set("resolved", "https://registry.npmjs.org/shebang-regex/-/shebang-regex-3.0.0.tgz")
+	// Simulate removing `builtins` from `package-lock.json` by hand.
+	packages.Delete("node_modules/builtins")
+	legacy.Delete("builtins")
+	deps.Delete("builtins")
+
+	drift, err = npmmod.FindDrift(tf, pj, pl)
+	assert.Nil(err)
+	expected := []string{
+		`package-lock.json "node_modules/shebang-regex": is resolved from the registry (https://registry.npmjs.org/shebang-regex/-/shebang-regex-3.0.0.tgz) (fix: npm-mod tidy && npm-mod vendor)`,
+		`package.json "left-pad": "^1.3.0" is not vendored (fix: npm-mod tidy)`,
+		`package.json "file:vendor/shebang-regex-3.0.1.tgz": is not tracked in .npm-mod.tidy.json (fix: npm-mod tidy)`,
+		`.npm-mod.tidy.json "builtins-1.0.3.tgz": is not referenced by package.json or package-lock.json (fix: npm-mod tidy)`,
+	}
+	actual := []string{}
+	for _, d := range drift {
+		actual = append(actual, d.String())
+	}
+	assert.Equal(expected, actual)
+}
+
+func readProjectJSON(assert *testifyassert.Assertions, root, name string) *ordered.OrderedMap {
+	data, err := os.ReadFile(filepath.Join(root, name))
+	assert.Nil(err)
+	return parseJSON(assert, string(data))
+}
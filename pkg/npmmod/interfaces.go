@@ -24,7 +24,17 @@ import (
 type VisitorFunc func(m *ordered.OrderedMap, k string, v any) error
 
 // ReplacePairFunc replaces a value based on the existing key/value pair.
-type ReplacePairFunc func(key, value string) string
+type ReplacePairFunc func(key, value string) (string, error)
 
 // ReplaceFunc replaces a value based on the value.
-type ReplaceFunc func(value string) string
+type ReplaceFunc func(value string) (string, error)
+
+// FileWriter writes a file, e.g. by staging it in a `Transaction`.
+type FileWriter interface {
+	WriteFile(filename string, data []byte) error
+}
+
+// FileRemover removes a file, e.g. by staging the removal in a `Transaction`.
+type FileRemover interface {
+	RemoveFile(filename string) error
+}
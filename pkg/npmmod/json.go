@@ -33,3 +33,71 @@ func marshalWithoutHTMLEscape(m *ordered.OrderedMap) ([]byte, error) {
 
 	return b.Bytes(), nil
 }
+
+// JSONFormat describes how a JSON file is formatted (beyond its contents), so
+// that it can be written back byte-for-byte. A `nil` format is the one npm
+// uses by default, i.e. two space indents, LF line endings and a final newline.
+type JSONFormat struct {
+	// Indent is the indent of each level; it is empty if the file is on a
+	// single line.
+	Indent         string `json:"indent"`
+	CRLF           bool   `json:"crlf,omitempty"`
+	NoFinalNewline bool   `json:"no-final-newline,omitempty"`
+}
+
+// DetectJSONFormat determines how a JSON file is formatted from the indent of
+// its second line and its line endings. This mirrors how npm determines the
+// format when it rewrites a `package.json` or `package-lock.json`.
+func DetectJSONFormat(data []byte) *JSONFormat {
+	f := JSONFormat{Indent: "  "}
+	f.NoFinalNewline = !bytes.HasSuffix(data, []byte("\n"))
+	f.CRLF = bytes.Contains(data, []byte("\r\n"))
+
+	trimmed := bytes.TrimRight(data, "\r\n")
+	i := bytes.IndexByte(trimmed, '\n')
+	if i < 0 {
+		f.Indent = ""
+	} else {
+		line := trimmed[i+1:]
+		indent := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+		// NOTE: The second line of an empty object (i.e. `{\n}`) has no
+		//       indent, so the default is kept.
+		if len(indent) > 0 {
+			f.Indent = string(indent)
+		}
+	}
+
+	if f == (JSONFormat{Indent: "  "}) {
+		return nil
+	}
+	return &f
+}
+
+// marshal serializes `m` in this format.
+func (f *JSONFormat) marshal(m *ordered.OrderedMap) ([]byte, error) {
+	if f == nil {
+		return marshalWithoutHTMLEscape(m)
+	}
+
+	var b bytes.Buffer
+	je := json.NewEncoder(&b)
+	je.SetEscapeHTML(false)
+	if f.Indent != "" {
+		je.SetIndent("", f.Indent)
+	}
+	err := je.Encode(m)
+	if err != nil {
+		return nil, err
+	}
+
+	asJSON := b.Bytes()
+	if f.NoFinalNewline {
+		asJSON = bytes.TrimSuffix(asJSON, []byte("\n"))
+	}
+	if f.CRLF {
+		// NOTE: Newlines within strings are always escaped, so every newline
+		//       is a line ending.
+		asJSON = bytes.ReplaceAll(asJSON, []byte("\n"), []byte("\r\n"))
+	}
+	return asJSON, nil
+}
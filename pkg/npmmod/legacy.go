@@ -0,0 +1,132 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+)
+
+// NOTE: Ensure that
+//       * `legacyReplace{}.ReplacePair` satisfies `ReplacePairFunc`.
+//       * `legacyReplace{}.Replace` satisfies `ReplaceFunc`.
+var (
+	_ ReplacePairFunc = (&legacyReplace{}).ReplacePair
+	_ ReplaceFunc     = (&legacyReplace{}).Replace
+)
+
+var (
+	// legacyPackageJSONDependencyKeys are the `package.json` dependency maps
+	// that were tidied with a 22.05 `.npm-mod.tidy.json`.
+	legacyPackageJSONDependencyKeys = []string{"dependencies", "devDependencies", "peerDependencies"}
+)
+
+// legacyTidy reproduces the `package.json` and `package-lock.json` that
+// `npm-mod tidy` wrote along with a 22.05 `.npm-mod.tidy.json`. These rules
+// differ from the current ones, e.g. every matching dependency was replaced
+// regardless of its version range and the `version` of every package in the
+// `package-lock.json` was replaced along with the `resolved` URL.
+func legacyTidy(packageJSON, packageLock []byte) ([]byte, []byte, error) {
+	pj := ordered.NewOrderedMap()
+	err := json.Unmarshal(packageJSON, &pj)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pl := ordered.NewOrderedMap()
+	err = json.Unmarshal(packageLock, &pl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byNodeModulesPath, byURL, err := PackageLockExtractDependencies(pl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lr := legacyReplace{ByNodeModulesPath: byNodeModulesPath, ByURL: byURL}
+	rd := ReplaceDependency{Replace: lr.ReplacePair}
+	for _, key := range legacyPackageJSONDependencyKeys {
+		err = walkPackageJSON(pj, key, rd.Visit)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rr := ReplaceResolved{Replace: lr.Replace, ParentKey: "packages"}
+	err = walkPackageLockPackages(pl, rr.Visit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rr = ReplaceResolved{Replace: lr.Replace, ParentKey: "dependencies"}
+	err = walkPackageLockDependencies(pl, rr.Visit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tidiedJSON, err := marshalWithoutHTMLEscape(pj)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tidiedLock, err := marshalWithoutHTMLEscape(pl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tidiedJSON, tidiedLock, nil
+}
+
+// legacyReplace provides the `replace` helpers used by `npm-mod tidy` with a
+// 22.05 `.npm-mod.tidy.json`. Anything that can't be matched is left as-is.
+type legacyReplace struct {
+	ByNodeModulesPath map[string]RegistryPackage
+	ByURL             map[string]RegistryPackage
+}
+
+// ReplacePair replaces a `package.json` dependency with a `file:vendor/...`
+// reference if the package is in the `node_modules/` directory at the top
+// level (without checking the version range).
+func (lr *legacyReplace) ReplacePair(name, version string) (string, error) {
+	rp, ok := lr.ByNodeModulesPath[fmt.Sprintf("node_modules/%s", name)]
+	if !ok {
+		return version, nil
+	}
+
+	filename, err := rp.Filename()
+	if err != nil {
+		return version, nil
+	}
+
+	return vendorPrefix + filename, nil
+}
+
+// Replace replaces a `resolved` URL with a `file:vendor/...` reference.
+func (lr *legacyReplace) Replace(resolved string) (string, error) {
+	rp, ok := lr.ByURL[resolved]
+	if !ok {
+		return resolved, nil
+	}
+
+	filename, err := rp.Filename()
+	if err != nil {
+		return resolved, nil
+	}
+
+	return vendorPrefix + filename, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	lockFilename = ".npm-mod.lock"
+)
+
+// Lock acquires an advisory lock on the project in `root` so that concurrent
+// `npm-mod` invocations don't interleave their writes. The lock is a
+// `.npm-mod.lock` file (containing the PID of the holder) that is created
+// exclusively; the returned function releases the lock by removing it.
+func Lock(root string) (func() error, error) {
+	filename := filepath.Join(root, lockFilename)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, defaultFileMode)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, lockHeldError(filename)
+		}
+		return nil, err
+	}
+
+	_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, maybeMultiError(err, os.Remove(filename))
+	}
+
+	release := func() error {
+		return os.Remove(filename)
+	}
+	return release, nil
+}
+
+func lockHeldError(filename string) error {
+	holder := "another process"
+	data, err := os.ReadFile(filename)
+	if err == nil && len(data) > 0 {
+		holder = fmt.Sprintf("process %s", strings.TrimSpace(string(data)))
+	}
+
+	return fmt.Errorf("project is locked by %s; if no other npm-mod is running, remove %s", holder, filename)
+}
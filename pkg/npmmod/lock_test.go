@@ -0,0 +1,52 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestLock(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := tempDir(t, assert)
+	release, err := npmmod.Lock(root)
+	assert.Nil(err)
+
+	filename := filepath.Join(root, ".npm-mod.lock")
+	again, err := npmmod.Lock(root)
+	assert.Nil(again)
+	assert.NotNil(err)
+	expected := fmt.Sprintf("project is locked by process %d; if no other npm-mod is running, remove %s", os.Getpid(), filename)
+	assert.Equal(expected, fmt.Sprintf("%v", err))
+
+	err = release()
+	assert.Nil(err)
+	_, err = os.Stat(filename)
+	assert.True(os.IsNotExist(err))
+
+	release, err = npmmod.Lock(root)
+	assert.Nil(err)
+	err = release()
+	assert.Nil(err)
+}
@@ -0,0 +1,301 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+)
+
+const (
+	manifestFilename = "npm-mod.txt"
+)
+
+// ManifestEntry describes a single package archive in `vendor/npm-mod.txt`,
+// i.e. why the archive is in `vendor/`.
+type ManifestEntry struct {
+	Filename  string
+	Name      string
+	Version   string
+	Integrity string
+	// Paths are the locations of the package in the `package-lock.json`
+	// packages map.
+	Paths []string
+	// RequiredBy are the top-level dependencies (i.e. the dependencies in
+	// `package.json`) that pull in the package.
+	RequiredBy []string
+}
+
+// NewManifest describes every package archive in a `.npm-mod.tidy.json`,
+// using the `package-lock.json` to determine the top-level dependencies that
+// pull in each package.
+func NewManifest(tf *TidyFile, packageLock *ordered.OrderedMap) ([]ManifestEntry, error) {
+	byLocation, err := requiredBy(packageLock)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ManifestEntry, len(tf.Packages))
+	for i, p := range tf.Packages {
+		names := map[string]bool{}
+		for _, location := range p.Paths {
+			for _, name := range byLocation[location] {
+				names[name] = true
+			}
+		}
+
+		entries[i] = ManifestEntry{
+			Filename:   p.Archive,
+			Name:       p.Name,
+			Version:    p.Version,
+			Integrity:  p.Algorithm + "-" + p.Hash,
+			Paths:      p.Paths,
+			RequiredBy: sortedKeys(names),
+		}
+	}
+
+	return entries, nil
+}
+
+// FormatManifest serializes the entries of `vendor/npm-mod.txt`. Each archive
+// has a `#` line followed by a `##` line for each path and each top-level
+// dependency that pulls it in, e.g.
+//
+//	# b-1.0.3.tgz b 1.0.3 sha512-...
+//	## path node_modules/b
+//	## required-by @s/d
+//	## required-by a
+func FormatManifest(entries []ManifestEntry) []byte {
+	var b bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&b, "# %s %s %s %s\n", e.Filename, e.Name, e.Version, e.Integrity)
+		for _, location := range e.Paths {
+			fmt.Fprintf(&b, "## path %s\n", location)
+		}
+		for _, name := range e.RequiredBy {
+			fmt.Fprintf(&b, "## required-by %s\n", name)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// ParseManifest parses the contents of a `vendor/npm-mod.txt` file.
+func ParseManifest(data []byte) ([]ManifestEntry, error) {
+	entries := []ManifestEntry{}
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch {
+		case fields[0] == "#" && len(fields) == 5:
+			e := ManifestEntry{Filename: fields[1], Name: fields[2], Version: fields[3], Integrity: fields[4]}
+			entries = append(entries, e)
+			continue
+		case fields[0] == "##" && len(fields) == 3 && len(entries) > 0:
+			e := &entries[len(entries)-1]
+			switch fields[1] {
+			case "path":
+				e.Paths = append(e.Paths, fields[2])
+				continue
+			case "required-by":
+				e.RequiredBy = append(e.RequiredBy, fields[2])
+				continue
+			}
+		}
+
+		return nil, fmt.Errorf("vendor/%s:%d: unexpected line; %q", manifestFilename, i+1, line)
+	}
+
+	return entries, nil
+}
+
+// ReadManifest reads and parses `vendor/npm-mod.txt` in `root`.
+func ReadManifest(root string) ([]ManifestEntry, error) {
+	data, err := os.ReadFile(filepath.Join(root, "vendor", manifestFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseManifest(data)
+}
+
+// PersistManifest writes `vendor/npm-mod.txt` via a file writer.
+func PersistManifest(w FileWriter, root string, entries []ManifestEntry) error {
+	target := filepath.Join(root, "vendor", manifestFilename)
+	return w.WriteFile(target, FormatManifest(entries))
+}
+
+// CheckVendorDir checks that the package archives in `vendor/` are exactly
+// the ones described by the manifest entries. As with `FindOrphans()`, only
+// regular files that `IsArchiveFilename()` recognizes are considered.
+func CheckVendorDir(root string, entries []ManifestEntry) error {
+	dirEntries, err := os.ReadDir(filepath.Join(root, "vendor"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	present := map[string]bool{}
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && IsArchiveFilename(name) {
+			present[name] = true
+		}
+	}
+
+	problems := []string{}
+	for _, e := range entries {
+		if !present[e.Filename] {
+			problems = append(problems, fmt.Sprintf("missing %s", e.Filename))
+		}
+		delete(present, e.Filename)
+	}
+	for _, filename := range sortedKeys(present) {
+		problems = append(problems, fmt.Sprintf("unexpected %s", filename))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	summary := fmt.Sprintf("vendor/ does not agree with vendor/%s; run npm-mod vendor --prune to fetch the missing archives and remove the unexpected ones", manifestFilename)
+	return &ProblemsError{Summary: summary, Problems: problems}
+}
+
+// CheckVendorManifest checks that the package archives in `vendor/` are
+// exactly the ones described by the existing `vendor/npm-mod.txt` in `root`
+// (if there is one), i.e. that `vendor/` has not been changed by hand since
+// the last `npm-mod vendor`.
+func CheckVendorManifest(root string) error {
+	entries, err := ReadManifest(root)
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return CheckVendorDir(root, entries)
+}
+
+// requiredBy determines the top-level dependencies (i.e. the dependencies of
+// the root package) that pull in the package at each location in the
+// `package-lock.json` packages map.
+func requiredBy(packageLock *ordered.OrderedMap) (map[string][]string, error) {
+	packages, err := lockPackages(packageLock)
+	if err != nil || packages == nil {
+		return map[string][]string{}, err
+	}
+
+	root, ok := packages.Get("").(*ordered.OrderedMap)
+	if !ok {
+		return nil, errors.New(`package-lock.json has no root package (i.e. packages[""])`)
+	}
+
+	names, err := dependencyNames(root, packageJSONDependencyKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	byLocation := map[string][]string{}
+	for _, name := range names {
+		location, _, ok := lookupPackage(packages, "", name)
+		if !ok {
+			continue
+		}
+
+		err = visitRequired(packages, location, func(current string) {
+			byLocation[current] = append(byLocation[current], name)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return byLocation, nil
+}
+
+// visitRequired calls `visit` for the package at `location` and for every
+// package it (transitively) depends on.
+func visitRequired(packages *ordered.OrderedMap, location string, visit func(string)) error {
+	seen := map[string]bool{location: true}
+	queue := []string{location}
+	// NOTE: Use a bounded for loop to avoid an accidental infinite loop.
+	loopComplete := false
+	for i := 0; i < 10000; i++ {
+		if len(queue) == 0 {
+			loopComplete = true
+			break
+		}
+
+		current := queue[0]
+		queue = queue[1:]
+		visit(current)
+
+		m, ok := packages.Get(current).(*ordered.OrderedMap)
+		if !ok {
+			continue
+		}
+		names, err := dependencyNames(m, lockDependencyKeys)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			next, _, ok := lookupPackage(packages, current, name)
+			if ok && !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	if !loopComplete {
+		return errors.New("loop over required packages never terminated")
+	}
+
+	return nil
+}
+
+// dependencyNames collects the (sorted) names in the given dependencies maps
+// of a package.
+func dependencyNames(m *ordered.OrderedMap, keys []string) ([]string, error) {
+	names := []string{}
+	for _, key := range keys {
+		ds, err := dependencyMap(m, key)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, ds.sortedNames()...)
+	}
+
+	return names, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,154 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestNewManifest(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := filepath.Join("testdata", "ranges")
+	tf, err := npmmod.GenerateTidyFile(root)
+	assert.Nil(err)
+	pl, err := npmmod.ReadPackageLock(root)
+	assert.Nil(err)
+
+	entries, err := npmmod.NewManifest(tf, pl)
+	assert.Nil(err)
+	actual := npmmod.FormatManifest(entries)
+	expected := `# s__d-2.1.4.tgz @s/d 2.1.4 sha512-IhMomAcGBLoaqQET6WoOoi857ZISGHFovvsFO6toDL5O9pXPNR9Po0fqEk5QpB6cBVR7K/uTbUUbYwgR1O22Zg==
+## path node_modules/@s/d
+## required-by @s/d
+# a-1.0.0.tgz a 1.0.0 sha512-PVI80fWGB7RY0avAfAm2zTA3S0APdq/E9HpGfAiyg4kKTEoQWWWyyKUFzwjtJP+h6/2gDarqTmVz/T2BtO1wcw==
+## path node_modules/a
+## required-by a
+# b-1.0.3.tgz b 1.0.3 sha512-QXlNDlGlROGZg8aittWc7eXw7Qc+UguedzSqVJompYQydROPpVwA+Fz/UG5re1KsAbabN4mbcyjvDB/KSF3ALA==
+## path node_modules/b
+## required-by @s/d
+## required-by a
+# c-1.2.0.tgz c 1.2.0 sha512-KFKox6RhwvxcU3edwtcMDVULoYXKlXa33wJq0HSY1/MkFG7zv5t65MACMUjOcHK11cJMvtr17lSe6YOx4RG7dA==
+## path node_modules/a/node_modules/c
+## required-by a
+# c-2.0.0.tgz c 2.0.0 sha512-Kqt2PUXeKmI8y/rlmhq6qOoMU/Ik4lui1VkfhLiN2AZKq5Fe5e7mVi3cFEvFbeW7Wy9vZ8YG/L/XMisSZZ1H3Q==
+## path node_modules/c
+## required-by @s/d
+## required-by a
+## required-by c
+`
+	assert.Equal(expected, string(actual))
+
+	parsed, err := npmmod.ParseManifest(actual)
+	assert.Nil(err)
+	assert.Equal(entries, parsed)
+}
+
+func TestParseManifest_Error(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	data := "## path node_modules/a\n"
+	entries, err := npmmod.ParseManifest([]byte(data))
+	assert.Nil(entries)
+	assert.Equal(`vendor/npm-mod.txt:1: unexpected line; "## path node_modules/a"`, fmt.Sprintf("%v", err))
+}
+
+func TestCheckVendorDir(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := tempDir(t, assert)
+	err := os.Mkdir(filepath.Join(root, "vendor"), 0755)
+	assert.Nil(err)
+	for _, filename := range []string{"a-1.0.0.tgz", "z-9.9.9.tgz", "npm-mod.txt", "notes.tgz"} {
+		err = os.WriteFile(filepath.Join(root, "vendor", filename), nil, 0644)
+		assert.Nil(err)
+	}
+
+	entries := []npmmod.ManifestEntry{{Filename: "a-1.0.0.tgz"}}
+	err = npmmod.CheckVendorDir(root, entries)
+	expected := "vendor/ does not agree with vendor/npm-mod.txt; run npm-mod vendor --prune to fetch the missing archives and remove the unexpected ones:\n- unexpected z-9.9.9.tgz"
+	assert.Equal(expected, fmt.Sprintf("%v", err))
+
+	entries = append(entries, npmmod.ManifestEntry{Filename: "z-9.9.9.tgz"})
+	err = npmmod.CheckVendorDir(root, entries)
+	assert.Nil(err)
+
+	entries = append(entries, npmmod.ManifestEntry{Filename: "b-2.0.0.tgz"})
+	err = npmmod.CheckVendorDir(root, entries)
+	expected = "vendor/ does not agree with vendor/npm-mod.txt; run npm-mod vendor --prune to fetch the missing archives and remove the unexpected ones:\n- missing b-2.0.0.tgz"
+	assert.Equal(expected, fmt.Sprintf("%v", err))
+}
+
+func TestCheckVendorManifest(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Name     string
+		Manifest string
+		Error    string
+	}
+
+	cases := []testCase{
+		{Name: "no-manifest"},
+		{Name: "agrees", Manifest: "# a-1.0.0.tgz a 1.0.0 sha512-YQ==\n# b-2.0.0.tgz b 2.0.0 sha512-Yg==\n"},
+		{
+			Name:     "extra",
+			Manifest: "# a-1.0.0.tgz a 1.0.0 sha512-YQ==\n",
+			Error:    "vendor/ does not agree with vendor/npm-mod.txt; run npm-mod vendor --prune to fetch the missing archives and remove the unexpected ones:\n- unexpected b-2.0.0.tgz",
+		},
+		{
+			Name:     "missing",
+			Manifest: "# a-1.0.0.tgz a 1.0.0 sha512-YQ==\n# b-2.0.0.tgz b 2.0.0 sha512-Yg==\n# c-3.0.0.tgz c 3.0.0 sha512-Yw==\n",
+			Error:    "vendor/ does not agree with vendor/npm-mod.txt; run npm-mod vendor --prune to fetch the missing archives and remove the unexpected ones:\n- missing c-3.0.0.tgz",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			root := tempDir(t, assert)
+			err := os.Mkdir(filepath.Join(root, "vendor"), 0755)
+			assert.Nil(err)
+			for _, filename := range []string{"a-1.0.0.tgz", "b-2.0.0.tgz"} {
+				err = os.WriteFile(filepath.Join(root, "vendor", filename), nil, 0644)
+				assert.Nil(err)
+			}
+			if tc.Manifest != "" {
+				err = os.WriteFile(filepath.Join(root, "vendor", "npm-mod.txt"), []byte(tc.Manifest), 0644)
+				assert.Nil(err)
+			}
+
+			err = npmmod.CheckVendorManifest(root)
+			if tc.Error == "" {
+				assert.Nil(err)
+			} else {
+				assert.Equal(tc.Error, fmt.Sprintf("%v", err))
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"fmt"
+	neturl "net/url"
+	"strings"
+)
+
+// Rewrite replaces the prefix `From` of a package archive URL with `To`,
+// e.g. to fetch `https://registry.npmjs.org/` package archives from an
+// internal mirror. Rewrites only apply at download time; the canonical URL
+// is what `.npm-mod.tidy.json` and `package-lock.json` refer to.
+type Rewrite struct {
+	From string
+	To   string
+}
+
+// ParseRewrite parses a rewrite of the form `FROM => TO`. Neither URL may
+// contain credentials (they belong in `.npmrc`).
+func ParseRewrite(s string) (Rewrite, error) {
+	from, to, ok := strings.Cut(s, "=>")
+	from = strings.TrimSpace(from)
+	to = strings.TrimSpace(to)
+	if !ok || from == "" || to == "" {
+		return Rewrite{}, fmt.Errorf("rewrite is not of the form FROM => TO; %q", s)
+	}
+
+	for _, prefix := range []string{from, to} {
+		u, err := neturl.Parse(prefix)
+		if err != nil || u.Host == "" {
+			return Rewrite{}, fmt.Errorf("rewrite URL is not valid; %q", prefix)
+		}
+		if u.User != nil {
+			return Rewrite{}, fmt.Errorf("rewrite URL must not contain credentials; %s", u.Redacted())
+		}
+	}
+
+	return Rewrite{From: from, To: to}, nil
+}
+
+// Apply rewrites `url` if it starts with `From`.
+func (r Rewrite) Apply(url string) (string, bool) {
+	if !strings.HasPrefix(url, r.From) {
+		return "", false
+	}
+	return r.To + strings.TrimPrefix(url, r.From), true
+}
+
+// String formats the rewrite as `FROM => TO`.
+func (r Rewrite) String() string {
+	return r.From + " => " + r.To
+}
+
+// candidateURLs returns the URLs to try (in order) for a package archive:
+// `url` after the first matching rewrite, followed by the matching mirrors.
+func candidateURLs(url string, rewrites, mirrors []Rewrite) []string {
+	primary := url
+	for _, r := range rewrites {
+		rewritten, ok := r.Apply(url)
+		if ok {
+			primary = rewritten
+			break
+		}
+	}
+
+	candidates := []string{primary}
+	seen := map[string]bool{primary: true}
+	for _, m := range mirrors {
+		mirrored, ok := m.Apply(url)
+		if ok && !seen[mirrored] {
+			candidates = append(candidates, mirrored)
+			seen[mirrored] = true
+		}
+	}
+
+	return candidates
+}
@@ -0,0 +1,254 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+)
+
+// NOTE: Ensure that
+//       * `RevertMirrored{}.Visit` satisfies `VisitorFunc`.
+//       * `FindMirrored{}.Visit` satisfies `VisitorFunc`.
+//       * `MirrorReplace{}.Replace` satisfies `ReplaceFunc`.
+var (
+	_ VisitorFunc = (&RevertMirrored{}).Visit
+	_ VisitorFunc = (&FindMirrored{}).Visit
+	_ ReplaceFunc = (&MirrorReplace{}).Replace
+)
+
+// SetMode sets the tidy mode. `ModeRegistry` requires a mirror registry base
+// URL; `ModeVendor` and `ModePin` don't take one.
+func (tf *TidyFile) SetMode(mode, mirror string) error {
+	switch mode {
+	case ModeVendor, ModePin:
+		if mirror != "" {
+			return fmt.Errorf("a mirror is only supported in %s mode; %s", ModeRegistry, mirror)
+		}
+		tf.Mode = mode
+		if mode == ModeVendor {
+			tf.Mode = ""
+		}
+		tf.Mirror = ""
+		return nil
+	case ModeRegistry:
+		normalized, err := normalizeMirror(mirror)
+		if err != nil {
+			return err
+		}
+		tf.Mode = ModeRegistry
+		tf.Mirror = normalized
+		return nil
+	default:
+		return fmt.Errorf("unsupported tidy mode; %q", mode)
+	}
+}
+
+// VendorMode determines if tidy replaces registry packages with
+// `file:vendor/...` references (i.e. if there are package archives to vendor).
+func (tf *TidyFile) VendorMode() bool {
+	return tf.Mode == "" || tf.Mode == ModeVendor
+}
+
+// RegistryMode determines if tidy rewrites `resolved` URLs to `Mirror`
+// rather than vendoring package archives.
+func (tf *TidyFile) RegistryMode() bool {
+	return tf.Mode == ModeRegistry
+}
+
+// normalizeMirror ensures a mirror registry base URL is valid (and doesn't
+// contain credentials, since it is written to `package-lock.json`) and ends
+// with a slash.
+func normalizeMirror(mirror string) (string, error) {
+	if mirror == "" {
+		return "", fmt.Errorf("%s mode requires a mirror registry URL", ModeRegistry)
+	}
+
+	u, err := neturl.Parse(mirror)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return "", fmt.Errorf("mirror registry URL is not valid; %q", mirror)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("mirror registry URL must not contain credentials; %s", u.Redacted())
+	}
+
+	return strings.TrimSuffix(mirror, "/") + "/", nil
+}
+
+// MirroredURL rewrites a registry URL to the same package archive in the
+// `mirror` registry, e.g. `https://registry.npmjs.org/@babel/core/-/core-7.17.9.tgz`
+// becomes `https://npm.internal/@babel/core/-/core-7.17.9.tgz` for the mirror
+// `https://npm.internal/`.
+func MirroredURL(mirror, url string) (string, error) {
+	packageName, filename, err := splitRegistryURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	return mirror + packageName + "/-/" + filename, nil
+}
+
+// MirrorReplace provides a `replace` helper that replaces a `resolved` URL
+// with the URL of the same package archive in a mirror registry.
+type MirrorReplace struct {
+	ByURL  map[string]RegistryPackage
+	Mirror string
+	// Unmatched collects every `resolved` URL that could not be replaced.
+	// Each URL is only recorded once, even if it is resolved more than once
+	// in the package lock.
+	Unmatched []Unmatched
+}
+
+// Replace replaces a `resolved` URL with the mirrored URL. In the case that
+// the URL can't be matched or isn't a registry URL, this just returns the
+// `resolved` and records it in `Unmatched`.
+func (mr *MirrorReplace) Replace(resolved string) (string, error) {
+	_, ok := mr.ByURL[resolved]
+	if !ok {
+		mr.unmatched(resolved, "not in package-lock.json packages")
+		return resolved, nil
+	}
+
+	mirrored, err := MirroredURL(mr.Mirror, resolved)
+	if err != nil {
+		mr.unmatched(resolved, err.Error())
+		return resolved, nil
+	}
+
+	return mirrored, nil
+}
+
+func (mr *MirrorReplace) unmatched(resolved, reason string) {
+	for _, u := range mr.Unmatched {
+		if u.Specifier == resolved {
+			return
+		}
+	}
+
+	u := Unmatched{File: "package-lock.json", Specifier: resolved, Reason: reason}
+	mr.Unmatched = append(mr.Unmatched, u)
+}
+
+// RevertMirrored produces a visitor function that **reverts** a package
+// `resolved` URL that refers to a mirror registry back to the original URL.
+type RevertMirrored struct {
+	// ByMirrored maps each mirrored URL to the original URL.
+	ByMirrored map[string]string
+	ParentKey  string
+}
+
+// Visit is a visitor function that **reverts** a mirrored `resolved` URL.
+// Packages that don't refer to a tracked mirrored URL are left unchanged.
+func (rm *RevertMirrored) Visit(_ *ordered.OrderedMap, k string, v any) error {
+	name := k
+	if rm.ParentKey == "packages" && name == "" {
+		return nil
+	}
+
+	m, ok := v.(*ordered.OrderedMap)
+	if !ok {
+		return fmt.Errorf("package %q does not point at a map", name)
+	}
+
+	resolved, _ := m.Get("resolved").(string)
+	original, ok := rm.ByMirrored[resolved]
+	if ok {
+		m.Set("resolved", original)
+	}
+	return nil
+}
+
+// FindMirrored produces a visitor function that collects the packages in
+// either the `package-lock.json` packages or dependencies map that are
+// resolved from a tracked mirrored URL.
+type FindMirrored struct {
+	// ByMirrored maps each mirrored URL to the original URL.
+	ByMirrored map[string]string
+	URLs       map[string]bool
+}
+
+// Visit is a visitor function that **collects** a mirrored `resolved` URL.
+func (fm *FindMirrored) Visit(_ *ordered.OrderedMap, _ string, v any) error {
+	m, ok := v.(*ordered.OrderedMap)
+	if !ok {
+		return nil
+	}
+
+	resolved, _ := m.Get("resolved").(string)
+	if _, ok := fm.ByMirrored[resolved]; ok {
+		fm.URLs[resolved] = true
+	}
+	return nil
+}
+
+// PackageLockMirroredURLs iterates through all entries in the
+// `package-lock.json` packages and dependencies maps and collects the
+// `resolved` URLs that are mirrored URLs of the `packages`.
+func PackageLockMirroredURLs(packageLock *ordered.OrderedMap, packages []Package) (map[string]bool, error) {
+	fm := FindMirrored{ByMirrored: packagesByMirrored(packages), URLs: map[string]bool{}}
+	err := walkPackageLockPackages(packageLock, fm.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = walkPackageLockDependencies(packageLock, fm.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	return fm.URLs, nil
+}
+
+// packagesByMirrored maps the mirrored URL of each package to its original
+// URL.
+func packagesByMirrored(packages []Package) map[string]string {
+	byMirrored := map[string]string{}
+	for _, p := range packages {
+		if p.Mirrored != "" {
+			byMirrored[p.Mirrored] = p.URL
+		}
+	}
+	return byMirrored
+}
+
+// revertMirrored reverts all mirrored `resolved` URLs in a `package-lock.json`
+// that was tidied in `ModeRegistry` (in place) based on the existing
+// `.npm-mod.tidy.json`. The patch is applied in reverse first; any mirrored
+// URLs it doesn't cover (e.g. because `npm install` rewrote an entry) are then
+// reverted based on the tracked packages.
+func revertMirrored(previous *TidyFile, pl *ordered.OrderedMap) ([]byte, error) {
+	err := previous.Patch.PackageLockJSON.Revert(pl, false)
+	if err != nil {
+		return nil, err
+	}
+
+	byMirrored := packagesByMirrored(previous.Packages)
+	rm := RevertMirrored{ByMirrored: byMirrored, ParentKey: "packages"}
+	err = walkPackageLockPackages(pl, rm.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	rm = RevertMirrored{ByMirrored: byMirrored, ParentKey: "dependencies"}
+	err = walkPackageLockDependencies(pl, rm.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	return previous.Patch.PackageLockJSON.Format.marshal(pl)
+}
@@ -0,0 +1,492 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+const (
+	// DefaultRegistry is the registry used when `.npmrc` doesn't set
+	// `registry`.
+	DefaultRegistry = "https://registry.npmjs.org/"
+)
+
+var (
+	envReference = regexp.MustCompile(`\$\{([^${}]+)\}`)
+)
+
+// NPMRC is the configuration from `.npmrc` files that determines how
+// package archives are fetched: which registry serves a package, the
+// credentials for each registry and the TLS and proxy settings.
+//
+// Credentials are only ever held in memory; they are never part of the
+// formatted value of an `NPMRC`.
+type NPMRC struct {
+	// Registry is the default registry.
+	Registry string
+	// Scopes maps a package scope (e.g. `@hardfin`) to its registry.
+	Scopes map[string]string
+	// AlwaysAuth determines if the credentials for a registry are sent for
+	// package archives hosted on a different host than the registry.
+	AlwaysAuth bool
+	// CAFile is a file with the (PEM encoded) certificate authorities that
+	// are trusted instead of the system certificate authorities.
+	CAFile string
+	// Proxy is the proxy for `http` requests (and for `https` requests if
+	// `HTTPSProxy` isn't set).
+	Proxy string
+	// HTTPSProxy is the proxy for `https` requests.
+	HTTPSProxy string
+	// StrictSSL determines if TLS certificates are verified.
+	StrictSSL bool
+
+	// values holds the raw (merged) values, including credentials keyed by
+	// "nerf dart" (e.g. `//npm.example.com/:_authToken`).
+	// NOTE: This is a pointer so that formatting an `NPMRC` (e.g. with `%+v`)
+	//       prints an address rather than the credentials.
+	values *npmrcValues
+}
+
+// npmrcValues holds the raw values from `.npmrc` files.
+type npmrcValues struct {
+	byKey map[string]string
+}
+
+// ReadNPMRC reads the global, user and project `.npmrc` files (in increasing
+// order of precedence) that apply to the project at `root`. Missing files are
+// skipped; environment variables are expanded in keys and values.
+//
+// The user configuration is `$NPM_CONFIG_USERCONFIG` or `~/.npmrc` and the
+// global configuration is `$NPM_CONFIG_GLOBALCONFIG` or `{PREFIX}/etc/npmrc`.
+// As with `npm`, the prefix is `$NPM_CONFIG_PREFIX`, `$PREFIX` or determined
+// from the location of the `node` executable on the `PATH`.
+func ReadNPMRC(root string) (*NPMRC, error) {
+	values := map[string]string{}
+	for _, filename := range npmrcFilenames(root) {
+		data, err := os.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fileValues, err := ParseNPMRC(data, os.LookupEnv)
+		if err != nil {
+			return nil, fmt.Errorf("%w; %s", err, filename)
+		}
+		for k, v := range fileValues {
+			values[k] = v
+		}
+	}
+
+	return NewNPMRC(values)
+}
+
+// npmrcFilenames returns the global, user and project `.npmrc` filenames.
+func npmrcFilenames(root string) []string {
+	filenames := []string{}
+
+	global := lookupNPMConfigEnv("globalconfig")
+	if global == "" {
+		prefix := globalPrefix()
+		if prefix != "" {
+			global = filepath.Join(prefix, "etc", "npmrc")
+		}
+	}
+	if global != "" {
+		filenames = append(filenames, global)
+	}
+
+	user := lookupNPMConfigEnv("userconfig")
+	if user == "" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			user = filepath.Join(home, ".npmrc")
+		}
+	}
+	if user != "" {
+		filenames = append(filenames, user)
+	}
+
+	return append(filenames, filepath.Join(root, ".npmrc"))
+}
+
+// globalPrefix determines the `npm` global prefix the same way `npm` does:
+// `$NPM_CONFIG_PREFIX`, `$PREFIX` or the directory that the `node` executable
+// is installed in (e.g. `/usr/local` for `/usr/local/bin/node`; on Windows the
+// `bin` directory is not used). Returns an empty string if `node` can't be
+// found.
+func globalPrefix() string {
+	prefix := lookupNPMConfigEnv("prefix")
+	if prefix != "" {
+		return prefix
+	}
+	prefix = os.Getenv("PREFIX")
+	if prefix != "" {
+		return prefix
+	}
+
+	node, err := exec.LookPath("node")
+	if err != nil {
+		return ""
+	}
+	// NOTE: `npm` uses the real path of the running `node` executable (e.g.
+	//       through a Homebrew or `nvm` symlink).
+	node, err = filepath.EvalSymlinks(node)
+	if err != nil {
+		return ""
+	}
+	node, err = filepath.Abs(node)
+	if err != nil {
+		return ""
+	}
+
+	if runtime.GOOS == "windows" {
+		return filepath.Dir(node)
+	}
+	return filepath.Dir(filepath.Dir(node))
+}
+
+// lookupNPMConfigEnv looks up an `npm` configuration environment variable,
+// e.g. `NPM_CONFIG_USERCONFIG` (or `npm_config_userconfig`).
+func lookupNPMConfigEnv(name string) string {
+	v := os.Getenv("npm_config_" + name)
+	if v != "" {
+		return v
+	}
+	return os.Getenv("NPM_CONFIG_" + strings.ToUpper(name))
+}
+
+// ParseNPMRC parses the `key = value` lines of an `.npmrc` file, expanding
+// `${NAME}` references with `lookupEnv`. As with `npm`, a reference to an
+// unset environment variable is left as-is.
+//
+// Errors never include values, since a value may be a credential.
+func ParseNPMRC(data []byte, lookupEnv func(string) (string, bool)) (map[string]string, error) {
+	expand := func(s string) string {
+		return envReference.ReplaceAllStringFunc(s, func(ref string) string {
+			v, ok := lookupEnv(ref[2 : len(ref)-1])
+			if !ok {
+				return ref
+			}
+			return v
+		})
+	}
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// NOTE: Sections have no meaning for `npm`.
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = expand(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if !ok {
+			value = "true"
+		}
+		if key == "" {
+			return nil, fmt.Errorf(".npmrc line has no key; line %d", lineNumber)
+		}
+
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			err := json.Unmarshal([]byte(value), &value)
+			if err != nil {
+				return nil, fmt.Errorf(".npmrc value is not a valid quoted string; key: %s, line %d", key, lineNumber)
+			}
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = expand(value)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// NewNPMRC interprets the (merged) values from `.npmrc` files.
+func NewNPMRC(values map[string]string) (*NPMRC, error) {
+	rc := &NPMRC{
+		Registry:  DefaultRegistry,
+		Scopes:    map[string]string{},
+		StrictSSL: true,
+		values:    &npmrcValues{byKey: map[string]string{}},
+	}
+
+	var err error
+	for k, v := range values {
+		rc.values.byKey[k] = v
+		switch {
+		case k == "registry":
+			rc.Registry, err = normalizeRegistry(k, v)
+		case strings.HasPrefix(k, "@") && strings.HasSuffix(k, ":registry"):
+			rc.Scopes[strings.TrimSuffix(k, ":registry")], err = normalizeRegistry(k, v)
+		case k == "always-auth":
+			rc.AlwaysAuth, err = parseNPMRCBool(k, v)
+		case k == "strict-ssl":
+			rc.StrictSSL, err = parseNPMRCBool(k, v)
+		case k == "cafile":
+			rc.CAFile = v
+		case k == "proxy":
+			rc.Proxy = v
+		case k == "https-proxy":
+			rc.HTTPSProxy = v
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rc, nil
+}
+
+// normalizeRegistry ensures a registry URL is valid (without revealing any
+// credentials in it) and ends with a slash.
+func normalizeRegistry(key, registry string) (string, error) {
+	u, err := neturl.Parse(registry)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf(".npmrc registry is not a valid URL; key: %s", key)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf(".npmrc registry URL must not contain credentials; key: %s", key)
+	}
+
+	return strings.TrimSuffix(registry, "/") + "/", nil
+}
+
+func parseNPMRCBool(key, value string) (bool, error) {
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf(".npmrc value is not a boolean; key: %s, value: %q", key, value)
+	}
+}
+
+// RegistryFor returns the registry for a package, i.e. the registry for its
+// scope (if configured) or the default registry.
+func (rc *NPMRC) RegistryFor(packageName string) string {
+	if strings.HasPrefix(packageName, "@") {
+		scope, _, _ := strings.Cut(packageName, "/")
+		registry, ok := rc.Scopes[scope]
+		if ok {
+			return registry
+		}
+	}
+	return rc.Registry
+}
+
+// Client creates an HTTP client with the TLS and proxy settings and that
+// adds the credentials for each request URL.
+func (rc *NPMRC) Client() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: !rc.StrictSSL}
+	if rc.CAFile != "" {
+		pem, err := os.ReadFile(rc.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf(".npmrc cafile has no PEM encoded certificates; %s", rc.CAFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	proxy, err := parseProxy("proxy", rc.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	httpsProxy, err := parseProxy("https-proxy", rc.HTTPSProxy)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = func(req *http.Request) (*neturl.URL, error) {
+		if req.URL.Scheme == "https" && httpsProxy != nil {
+			return httpsProxy, nil
+		}
+		if proxy != nil {
+			return proxy, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+
+	return &http.Client{Transport: &authTransport{Config: rc, Base: transport}}, nil
+}
+
+func parseProxy(key, proxy string) (*neturl.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+
+	u, err := neturl.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf(".npmrc proxy is not a valid URL; key: %s", key)
+	}
+	return u, nil
+}
+
+// Authorization returns the `Authorization` header value for a request for
+// `url` (or the empty string if there are no credentials for it).
+//
+// The credentials for the longest "nerf dart" (e.g.
+// `//npm.example.com/private/`) that is a prefix of `url` are used. Otherwise,
+// the credentials for the registry of the package in `url` are used if `url`
+// is on the same host as the registry (or if `always-auth` is set).
+func (rc *NPMRC) Authorization(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	authorization := rc.matchingAuthorization(u)
+	if authorization != "" {
+		return authorization
+	}
+
+	// NOTE: A package name that can't be determined from the URL only means
+	//       that the package is unscoped.
+	packageName, _, _ := splitRegistryURL(url)
+	registry, err := neturl.Parse(rc.RegistryFor(packageName))
+	if err != nil || (registry.Host != u.Host && !rc.alwaysAuth(registry)) {
+		return ""
+	}
+
+	authorization = rc.authorizationFor(nerfDartFor(registry))
+	if authorization == "" && rc.RegistryFor(packageName) == rc.Registry {
+		// NOTE: Credentials that aren't scoped to a registry only apply to
+		//       the default registry.
+		authorization = rc.authorizationFor("")
+	}
+	return authorization
+}
+
+// matchingAuthorization returns the `Authorization` header value for the
+// longest "nerf dart" that is a prefix of `u`.
+func (rc *NPMRC) matchingAuthorization(u *neturl.URL) string {
+	for nerfDart := nerfDartFor(u); nerfDart != "//"; {
+		authorization := rc.authorizationFor(nerfDart)
+		if authorization != "" {
+			return authorization
+		}
+
+		nerfDart = nerfDart[:strings.LastIndex(strings.TrimSuffix(nerfDart, "/"), "/")+1]
+	}
+
+	return ""
+}
+
+// nerfDartFor returns the "nerf dart" for a URL, i.e. the URL without the
+// scheme, query and the last path component, e.g.
+// `//registry.npmjs.org/@babel/core/-/`.
+func nerfDartFor(u *neturl.URL) string {
+	path := u.Path
+	return "//" + u.Host + path[:strings.LastIndex(path, "/")+1]
+}
+
+// alwaysAuth determines if `always-auth` is set for a registry (or globally).
+func (rc *NPMRC) alwaysAuth(registry *neturl.URL) bool {
+	v, ok := rc.values.byKey[nerfDartFor(registry)+":always-auth"]
+	if ok {
+		return v == "true"
+	}
+	return rc.AlwaysAuth
+}
+
+// authorizationFor returns the `Authorization` header value for the
+// credentials with the given prefix (either a "nerf dart" or the empty
+// string).
+func (rc *NPMRC) authorizationFor(nerfDart string) string {
+	prefix := ""
+	if nerfDart != "" {
+		prefix = nerfDart + ":"
+	}
+
+	token := rc.values.byKey[prefix+"_authToken"]
+	if token != "" {
+		return "Bearer " + token
+	}
+
+	auth := rc.values.byKey[prefix+"_auth"]
+	if auth != "" {
+		return "Basic " + auth
+	}
+
+	username := rc.values.byKey[prefix+"username"]
+	password, err := base64.StdEncoding.DecodeString(rc.values.byKey[prefix+"_password"])
+	if username != "" && err == nil && len(password) > 0 {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+string(password)))
+	}
+
+	return ""
+}
+
+// authTransport adds the credentials from `.npmrc` to each request,
+// including each request that follows a redirect.
+type authTransport struct {
+	Config *NPMRC
+	Base   http.RoundTripper
+}
+
+// RoundTrip sends the request with the credentials for its URL.
+func (at *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// NOTE: A redirect (e.g. to a CDN) only gets credentials that are
+	//       explicitly configured for its URL, never the credentials of a
+	//       registry via `always-auth`.
+	authorization := ""
+	if req.Response == nil {
+		authorization = at.Config.Authorization(req.URL.String())
+	} else {
+		authorization = at.Config.matchingAuthorization(req.URL)
+	}
+	if authorization == "" || req.Header.Get("Authorization") != "" {
+		return at.Base.RoundTrip(req)
+	}
+
+	// NOTE: A `RoundTripper` must not modify the request it is given.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", authorization)
+	return at.Base.RoundTrip(req)
+}
@@ -0,0 +1,221 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestParseNPMRC(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	data := []byte(`
+; comment
+# another comment
+registry = https://npm.example.com/
+@hardfin:registry=https://npm.hardfin.com/
+//npm.hardfin.com/:_authToken=${NPM_TOKEN}
+//${NPM_HOST}/:_authToken = "quoted ${NPM_TOKEN}"
+//npm.example.com/:_auth='dXNlcjpwYXNz'
+unset=${UNSET}
+always-auth
+[section]
+strict-ssl=false
+`)
+	lookupEnv := func(name string) (string, bool) {
+		env := map[string]string{"NPM_TOKEN": "s3cr3t", "NPM_HOST": "npm.other.com"}
+		v, ok := env[name]
+		return v, ok
+	}
+	values, err := npmmod.ParseNPMRC(data, lookupEnv)
+	assert.Nil(err)
+	expected := map[string]string{
+		"registry":                      "https://npm.example.com/",
+		"@hardfin:registry":             "https://npm.hardfin.com/",
+		"//npm.hardfin.com/:_authToken": "s3cr3t",
+		"//npm.other.com/:_authToken":   "quoted s3cr3t",
+		"//npm.example.com/:_auth":      "dXNlcjpwYXNz",
+		"unset":                         "${UNSET}",
+		"always-auth":                   "true",
+		"strict-ssl":                    "false",
+	}
+	assert.Equal(expected, values)
+
+	// Errors don't include the value.
+	_, err = npmmod.ParseNPMRC([]byte("//npm.hardfin.com/:_authToken=\"s3cr3t\\\"\n"), lookupEnv)
+	assert.Equal(".npmrc value is not a valid quoted string; key: //npm.hardfin.com/:_authToken, line 1", fmt.Sprintf("%v", err))
+}
+
+func TestReadNPMRC_GlobalPrefix(t *testing.T) {
+	// NOTE: This can't run in parallel since it modifies the environment.
+	assert := testifyassert.New(t)
+
+	// The global configuration is found via the `node` executable on the
+	// `PATH`, as with `npm`.
+	prefix := tempDir(t, assert)
+	for _, dir := range []string{"bin", "etc"} {
+		err := os.Mkdir(filepath.Join(prefix, dir), 0755)
+		assert.Nil(err)
+	}
+	err := os.WriteFile(filepath.Join(prefix, "bin", "node"), []byte("#!/bin/sh\n"), 0755)
+	assert.Nil(err)
+	err = os.WriteFile(filepath.Join(prefix, "etc", "npmrc"), []byte("registry=https://npm.global.example.com/\n"), 0644)
+	assert.Nil(err)
+
+	root := tempDir(t, assert)
+	t.Setenv("PATH", filepath.Join(prefix, "bin"))
+	t.Setenv("HOME", root)
+	for _, name := range []string{"NPM_CONFIG_GLOBALCONFIG", "npm_config_globalconfig", "NPM_CONFIG_PREFIX", "npm_config_prefix", "PREFIX", "NPM_CONFIG_USERCONFIG", "npm_config_userconfig"} {
+		t.Setenv(name, "")
+	}
+	rc, err := npmmod.ReadNPMRC(root)
+	assert.Nil(err)
+	assert.Equal("https://npm.global.example.com/", rc.Registry)
+
+	// `$PREFIX` takes precedence.
+	t.Setenv("PREFIX", root)
+	rc, err = npmmod.ReadNPMRC(root)
+	assert.Nil(err)
+	assert.Equal(npmmod.DefaultRegistry, rc.Registry)
+}
+
+func TestNPMRC_Authorization(outer *testing.T) {
+	outer.Parallel()
+
+	values := map[string]string{
+		"_authToken":                            "default",
+		"@hardfin:registry":                     "https://npm.hardfin.com/private",
+		"//npm.hardfin.com/private/:_authToken": "hardfin",
+		"//npm.hardfin.com/private/@hardfin/secret/:_authToken": "secret",
+		"@basic:registry":               "https://npm.basic.com/",
+		"//npm.basic.com/:username":     "user",
+		"//npm.basic.com/:_password":    "cGFzcw==",
+		"@always:registry":              "https://npm.always.com/",
+		"//npm.always.com/:_auth":       "dXNlcjpwYXNz",
+		"//npm.always.com/:always-auth": "true",
+	}
+	rc, err := npmmod.NewNPMRC(values)
+	if err != nil {
+		outer.Fatal(err)
+	}
+
+	cases := []struct {
+		URL           string
+		Authorization string
+	}{
+		{URL: "https://registry.npmjs.org/react/-/react-18.0.0.tgz", Authorization: "Bearer default"},
+		{URL: "https://cdn.example.com/react/-/react-18.0.0.tgz"},
+		{URL: "https://npm.hardfin.com/private/@hardfin/core/-/core-1.0.0.tgz", Authorization: "Bearer hardfin"},
+		{URL: "https://npm.hardfin.com/private/@hardfin/secret/-/secret-1.0.0.tgz", Authorization: "Bearer secret"},
+		{URL: "https://npm.hardfin.com/public/react/-/react-18.0.0.tgz"},
+		{URL: "https://cdn.hardfin.com/@hardfin/core/-/core-1.0.0.tgz"},
+		{URL: "https://npm.basic.com/@basic/core/-/core-1.0.0.tgz", Authorization: "Basic dXNlcjpwYXNz"},
+		{URL: "https://cdn.basic.com/@basic/core/-/core-1.0.0.tgz"},
+		{URL: "https://cdn.always.com/@always/core/-/core-1.0.0.tgz", Authorization: "Basic dXNlcjpwYXNz"},
+	}
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.URL, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			assert.Equal(tc.Authorization, rc.Authorization(tc.URL))
+		})
+	}
+}
+
+func TestNPMRC_Client(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	data, err := os.ReadFile(filepath.Join("testdata", "builtins-1.0.3.tgz"))
+	assert.Nil(err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	// NOTE: Don't log the handshake failure for the untrusted certificate.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	destination := tempDir(t, assert)
+	cafile := filepath.Join(destination, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	err = os.WriteFile(cafile, caPEM, 0o644)
+	assert.Nil(err)
+
+	url := server.URL + "/builtins/-/builtins-1.0.3.tgz"
+	filename := filepath.Join(destination, "builtins-1.0.3.tgz")
+	nerfDart := "//" + strings.TrimPrefix(server.URL, "https://") + "/"
+	cases := []struct {
+		Values map[string]string
+		Error  string
+	}{
+		{
+			Values: map[string]string{"cafile": cafile, nerfDart + ":_authToken": "s3cr3t"},
+		},
+		{
+			Values: map[string]string{"strict-ssl": "false", nerfDart + ":_authToken": "s3cr3t"},
+		},
+		{
+			Values: map[string]string{"cafile": cafile},
+			Error:  "request failed; response code: 401 (attempt 1 of 1)",
+		},
+		{
+			Values: map[string]string{nerfDart + ":_authToken": "s3cr3t"},
+			Error:  "x509: certificate signed by unknown authority",
+		},
+	}
+	for _, tc := range cases {
+		rc, err := npmmod.NewNPMRC(tc.Values)
+		assert.Nil(err)
+		// The credentials are never part of the printed configuration.
+		assert.NotContains(fmt.Sprintf("%+v %#v", rc, rc), "s3cr3t")
+
+		f := npmmod.NewFetcher()
+		f.Retry.Attempts = 1
+		f.Client, err = rc.Client()
+		assert.Nil(err)
+		_, err = f.Fetch(context.TODO(), url, "sha1", "y5T662HIaWRR2zZTThQi+U8K7og=", filename)
+		if tc.Error != "" {
+			assert.NotNil(err)
+			assert.Contains(fmt.Sprintf("%v", err), tc.Error)
+			continue
+		}
+
+		assert.Nil(err)
+		assert.Nil(os.Remove(filename))
+	}
+}
@@ -21,29 +21,43 @@ import (
 	"github.com/hardfinhq/npm-mod/pkg/ordered"
 )
 
+var (
+	packageJSONDependencyKeys = []string{"dependencies", "devDependencies", "optionalDependencies", "peerDependencies"}
+)
+
 // PackageJSONReplaceDependencies iterates through all entries in the
 // `package.json` dependencies maps and then replaces each package version based
 // on a "replace" function.
 func PackageJSONReplaceDependencies(packageJSON *ordered.OrderedMap, replace ReplacePairFunc) error {
 	rp := ReplaceDependency{Replace: replace}
-
-	err := walkPackageJSON(packageJSON, "dependencies", rp.Visit)
-	if err != nil {
-		return err
+	for _, key := range packageJSONDependencyKeys {
+		err := walkPackageJSON(packageJSON, key, rp.Visit)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = walkPackageJSON(packageJSON, "devDependencies", rp.Visit)
-	if err != nil {
-		return err
+	return nil
+}
+
+// PackageJSONVendoredFilenames iterates through all entries in the
+// `package.json` dependencies maps and collects the filenames of all packages
+// that have a `file:vendor/...` version.
+func PackageJSONVendoredFilenames(packageJSON *ordered.OrderedMap) (map[string]bool, error) {
+	fv := FindVendored{Filenames: map[string]bool{}}
+	for _, key := range packageJSONDependencyKeys {
+		err := walkPackageJSON(packageJSON, key, fv.Visit)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = walkPackageJSON(packageJSON, "peerDependencies", rp.Visit)
-	return err
+	return fv.Filenames, nil
 }
 
 // walkPackageJSON iterates through all entries in a `package.json` dependencies
-// map (i.e. `dependencies`, `devDependencies` or `peerDependencies`) and then
-// applies a "visitor" function to each key / value pair in the map
+// map (e.g. `dependencies` or `devDependencies`) and then applies a "visitor"
+// function to each key / value pair in the map
 func walkPackageJSON(packageJSON *ordered.OrderedMap, key string, visitor VisitorFunc) error {
 	depsAny, ok := packageJSON.GetValue(key)
 	if !ok {
@@ -17,6 +17,8 @@ package npmmod_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -54,6 +56,121 @@ func TestPackageJSONReplaceDependencies(t *testing.T) {
 	assert.True(bytes.Equal(expected, asJSON), "golden.package.json")
 }
 
-func replaceWithCaret(_, packageVersion string) string {
-	return "^" + packageVersion
+func replaceWithCaret(_, packageVersion string) (string, error) {
+	return "^" + packageVersion, nil
+}
+
+func TestPackageJSONReplaceDependencies_Error(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	b, err := os.ReadFile(filepath.Join("testdata", "package.json"))
+	assert.Nil(err)
+	packageJSON := ordered.NewOrderedMap()
+	err = json.Unmarshal(b, &packageJSON)
+	assert.Nil(err)
+
+	known := errors.New("WRENCH Replace()")
+	replace := func(_, _ string) (string, error) {
+		return "", known
+	}
+	err = npmmod.PackageJSONReplaceDependencies(packageJSON, replace)
+	assert.Equal(known, err)
+}
+
+func TestPackageJSONReplace_Unmatched(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	b, err := os.ReadFile(filepath.Join("testdata", "package.json"))
+	assert.Nil(err)
+	packageJSON := ordered.NewOrderedMap()
+	err = json.Unmarshal(b, &packageJSON)
+	assert.Nil(err)
+
+	pjr := npmmod.PackageJSONReplace{
+		ByNodeModulesPath: map[string]npmmod.RegistryPackage{
+			"node_modules/ce":     {URL: "https://registry.npmjs.org/ce/-/ce-1.2.3.tgz"},
+			"node_modules/aguent": {URL: "git+ssh://git@github.com/aguent/aguent.git"},
+		},
+	}
+	err = npmmod.PackageJSONReplaceDependencies(packageJSON, pjr.Replace)
+	assert.Nil(err)
+
+	actual := []string{}
+	for _, u := range pjr.Unmatched {
+		actual = append(actual, u.String())
+	}
+	expected := []string{
+		`package.json "stedientons": "3.4.5" (node_modules/stedientons not in package-lock.json)`,
+		`package.json "aguent": "2.0.11" (npm url in unexpected format; url: git+ssh://git@github.com/aguent/aguent.git)`,
+		`package.json "bility": "11.0.2" (node_modules/bility not in package-lock.json)`,
+		`package.json "syphontion": "2022.1" (node_modules/syphontion not in package-lock.json)`,
+	}
+	assert.Equal(expected, actual)
+
+	deps := packageJSON.Get("dependencies").(*ordered.OrderedMap)
+	assert.Equal("file:vendor/ce-1.2.3.tgz", deps.Get("ce"))
+	assert.Equal("2.0.11", deps.Get("aguent"))
+}
+
+func TestPackageJSONReplace_Range(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Version  string
+		URL      string
+		Expected string
+		Error    string
+	}
+
+	cases := []testCase{
+		{
+			Version:  "^18.0.0",
+			URL:      "https://registry.npmjs.org/react/-/react-18.2.0.tgz",
+			Expected: "file:vendor/react-18.2.0.tgz",
+			Error:    "<nil>",
+		},
+		{
+			Version:  "^18.0.0",
+			URL:      "https://registry.npmjs.org/react/-/react-17.0.2.tgz",
+			Expected: "",
+			Error:    `vendored version 17.0.2 of "react" does not satisfy "^18.0.0" (>=18.0.0 <19.0.0-0) in package.json`,
+		},
+		{
+			Version:  "npm:preact@~10.11.0",
+			URL:      "https://registry.npmjs.org/preact/-/preact-10.11.3.tgz",
+			Expected: "file:vendor/preact-10.11.3.tgz",
+			Error:    "<nil>",
+		},
+		{
+			Version:  "16.x || 17.x",
+			URL:      "https://registry.npmjs.org/react/-/react-18.0.0-rc.3.tgz",
+			Expected: "",
+			Error:    `vendored version 18.0.0-rc.3 of "react" does not satisfy "16.x || 17.x" (>=16.0.0 <17.0.0-0||>=17.0.0 <18.0.0-0) in package.json`,
+		},
+		{
+			Version:  "latest",
+			URL:      "https://registry.npmjs.org/react/-/react-17.0.2.tgz",
+			Expected: "file:vendor/react-17.0.2.tgz",
+			Error:    "<nil>",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Version, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			pjr := npmmod.PackageJSONReplace{
+				ByNodeModulesPath: map[string]npmmod.RegistryPackage{
+					"node_modules/react": {URL: tc.URL},
+				},
+			}
+			replaced, err := pjr.Replace("react", tc.Version)
+			assert.Equal(tc.Expected, replaced)
+			assert.Equal(tc.Error, fmt.Sprintf("%v", err))
+		})
+	}
 }
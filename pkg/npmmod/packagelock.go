@@ -17,15 +17,26 @@ package npmmod
 import (
 	"errors"
 	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/hardfinhq/npm-mod/pkg/ordered"
 )
 
+var (
+	// lockDependencyKeys are the dependency maps of a (non-root) package in
+	// the `package-lock.json` packages map.
+	lockDependencyKeys = []string{"dependencies", "optionalDependencies", "peerDependencies"}
+)
+
 // PackageLockReplaceDependencies iterates through all entries in the
 // `package-lock.json` packages and dependencies maps and then replaces each
-// package version based on a "replace" function.
-func PackageLockReplaceDependencies(packageLock *ordered.OrderedMap, replace ReplaceFunc) error {
-	rr := ReplaceResolved{Replace: replace, ParentKey: "packages"}
+// package version based on a "replace" function. If `keepVersions` is set,
+// the `version` of each package in the packages map is left as-is (only the
+// `resolved` URL is replaced).
+func PackageLockReplaceDependencies(packageLock *ordered.OrderedMap, replace ReplaceFunc, keepVersions bool) error {
+	rr := ReplaceResolved{Replace: replace, ParentKey: "packages", KeepVersion: keepVersions}
 	err := walkPackageLockPackages(packageLock, rr.Visit)
 	if err != nil {
 		return err
@@ -35,6 +46,50 @@ func PackageLockReplaceDependencies(packageLock *ordered.OrderedMap, replace Rep
 	return walkPackageLockDependencies(packageLock, rr.Visit)
 }
 
+// PackageLockReplaceResolved iterates through all entries in the
+// `package-lock.json` packages and dependencies maps and then replaces each
+// `resolved` URL based on a "replace" function. Unlike
+// `PackageLockReplaceDependencies()`, the `version` of every package is left
+// as-is.
+func PackageLockReplaceResolved(packageLock *ordered.OrderedMap, replace ReplaceFunc) error {
+	rr := ReplaceResolved{Replace: replace, ParentKey: "packages", KeepVersion: true}
+	err := walkPackageLockPackages(packageLock, rr.Visit)
+	if err != nil {
+		return err
+	}
+
+	rr = ReplaceResolved{Replace: replace, ParentKey: "dependencies", KeepVersion: true}
+	return walkPackageLockDependencies(packageLock, rr.Visit)
+}
+
+// PackageLockReplaceRoot iterates through all entries in the dependencies maps
+// of the root package (i.e. `packages[""]`) in a `package-lock.json` and then
+// replaces each package version based on a "replace" function. The root
+// package mirrors the `package.json` dependencies, so this should be the same
+// "replace" function used for the `package.json`.
+func PackageLockReplaceRoot(packageLock *ordered.OrderedMap, replace ReplacePairFunc) error {
+	root, err := lockPackage(packageLock, "")
+	if err != nil || root == nil {
+		return err
+	}
+
+	return PackageJSONReplaceDependencies(root, replace)
+}
+
+// PackageLockReplaceRanges iterates through all entries in the dependencies
+// maps of every (non-root) package in the `package-lock.json` packages map and
+// replaces each range that resolves to a vendored package with a `file:`
+// reference to the package archive.
+func PackageLockReplaceRanges(packageLock *ordered.OrderedMap) error {
+	packages, err := lockPackages(packageLock)
+	if err != nil || packages == nil {
+		return err
+	}
+
+	rr := ReplaceRanges{Packages: packages}
+	return walkPackageLockPackages(packageLock, rr.Visit)
+}
+
 // PackageLockExtractDependencies iterates through all entries in the
 // `package-lock.json` packages and dependencies maps and extracts the
 // "resolved" URL.
@@ -56,19 +111,32 @@ func PackageLockExtractDependencies(packageLock *ordered.OrderedMap) (map[string
 	return byNodeModulesPath, byURL, nil
 }
 
+// PackageLockVendoredFilenames iterates through all entries in the
+// `package-lock.json` packages and dependencies maps and collects the filenames
+// of all packages that have a `file:vendor/...` "resolved" reference.
+func PackageLockVendoredFilenames(packageLock *ordered.OrderedMap) (map[string]bool, error) {
+	fv := FindVendored{Filenames: map[string]bool{}}
+	err := walkPackageLockPackages(packageLock, fv.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = walkPackageLockDependencies(packageLock, fv.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	return fv.Filenames, nil
+}
+
 // walkPackageLockPackages iterates through all entries in the
 // `package-lock.json` packages map and then replaces each package version based
 // on a "replace" function.
 func walkPackageLockPackages(packageLock *ordered.OrderedMap, visitor VisitorFunc) error {
-	packagesAny, ok := packageLock.GetValue("packages")
-	if !ok {
-		// Early exit if the dependencies key is absent
-		return nil
-	}
-
-	packages, ok := packagesAny.(*ordered.OrderedMap)
-	if !ok {
-		return errors.New(`"packages" key is present, but not a map`)
+	packages, err := lockPackages(packageLock)
+	if err != nil || packages == nil {
+		// Early exit if the packages key is absent
+		return err
 	}
 
 	nextPair := packages.EntriesIter()
@@ -146,3 +214,104 @@ func walkPackageLockDependencies(hasDependencies *ordered.OrderedMap, visitor Vi
 
 	return nil
 }
+
+// lockPackages returns the `package-lock.json` packages map, or `nil` if the
+// packages key is absent (i.e. for a `lockfileVersion: 1` lockfile).
+func lockPackages(packageLock *ordered.OrderedMap) (*ordered.OrderedMap, error) {
+	packagesAny, ok := packageLock.GetValue("packages")
+	if !ok {
+		return nil, nil
+	}
+
+	packages, ok := packagesAny.(*ordered.OrderedMap)
+	if !ok {
+		return nil, errors.New(`"packages" key is present, but not a map`)
+	}
+
+	return packages, nil
+}
+
+// lockPackage returns the package at `location` in the `package-lock.json`
+// packages map, or `nil` if there is no such package.
+func lockPackage(packageLock *ordered.OrderedMap, location string) (*ordered.OrderedMap, error) {
+	packages, err := lockPackages(packageLock)
+	if err != nil || packages == nil {
+		return nil, err
+	}
+
+	packageAny, ok := packages.GetValue(location)
+	if !ok {
+		return nil, nil
+	}
+
+	m, ok := packageAny.(*ordered.OrderedMap)
+	if !ok {
+		return nil, fmt.Errorf("package %q does not point at a map", location)
+	}
+
+	return m, nil
+}
+
+// lookupPackage finds the package that `name` resolves to when it is required
+// by the package at `location`. This follows the `node` module lookup, i.e. it
+// checks the `node_modules` directory of `location` and then that of every
+// ancestor up to the project root.
+func lookupPackage(packages *ordered.OrderedMap, location, name string) (string, *ordered.OrderedMap, bool) {
+	// NOTE: Use a bounded for loop to avoid an accidental infinite loop.
+	for i := 0; i < 10000; i++ {
+		candidate := path.Join(location, "node_modules", name)
+		m, ok := packages.Get(candidate).(*ordered.OrderedMap)
+		if ok {
+			return candidate, m, true
+		}
+
+		if location == "" {
+			break
+		}
+		location = parentLocation(location)
+	}
+
+	return "", nil, false
+}
+
+// parentLocation determines the location of the package whose `node_modules`
+// directory contains `location`, e.g. `node_modules/a` for
+// `node_modules/a/node_modules/@b/c`. For a top-level package, the parent is
+// the project root (i.e. `""`).
+func parentLocation(location string) string {
+	i := strings.LastIndex(location, "/node_modules/")
+	if i < 0 {
+		return ""
+	}
+	return location[:i]
+}
+
+// requestorDir determines the directory (relative to the project root) that
+// `npm` resolves the `file:` dependencies of a package against. For a package
+// installed from a local archive, this is the directory that contains the
+// archive, otherwise it is the location of the package.
+func requestorDir(location string, m *ordered.OrderedMap) string {
+	resolved, _ := m.Get("resolved").(string)
+	if strings.HasPrefix(resolved, "file:") && !isLink(m) {
+		return path.Dir(strings.TrimPrefix(resolved, "file:"))
+	}
+	return location
+}
+
+// fileSpec creates a `file:` dependency specifier that refers to `target` when
+// resolved against `dir` (both relative to the project root).
+func fileSpec(dir, target string) (string, error) {
+	rel, err := filepath.Rel(filepath.FromSlash("/"+dir), filepath.FromSlash("/"+target))
+	if err != nil {
+		return "", err
+	}
+
+	return "file:" + filepath.ToSlash(rel), nil
+}
+
+// isLink determines if a package in the `package-lock.json` packages map is a
+// symbolic link (e.g. to a workspace).
+func isLink(m *ordered.OrderedMap) bool {
+	link, _ := m.Get("link").(bool)
+	return link
+}
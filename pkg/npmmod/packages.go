@@ -0,0 +1,242 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+)
+
+// NOTE: Ensure that
+//       * `DescribePackages{}.Visit` satisfies `VisitorFunc`.
+var (
+	_ VisitorFunc = (&DescribePackages{}).Visit
+)
+
+// Package describes a vendored package archive in a `.npm-mod.tidy.json`.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Archive is the filename of the package archive in `vendor/`, i.e.
+	// `RegistryPackage.Filename()`. It is empty if the package is not a
+	// registry package.
+	Archive string `json:"filename"`
+	RegistryPackage
+	// Mirrored is the URL of the package archive in the mirror registry that
+	// `package-lock.json` refers to in `ModeRegistry`.
+	Mirrored string `json:"mirrored,omitempty"`
+	// Paths are the locations of the package in the `package-lock.json`
+	// packages map, e.g. `node_modules/a/node_modules/b`.
+	Paths []string `json:"paths"`
+	// Dev, Optional and Peer are set if the package is a dev, optional or
+	// peer dependency at **every** location in `Paths`.
+	Dev      bool `json:"dev,omitempty"`
+	Optional bool `json:"optional,omitempty"`
+	Peer     bool `json:"peer,omitempty"`
+}
+
+// NewPackage describes a registry package based on its URL, i.e. without any
+// of the information from the `package-lock.json` packages map. If the URL is
+// not a registry URL, the name, version and filename are left empty.
+func NewPackage(rp RegistryPackage) Package {
+	p := Package{RegistryPackage: rp, Paths: []string{}}
+	name, _, err := splitRegistryURL(rp.URL)
+	if err != nil {
+		return p
+	}
+	version, err := VersionFromURL(rp.URL)
+	if err != nil {
+		return p
+	}
+	filename, err := rp.Filename()
+	if err != nil {
+		return p
+	}
+
+	p.Name = name
+	p.Version = version
+	p.Archive = filename
+	return p
+}
+
+// ArchiveFilename returns `Archive`, or an error if the package is not a
+// registry package (i.e. it has no package archive in `vendor/`).
+func (p Package) ArchiveFilename() (string, error) {
+	if p.Archive == "" {
+		return "", fmt.Errorf("package has no package archive filename; url: %s", p.URL)
+	}
+	return p.Archive, nil
+}
+
+// TrackedFilenames collects the package archive filenames of `packages`, i.e.
+// the files in `vendor/` that are tracked in a `.npm-mod.tidy.json`.
+func TrackedFilenames(packages []Package) map[string]bool {
+	tracked := map[string]bool{}
+	for _, p := range packages {
+		tracked[p.Archive] = true
+	}
+	return tracked
+}
+
+// DescribePackages produces a visitor function that collects the metadata
+// (e.g. locations and dev / optional / peer flags) for every registry package
+// in the `package-lock.json` packages map.
+type DescribePackages struct {
+	Registry map[string]RegistryPackage
+	ByURL    map[string]*Package
+}
+
+// Visit is a visitor function that **collects** the metadata of a package in
+// the `package-lock.json` packages map.
+func (dp *DescribePackages) Visit(_ *ordered.OrderedMap, k string, v any) error {
+	location := k
+	if location == "" {
+		return nil
+	}
+
+	m, ok := v.(*ordered.OrderedMap)
+	if !ok {
+		return fmt.Errorf("package %q does not point at a map", location)
+	}
+
+	resolved, _ := m.Get("resolved").(string)
+	rp, ok := dp.Registry[resolved]
+	if !ok {
+		return nil
+	}
+
+	dev, _ := m.Get("dev").(bool)
+	optional, _ := m.Get("optional").(bool)
+	peer, _ := m.Get("peer").(bool)
+
+	p, ok := dp.ByURL[resolved]
+	if !ok {
+		described := NewPackage(rp)
+		p = &described
+		p.Dev, p.Optional, p.Peer = dev, optional, peer
+		if p.Name == "" {
+			p.Name = locationName(location)
+		}
+		if version, _ := m.Get("version").(string); p.Version == "" && !isVendorReference(version) {
+			p.Version = version
+		}
+		dp.ByURL[resolved] = p
+	}
+
+	p.Paths = append(p.Paths, location)
+	p.Dev = p.Dev && dev
+	p.Optional = p.Optional && optional
+	p.Peer = p.Peer && peer
+	return nil
+}
+
+// describePackages describes every registry package in `byURL` (sorted by
+// URL) using the metadata from the `package-lock.json` packages map.
+func describePackages(packageLock *ordered.OrderedMap, byURL map[string]RegistryPackage) ([]Package, error) {
+	dp := DescribePackages{Registry: byURL, ByURL: map[string]*Package{}}
+	err := walkPackageLockPackages(packageLock, dp.Visit)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: Packages that are only in the legacy `dependencies` map (e.g. for
+	//       `lockfileVersion: 1`) are described based on their nesting.
+	legacy := DescribePackages{Registry: byURL, ByURL: map[string]*Package{}}
+	err = describeLegacyDependencies(packageLock, "", &legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := resolvedKeys(byURL)
+	packages := make([]Package, len(keys))
+	for i, k := range keys {
+		p, ok := dp.ByURL[k]
+		if !ok {
+			p, ok = legacy.ByURL[k]
+		}
+		if !ok {
+			packages[i] = NewPackage(byURL[k])
+			continue
+		}
+		packages[i] = *p
+	}
+
+	return packages, nil
+}
+
+// describeLegacyDependencies describes every package in the legacy
+// `package-lock.json` dependencies map (and so on recursively). The location
+// of each package is determined from the nesting, e.g. `b` within the
+// dependencies of `a` is at `node_modules/a/node_modules/b`.
+func describeLegacyDependencies(hasDependencies *ordered.OrderedMap, prefix string, dp *DescribePackages) error {
+	depsAny, ok := hasDependencies.GetValue("dependencies")
+	if !ok {
+		// Early exit if the dependencies key is absent
+		return nil
+	}
+
+	deps, ok := depsAny.(*ordered.OrderedMap)
+	if !ok {
+		return errors.New(`"dependencies" key is present, but not a map`)
+	}
+
+	nextPair := deps.EntriesIter()
+	// NOTE: Use a bounded for loop to avoid an accidental infinite loop.
+	loopComplete := false
+	for i := 0; i < 10000; i++ {
+		pair, ok := nextPair()
+		if !ok {
+			loopComplete = true
+			break
+		}
+
+		location := path.Join(prefix, "node_modules", pair.Key)
+		err := dp.Visit(deps, location, pair.Value)
+		if err != nil {
+			return err
+		}
+
+		dependencyMap, ok := pair.Value.(*ordered.OrderedMap)
+		if !ok {
+			return fmt.Errorf("dependency %q does not point at a map", pair.Key)
+		}
+
+		err = describeLegacyDependencies(dependencyMap, location, dp)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !loopComplete {
+		return errors.New("loop over dependencies never terminated")
+	}
+
+	return nil
+}
+
+// locationName determines the package name from a location in the
+// `package-lock.json` packages map, e.g. `@b/c` for
+// `node_modules/a/node_modules/@b/c`.
+func locationName(location string) string {
+	i := strings.LastIndex(location, "node_modules/")
+	if i < 0 {
+		return location
+	}
+	return location[i+len("node_modules/"):]
+}
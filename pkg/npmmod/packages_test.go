@@ -0,0 +1,205 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestGenerateTidyFile_Packages(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	tf, err := npmmod.GenerateTidyFile(filepath.Join("testdata", "ranges"))
+	assert.Nil(err)
+
+	actual := []string{}
+	for _, p := range tf.Packages {
+		actual = append(actual, fmt.Sprintf("%s@%s %s %v dev=%t", p.Name, p.Version, p.Archive, p.Paths, p.Dev))
+	}
+	expected := []string{
+		"@s/d@2.1.4 s__d-2.1.4.tgz [node_modules/@s/d] dev=false",
+		"a@1.0.0 a-1.0.0.tgz [node_modules/a] dev=false",
+		"b@1.0.3 b-1.0.3.tgz [node_modules/b] dev=false",
+		"c@1.2.0 c-1.2.0.tgz [node_modules/a/node_modules/c] dev=false",
+		"c@2.0.0 c-2.0.0.tgz [node_modules/c] dev=true",
+	}
+	assert.Equal(expected, actual)
+}
+
+func TestReadTidyFile_Migrate(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Fixture    string
+		Version    string
+		Unverified bool
+	}
+
+	cases := []testCase{
+		{Fixture: "legacy.npm-mod.tidy.json", Version: "22.05"},
+		{Fixture: "snapshot.npm-mod.tidy.json", Version: "26.10", Unverified: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Version, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			root := tempDir(t, assert)
+			data, err := os.ReadFile(filepath.Join("testdata", tc.Fixture))
+			assert.Nil(err)
+			err = os.WriteFile(filepath.Join(root, ".npm-mod.tidy.json"), data, 0644)
+			assert.Nil(err)
+
+			tf, err := npmmod.ReadTidyFile(root)
+			assert.Nil(err)
+			assert.Equal(tc.Version, tf.MigratedFrom)
+			assert.Equal("26.11", tf.Version)
+
+			// Migrating is equivalent to generating (and tidying) the tidy
+			// file from scratch.
+			expected, err := npmmod.GenerateTidyFile("testdata")
+			assert.Nil(err)
+			err = expected.TidyPackageJSON(npmmod.NewTransaction())
+			assert.Nil(err)
+			err = expected.TidyPackageLockJSON(npmmod.NewTransaction())
+			assert.Nil(err)
+			assert.Equal(expected.Packages, tf.Packages)
+			assert.Equal(expected.Patch.PackageJSON.Changes, tf.Patch.PackageJSON.Changes)
+			assert.Equal(expected.Patch.PackageLockJSON.Changes, tf.Patch.PackageLockJSON.Changes)
+			assert.Empty(tf.Unmatched)
+
+			// NOTE: Only the tidied files written with a 22.05 tidy file can
+			//       be reproduced.
+			assert.Equal(tc.Unverified, tf.Patch.PackageJSON.Unverified())
+			assert.Equal(tc.Unverified, tf.Patch.PackageLockJSON.Unverified())
+		})
+	}
+}
+
+func TestReadTidyFile_MigrateNoDrift(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	// A project tidied by `npm-mod` with a 22.05 tidy file (and not changed
+	// since) must not appear to have drifted after migrating.
+	root := copyProject(t, assert, "legacy-project")
+	data, err := os.ReadFile(filepath.Join("testdata", "legacy-project", ".npm-mod.tidy.json"))
+	assert.Nil(err)
+	err = os.WriteFile(filepath.Join(root, ".npm-mod.tidy.json"), data, 0644)
+	assert.Nil(err)
+
+	tf, err := npmmod.ReadTidyFile(root)
+	assert.Nil(err)
+	assert.Equal("22.05", tf.MigratedFrom)
+	for _, name := range []string{"package.json", "package-lock.json"} {
+		fp := tf.Patch.PackageJSON
+		if name == "package-lock.json" {
+			fp = tf.Patch.PackageLockJSON
+		}
+		data, err := os.ReadFile(filepath.Join(root, name))
+		assert.Nil(err)
+		assert.False(fp.Drifted(data), name)
+		err = fp.Revert(readProjectJSON(assert, root, name), true)
+		assert.Nil(err, name)
+	}
+
+	txn := npmmod.NewTransaction()
+	err = tf.Restore(txn)
+	assert.Nil(err)
+	assert.Empty(tf.Drifted)
+	err = txn.Commit()
+	assert.Nil(err)
+	for _, name := range []string{"package.json", "package-lock.json"} {
+		expected, err := os.ReadFile(filepath.Join("testdata", "project", name))
+		assert.Nil(err)
+		actual, err := os.ReadFile(filepath.Join(root, name))
+		assert.Nil(err)
+		assert.Equal(string(expected), string(actual), name)
+	}
+}
+
+func TestReadTidyFile_MigrateDrift(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	// Simulate an edit after the last tidy with a 22.05 tidy file.
+	root := copyProject(t, assert, "legacy-project")
+	data, err := os.ReadFile(filepath.Join("testdata", "legacy-project", ".npm-mod.tidy.json"))
+	assert.Nil(err)
+	err = os.WriteFile(filepath.Join(root, ".npm-mod.tidy.json"), data, 0644)
+	assert.Nil(err)
+	pjFilename := filepath.Join(root, "package.json")
+	pj := readProjectJSON(assert, root, "package.json")
+	pj.Set("description", "changed after tidy")
+	writeJSON(assert, pjFilename, pj)
+
+	tf, err := npmmod.ReadTidyFile(root)
+	assert.Nil(err)
+	packageJSON, err := os.ReadFile(pjFilename)
+	assert.Nil(err)
+	assert.True(tf.Patch.PackageJSON.Drifted(packageJSON))
+
+	err = tf.Restore(npmmod.NewTransaction())
+	assert.Nil(err)
+	assert.Equal([]string{"package.json"}, tf.Drifted)
+	assert.Empty(tf.Unverified)
+}
+
+func TestReadTidyFile_Version(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Version string
+		Error   string
+	}
+
+	cases := []testCase{
+		{Version: "", Error: `%s has no version; expected "26.11"`},
+		{Version: "21.01", Error: `%s has unsupported version "21.01"; expected "26.11" (or "26.10" or "22.05", which are migrated automatically)`},
+		{Version: "27.04", Error: `%s has version "27.04", which is newer than the supported version "26.11"; upgrade npm-mod`},
+		{Version: "26.12", Error: `%s has version "26.12", which is newer than the supported version "26.11"; upgrade npm-mod`},
+		{Version: "100.01", Error: `%s has version "100.01", which is newer than the supported version "26.11"; upgrade npm-mod`},
+		{Version: "9.01", Error: `%s has unsupported version "9.01"; expected "26.11" (or "26.10" or "22.05", which are migrated automatically)`},
+		{Version: "next", Error: `%s has unsupported version "next"; expected "26.11" (or "26.10" or "22.05", which are migrated automatically)`},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Version, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			root := tempDir(t, assert)
+			filename := filepath.Join(root, ".npm-mod.tidy.json")
+			data := fmt.Sprintf(`{"version": %q}`, tc.Version)
+			err := os.WriteFile(filename, []byte(data), 0644)
+			assert.Nil(err)
+
+			tf, err := npmmod.ReadTidyFile(root)
+			assert.Nil(tf)
+			assert.Equal(fmt.Sprintf(tc.Error, filename), fmt.Sprintf("%v", err))
+		})
+	}
+}
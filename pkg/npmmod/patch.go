@@ -0,0 +1,325 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+)
+
+var (
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+// Patch is the reversible delta between the original `package.json` and
+// `package-lock.json` and the tidied ones.
+type Patch struct {
+	PackageJSON     FilePatch `json:"package.json"`
+	PackageLockJSON FilePatch `json:"package-lock.json"`
+}
+
+// rebuild serializes a reverted `package.json` and `package-lock.json` in the
+// format of the original files. Unlike `FilePatch.Rebuild()`, the files may
+// have changed since the last tidy, so they are not checked.
+func (p Patch) rebuild(pj, pl *ordered.OrderedMap) ([]byte, []byte, error) {
+	packageJSON, err := p.PackageJSON.Format.marshal(pj)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	packageLock, err := p.PackageLockJSON.Format.marshal(pl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return packageJSON, packageLock, nil
+}
+
+// FilePatch is the reversible delta for a single file. It records every key
+// that was rewritten by `npm-mod tidy` along with a checksum of the tidied
+// file, so that changes made after the last tidy can be detected.
+type FilePatch struct {
+	// Checksum is a checksum of the tidied file. It is empty if the patch was
+	// migrated from a `.npm-mod.tidy.json` whose tidied files can't be
+	// reproduced.
+	Checksum string `json:"checksum"`
+	// OriginalChecksum is a checksum of the original file, so that restoring
+	// it can be verified.
+	OriginalChecksum string `json:"original-checksum,omitempty"`
+	// Format is the format of the original file (which the tidied file
+	// keeps). It is `nil` for the npm default format.
+	Format  *JSONFormat `json:"format,omitempty"`
+	Changes []Change    `json:"changes"`
+}
+
+// Change is a single key rewritten by `npm-mod tidy`, e.g. a dependency
+// specifier or a `resolved` URL. The `Path` is a JSON Pointer (RFC 6901) to the
+// key, e.g. `/packages/node_modules~1a/resolved`; the `Original` is `nil` if
+// the key was absent.
+type Change struct {
+	Path     string  `json:"path"`
+	Original *string `json:"original"`
+	Tidied   string  `json:"tidied"`
+}
+
+// NewFilePatch computes the reversible delta between an `original` file
+// (serialized as `originalJSON`) and the `tidied` one. The tidied file is
+// serialized in the same format as the original and returned along with the
+// patch. Only rewritten string values are supported; any other difference is
+// an error.
+func NewFilePatch(original, tidied *ordered.OrderedMap, originalJSON []byte) (FilePatch, []byte, error) {
+	changes := []Change{}
+	err := diffMaps(nil, original, tidied, &changes)
+	if err != nil {
+		return FilePatch{}, nil, err
+	}
+
+	format := DetectJSONFormat(originalJSON)
+	tidiedJSON, err := format.marshal(tidied)
+	if err != nil {
+		return FilePatch{}, nil, err
+	}
+
+	fp := FilePatch{
+		Checksum:         checksum(tidiedJSON),
+		OriginalChecksum: checksum(originalJSON),
+		Format:           format,
+		Changes:          changes,
+	}
+	return fp, tidiedJSON, nil
+}
+
+// Rebuild serializes a reverted file in the format of the original. If the
+// tidied file had not changed since the last tidy, the result is checked
+// against the checksum of the original.
+func (fp FilePatch) Rebuild(m *ordered.OrderedMap, drifted bool) ([]byte, error) {
+	data, err := fp.Format.marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if !drifted && fp.OriginalChecksum != "" && checksum(data) != fp.OriginalChecksum {
+		return nil, errors.New("rebuilt file does not match the checksum of the original")
+	}
+	return data, nil
+}
+
+// Drifted determines if a file has changed since it was tidied. An unverified
+// patch can't tell, so the file is assumed to have changed.
+func (fp FilePatch) Drifted(data []byte) bool {
+	return fp.Checksum != checksum(data)
+}
+
+// Unverified determines if the patch has no checksum of the tidied file to
+// detect changes with.
+func (fp FilePatch) Unverified() bool {
+	return fp.Checksum == ""
+}
+
+// Revert applies the delta in reverse to a tidied file (in place). A key that
+// no longer has its tidied value (e.g. because the package was updated since
+// the last tidy) is left as-is; if `strict` is set this is an error unless the
+// key already has its original value. Keys that have been removed since the
+// last tidy are ignored.
+func (fp FilePatch) Revert(m *ordered.OrderedMap, strict bool) error {
+	conflicts := []string{}
+	for _, c := range fp.Changes {
+		parent, key, ok := lookupParent(m, splitPointer(c.Path))
+		if !ok || !parent.Has(key) {
+			continue
+		}
+
+		current, isString := parent.Get(key).(string)
+		if isString && current == c.Tidied {
+			if c.Original == nil {
+				parent.Delete(key)
+			} else {
+				parent.Set(key, *c.Original)
+			}
+			continue
+		}
+
+		if !strict || (isString && c.Original != nil && current == *c.Original) {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s is %s; expected %q", c.Path, describeValue(parent.Get(key)), c.Tidied))
+	}
+
+	if len(conflicts) > 0 {
+		return &ProblemsError{Summary: "changed since the last tidy", Problems: conflicts}
+	}
+	return nil
+}
+
+// Originals creates a sparse copy of the original file that only contains
+// the keys that were rewritten.
+func (fp FilePatch) Originals() *ordered.OrderedMap {
+	root := ordered.NewOrderedMap()
+	for _, c := range fp.Changes {
+		path := splitPointer(c.Path)
+		if c.Original == nil || len(path) == 0 {
+			continue
+		}
+
+		m := root
+		for _, key := range path[:len(path)-1] {
+			child, ok := m.Get(key).(*ordered.OrderedMap)
+			if !ok {
+				child = ordered.NewOrderedMap()
+				m.Set(key, child)
+			}
+			m = child
+		}
+		m.Set(path[len(path)-1], *c.Original)
+	}
+
+	return root
+}
+
+// diffMaps collects the rewritten string values in `tidied` (compared to
+// `original`) in the order of the keys in `tidied`.
+func diffMaps(path []string, original, tidied *ordered.OrderedMap, changes *[]Change) error {
+	nextPair := original.EntriesIter()
+	// NOTE: Use a bounded for loop to avoid an accidental infinite loop.
+	loopComplete := false
+	for i := 0; i < 10000; i++ {
+		pair, ok := nextPair()
+		if !ok {
+			loopComplete = true
+			break
+		}
+
+		if !tidied.Has(pair.Key) {
+			return fmt.Errorf("cannot patch %s; key was removed", joinPointer(childPath(path, pair.Key)))
+		}
+	}
+	if !loopComplete {
+		return errors.New("loop over original keys never terminated")
+	}
+
+	nextPair = tidied.EntriesIter()
+	loopComplete = false
+	for i := 0; i < 10000; i++ {
+		pair, ok := nextPair()
+		if !ok {
+			loopComplete = true
+			break
+		}
+
+		keyPath := childPath(path, pair.Key)
+		originalValue, hasOriginal := original.GetValue(pair.Key)
+		originalMap, originalIsMap := originalValue.(*ordered.OrderedMap)
+		tidiedMap, tidiedIsMap := pair.Value.(*ordered.OrderedMap)
+		if originalIsMap && tidiedIsMap {
+			err := diffMaps(keyPath, originalMap, tidiedMap, changes)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if hasOriginal && reflect.DeepEqual(originalValue, pair.Value) {
+			continue
+		}
+
+		tidiedString, ok := pair.Value.(string)
+		if !ok {
+			return fmt.Errorf("cannot patch %s; only string values can be rewritten", joinPointer(keyPath))
+		}
+
+		c := Change{Path: joinPointer(keyPath), Tidied: tidiedString}
+		if hasOriginal {
+			originalString, ok := originalValue.(string)
+			if !ok {
+				return fmt.Errorf("cannot patch %s; only string values can be rewritten", c.Path)
+			}
+			c.Original = &originalString
+		}
+		*changes = append(*changes, c)
+	}
+	if !loopComplete {
+		return errors.New("loop over tidied keys never terminated")
+	}
+
+	return nil
+}
+
+// lookupParent finds the map that contains the last key in `path`.
+func lookupParent(m *ordered.OrderedMap, path []string) (*ordered.OrderedMap, string, bool) {
+	if len(path) == 0 {
+		return nil, "", false
+	}
+
+	for _, key := range path[:len(path)-1] {
+		child, ok := m.Get(key).(*ordered.OrderedMap)
+		if !ok {
+			return nil, "", false
+		}
+		m = child
+	}
+
+	return m, path[len(path)-1], true
+}
+
+// describeValue describes the current value of a key for an error message.
+func describeValue(value any) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// joinPointer creates a JSON Pointer (RFC 6901) from a sequence of keys.
+func joinPointer(path []string) string {
+	var b strings.Builder
+	for _, key := range path {
+		b.WriteString("/")
+		b.WriteString(pointerEscaper.Replace(key))
+	}
+	return b.String()
+}
+
+// splitPointer splits a JSON Pointer (RFC 6901) into a sequence of keys.
+func splitPointer(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, part := range parts {
+		parts[i] = pointerUnescaper.Replace(part)
+	}
+	return parts
+}
+
+// childPath extends a path with a key (without sharing the backing array).
+func childPath(path []string, key string) []string {
+	extended := make([]string, len(path), len(path)+1)
+	copy(extended, path)
+	return append(extended, key)
+}
+
+// checksum computes a subresource integrity style checksum of a file.
+func checksum(data []byte) string {
+	sum := sha512.Sum512(data)
+	return "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,237 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+)
+
+func TestNewFilePatch(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	original := parseJSON(assert, `{
+  "packages": {
+    "node_modules/@s/d": {"resolved": "https://registry.npmjs.org/@s/d/-/d-2.1.4.tgz", "dev": true},
+    "node_modules/a~b": {"version": "1.0.0"}
+  }
+}`)
+	tidied := parseJSON(assert, `{
+  "packages": {
+    "node_modules/@s/d": {"resolved": "file:vendor/s__d-2.1.4.tgz", "dev": true},
+    "node_modules/a~b": {"version": "1.0.0", "resolved": "file:vendor/a~b-1.0.0.tgz"}
+  }
+}`)
+	fp, tidiedJSON, err := npmmod.NewFilePatch(original, tidied, []byte("{}\n"))
+	assert.Nil(err)
+
+	d := "https://registry.npmjs.org/@s/d/-/d-2.1.4.tgz"
+	expected := []npmmod.Change{
+		{Path: "/packages/node_modules~1@s~1d/resolved", Original: &d, Tidied: "file:vendor/s__d-2.1.4.tgz"},
+		{Path: "/packages/node_modules~1a~0b/resolved", Original: nil, Tidied: "file:vendor/a~b-1.0.0.tgz"},
+	}
+	assert.Equal(expected, fp.Changes)
+	assert.False(fp.Drifted(tidiedJSON))
+	assert.True(fp.Drifted(append(tidiedJSON, '\n')))
+
+	// Reverting the patch restores the original exactly.
+	err = fp.Revert(tidied, true)
+	assert.Nil(err)
+	assert.Equal(marshal(assert, original), marshal(assert, tidied))
+
+	originals := fp.Originals()
+	assert.Equal(`{"packages":{"node_modules/@s/d":{"resolved":"https://registry.npmjs.org/@s/d/-/d-2.1.4.tgz"}}}`, marshal(assert, originals))
+}
+
+func TestNewFilePatch_Error(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	original := parseJSON(assert, `{"dependencies": {"a": "^1.0.0", "b": "^2.0.0"}, "files": ["a"]}`)
+	tidied := parseJSON(assert, `{"dependencies": {"a": "^1.0.0"}, "files": ["a"]}`)
+	_, _, err := npmmod.NewFilePatch(original, tidied, nil)
+	assert.Equal("cannot patch /dependencies/b; key was removed", fmt.Sprintf("%v", err))
+
+	tidied = parseJSON(assert, `{"dependencies": {"a": "^1.0.0", "b": "^2.0.0"}, "files": ["b"]}`)
+	_, _, err = npmmod.NewFilePatch(original, tidied, nil)
+	assert.Equal("cannot patch /files; only string values can be rewritten", fmt.Sprintf("%v", err))
+}
+
+func TestFilePatch_Rebuild(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Name     string
+		Original string
+		Format   *npmmod.JSONFormat
+	}
+
+	cases := []testCase{
+		{Name: "default", Original: "{\n  \"a\": {\n    \"b\": \"^1.0.0\"\n  }\n}\n"},
+		{
+			Name:     "four spaces",
+			Original: "{\n    \"a\": {\n        \"b\": \"^1.0.0\"\n    }\n}\n",
+			Format:   &npmmod.JSONFormat{Indent: "    "},
+		},
+		{
+			Name:     "tabs",
+			Original: "{\n\t\"a\": {\n\t\t\"b\": \"^1.0.0\"\n\t}\n}\n",
+			Format:   &npmmod.JSONFormat{Indent: "\t"},
+		},
+		{
+			Name:     "CRLF",
+			Original: "{\r\n  \"a\": {\r\n    \"b\": \"^1.0.0\"\r\n  }\r\n}\r\n",
+			Format:   &npmmod.JSONFormat{Indent: "  ", CRLF: true},
+		},
+		{
+			Name:     "no final newline",
+			Original: "{\n  \"a\": {\n    \"b\": \"^1.0.0\"\n  }\n}",
+			Format:   &npmmod.JSONFormat{Indent: "  ", NoFinalNewline: true},
+		},
+		{
+			Name:     "single line",
+			Original: `{"a":{"b":"^1.0.0"}}`,
+			Format:   &npmmod.JSONFormat{NoFinalNewline: true},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			original := parseJSON(assert, tc.Original)
+			tidied := parseJSON(assert, `{"a": {"b": "file:vendor/b-1.0.0.tgz"}}`)
+			fp, tidiedJSON, err := npmmod.NewFilePatch(original, tidied, []byte(tc.Original))
+			assert.Nil(err)
+			assert.Equal(tc.Format, fp.Format)
+			assert.Equal(tc.Format, npmmod.DetectJSONFormat(tidiedJSON))
+
+			err = fp.Revert(tidied, true)
+			assert.Nil(err)
+			rebuilt, err := fp.Rebuild(tidied, false)
+			assert.Nil(err)
+			assert.Equal(tc.Original, string(rebuilt))
+		})
+	}
+}
+
+func TestFilePatch_Rebuild_Mismatch(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	// NOTE: The spaces after the colons can't be reproduced.
+	originalJSON := `{"a": {"b": "^1.0.0"}}`
+	original := parseJSON(assert, originalJSON)
+	tidied := parseJSON(assert, `{"a": {"b": "file:vendor/b-1.0.0.tgz"}}`)
+	fp, _, err := npmmod.NewFilePatch(original, tidied, []byte(originalJSON))
+	assert.Nil(err)
+
+	err = fp.Revert(tidied, true)
+	assert.Nil(err)
+	_, err = fp.Rebuild(tidied, false)
+	assert.Equal("rebuilt file does not match the checksum of the original", fmt.Sprintf("%v", err))
+
+	// A file that changed since the last tidy can't be checked.
+	rebuilt, err := fp.Rebuild(tidied, true)
+	assert.Nil(err)
+	assert.Equal(`{"a":{"b":"^1.0.0"}}`, string(rebuilt))
+}
+
+func TestFilePatch_Revert(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Name     string
+		Current  string
+		Strict   bool
+		Expected string
+		Error    string
+	}
+
+	cases := []testCase{
+		{
+			Name:     "tidied",
+			Current:  `{"dependencies":{"a":"file:vendor/a-1.0.0.tgz","b":"file:vendor/b-2.0.0.tgz"}}`,
+			Strict:   true,
+			Expected: `{"dependencies":{"a":"^1.0.0","b":"^2.0.0"}}`,
+			Error:    "<nil>",
+		},
+		{
+			Name:     "reverted-or-removed",
+			Current:  `{"dependencies":{"a":"^1.0.0","c":"^3.0.0"}}`,
+			Strict:   true,
+			Expected: `{"dependencies":{"a":"^1.0.0","c":"^3.0.0"}}`,
+			Error:    "<nil>",
+		},
+		{
+			Name:     "updated",
+			Current:  `{"dependencies":{"a":"^1.1.0","b":"file:vendor/b-2.0.0.tgz"}}`,
+			Strict:   false,
+			Expected: `{"dependencies":{"a":"^1.1.0","b":"^2.0.0"}}`,
+			Error:    "<nil>",
+		},
+		{
+			Name:    "updated-strict",
+			Current: `{"dependencies":{"a":"^1.1.0","b":"file:vendor/b-2.0.0.tgz"}}`,
+			Strict:  true,
+			Error:   "changed since the last tidy:\n- /dependencies/a is \"^1.1.0\"; expected \"file:vendor/a-1.0.0.tgz\"",
+		},
+	}
+
+	a, b := "^1.0.0", "^2.0.0"
+	fp := npmmod.FilePatch{
+		Changes: []npmmod.Change{
+			{Path: "/dependencies/a", Original: &a, Tidied: "file:vendor/a-1.0.0.tgz"},
+			{Path: "/dependencies/b", Original: &b, Tidied: "file:vendor/b-2.0.0.tgz"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			m := parseJSON(assert, tc.Current)
+			err := fp.Revert(m, tc.Strict)
+			assert.Equal(tc.Error, fmt.Sprintf("%v", err))
+			if err == nil {
+				assert.Equal(tc.Expected, marshal(assert, m))
+			}
+		})
+	}
+}
+
+func parseJSON(assert *testifyassert.Assertions, data string) *ordered.OrderedMap {
+	m := ordered.NewOrderedMap()
+	err := json.Unmarshal([]byte(data), &m)
+	assert.Nil(err)
+	return m
+}
+
+func marshal(assert *testifyassert.Assertions, m *ordered.OrderedMap) string {
+	asJSON, err := json.Marshal(m)
+	assert.Nil(err)
+	return string(asJSON)
+}
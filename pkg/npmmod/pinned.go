@@ -0,0 +1,187 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+	"github.com/hardfinhq/npm-mod/pkg/semver"
+)
+
+// NOTE: Ensure that
+//       * `PackageJSONPin{}.Replace` satisfies `ReplacePairFunc`.
+var (
+	_ ReplacePairFunc = (&PackageJSONPin{}).Replace
+)
+
+// PinMode determines if tidy pins the `package.json` version ranges to the
+// exact versions in `package-lock.json` rather than vendoring package
+// archives.
+func (tf *TidyFile) PinMode() bool {
+	return tf.Mode == ModePin
+}
+
+// PackageJSONPin provides a `replace` helper that replaces a `package.json`
+// version range with the exact version resolved in the `package-lock.json`.
+type PackageJSONPin struct {
+	ByNodeModulesPath map[string]RegistryPackage
+	// Unmatched collects every package version that could not be pinned.
+	Unmatched []Unmatched
+}
+
+// Replace replaces a `package.json` version range with the exact version
+// resolved in the `package-lock.json`, e.g. `^18.0.0` becomes `18.2.0` and
+// `npm:string-width@^4.2.0` becomes `npm:string-width@4.2.3`. In the case that
+// the specifier is not a version range or the package can't be matched, this
+// just returns the `version` and records it in `Unmatched`. If the resolved
+// version does not satisfy the version range in `package.json`, this returns
+// an error.
+func (pjp *PackageJSONPin) Replace(name, version string) (string, error) {
+	if strings.HasPrefix(version, "file:") {
+		pjp.unmatched(name, version, "not a version range")
+		return version, nil
+	}
+	_, err := semver.ParseRange(rangeFromSpecifier(version))
+	if err != nil {
+		pjp.unmatched(name, version, "not a version range")
+		return version, nil
+	}
+
+	key := fmt.Sprintf("node_modules/%s", name)
+	rp, ok := pjp.ByNodeModulesPath[key]
+	if !ok {
+		pjp.unmatched(name, version, fmt.Sprintf("%s not in package-lock.json", key))
+		return version, nil
+	}
+
+	resolved, err := VersionFromURL(rp.URL)
+	if err != nil {
+		pjp.unmatched(name, version, err.Error())
+		return version, nil
+	}
+
+	err = checkRange("resolved version", name, version, rp)
+	if err != nil {
+		return "", err
+	}
+
+	return pinSpecifier(version, resolved), nil
+}
+
+func (pjp *PackageJSONPin) unmatched(name, version, reason string) {
+	u := Unmatched{File: "package.json", Name: name, Specifier: version, Reason: reason}
+	pjp.Unmatched = append(pjp.Unmatched, u)
+}
+
+// pinSpecifier replaces the version range in a specifier with an exact
+// version, keeping the alias of an `npm:` specifier.
+func pinSpecifier(specifier, version string) string {
+	alias := strings.TrimPrefix(specifier, "npm:")
+	if alias == specifier {
+		return version
+	}
+
+	i := strings.LastIndex(alias, "@")
+	if i <= 0 {
+		return specifier + "@" + version
+	}
+	return "npm:" + alias[:i+1] + version
+}
+
+// pinPackageJSON replaces every version range in a `package.json` (in place)
+// with the exact version resolved in the `package-lock.json`.
+func (tf *TidyFile) pinPackageJSON(pj *ordered.OrderedMap) error {
+	byNodeModulesPath, _, err := PackageLockExtractDependencies(tf.PackageLockParsed)
+	if err != nil {
+		return err
+	}
+
+	pjp := PackageJSONPin{ByNodeModulesPath: byNodeModulesPath}
+	err = PackageJSONReplaceDependencies(pj, pjp.Replace)
+	if err != nil {
+		return err
+	}
+	tf.Unmatched = append(tf.Unmatched, pjp.Unmatched...)
+	return nil
+}
+
+// pinPackageLockJSON keeps the root package of a `package-lock.json` (in place)
+// in agreement with the pinned `package.json`. The `resolved` URLs are left
+// as-is.
+func (tf *TidyFile) pinPackageLockJSON(pl *ordered.OrderedMap, byNodeModulesPath map[string]RegistryPackage) error {
+	for i := range tf.Packages {
+		tf.Packages[i].Mirrored = ""
+	}
+
+	// NOTE: The root package mirrors the `package.json` dependencies, so it
+	//       gets the same replacements as `TidyPackageJSON()` (which already
+	//       reports the unmatched dependencies).
+	pjp := PackageJSONPin{ByNodeModulesPath: byNodeModulesPath}
+	return PackageLockReplaceRoot(pl, pjp.Replace)
+}
+
+// revertPinned reverts the pinned versions in a `package.json` and the root
+// package of a `package-lock.json` that were tidied in `ModePin` (in place)
+// by applying the patch in the existing `.npm-mod.tidy.json` in reverse. A
+// dependency that has been changed since the last tidy (e.g. by
+// `npm install`) is kept as-is.
+func revertPinned(previous *TidyFile, pj, pl *ordered.OrderedMap) ([]byte, []byte, error) {
+	err := previous.Patch.PackageJSON.Revert(pj, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = previous.Patch.PackageLockJSON.Revert(pl, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return previous.Patch.rebuild(pj, pl)
+}
+
+// findPinDrift describes every version range in `package.json` that is not
+// pinned to the exact version resolved in `package-lock.json`.
+func findPinDrift(packageJSON, packageLock *ordered.OrderedMap) ([]Drift, error) {
+	byNodeModulesPath, _, err := PackageLockExtractDependencies(packageLock)
+	if err != nil {
+		return nil, err
+	}
+
+	frg := FindRegistryRanges{}
+	for _, key := range packageJSONDependencyKeys {
+		err = walkPackageJSON(packageJSON, key, frg.Visit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	drift := []Drift{}
+	pjp := PackageJSONPin{ByNodeModulesPath: byNodeModulesPath}
+	for _, u := range frg.Ranges {
+		pinned, err := pjp.Replace(u.Name, u.Specifier)
+		if err != nil {
+			return nil, err
+		}
+		if pinned == u.Specifier {
+			continue
+		}
+		message := fmt.Sprintf("%q is not pinned (to %q)", u.Specifier, pinned)
+		drift = append(drift, Drift{File: u.File, Subject: u.Name, Message: message, Fix: fixTidy})
+	}
+
+	return drift, nil
+}
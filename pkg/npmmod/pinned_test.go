@@ -0,0 +1,130 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"fmt"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestPackageJSONPin_Replace(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Name      string
+		Version   string
+		Expected  string
+		Unmatched string
+		Error     string
+	}
+
+	cases := []testCase{
+		{
+			Name:     "react",
+			Version:  "^18.0.0",
+			Expected: "18.2.0",
+			Error:    "<nil>",
+		},
+		{
+			Name:     "react",
+			Version:  "18.2.0",
+			Expected: "18.2.0",
+			Error:    "<nil>",
+		},
+		{
+			Name:     "@babel/core",
+			Version:  "~7.17.0",
+			Expected: "7.17.9",
+			Error:    "<nil>",
+		},
+		{
+			Name:     "string-width-cjs",
+			Version:  "npm:string-width@^4.2.0",
+			Expected: "npm:string-width@4.2.3",
+			Error:    "<nil>",
+		},
+		{
+			Name:     "@types/core",
+			Version:  "npm:@babel/core@^7.0.0",
+			Expected: "npm:@babel/core@7.17.9",
+			Error:    "<nil>",
+		},
+		{
+			Name:      "react",
+			Version:   "latest",
+			Expected:  "latest",
+			Unmatched: `package.json "react": "latest" (not a version range)`,
+			Error:     "<nil>",
+		},
+		{
+			Name:      "local",
+			Version:   "file:../local",
+			Expected:  "file:../local",
+			Unmatched: `package.json "local": "file:../local" (not a version range)`,
+			Error:     "<nil>",
+		},
+		{
+			Name:      "aguent",
+			Version:   "^2.0.0",
+			Expected:  "^2.0.0",
+			Unmatched: `package.json "aguent": "^2.0.0" (npm url in unexpected format; url: git+ssh://git@github.com/aguent/aguent.git)`,
+			Error:     "<nil>",
+		},
+		{
+			Name:      "bility",
+			Version:   "^11.0.0",
+			Expected:  "^11.0.0",
+			Unmatched: `package.json "bility": "^11.0.0" (node_modules/bility not in package-lock.json)`,
+			Error:     "<nil>",
+		},
+		{
+			Name:     "react",
+			Version:  "^17.0.0",
+			Expected: "",
+			Error:    `resolved version 18.2.0 of "react" does not satisfy "^17.0.0" (>=17.0.0 <18.0.0-0) in package.json`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Name+"@"+tc.Version, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			pjp := npmmod.PackageJSONPin{
+				ByNodeModulesPath: map[string]npmmod.RegistryPackage{
+					"node_modules/react":            {URL: "https://registry.npmjs.org/react/-/react-18.2.0.tgz"},
+					"node_modules/@babel/core":      {URL: "https://registry.npmjs.org/@babel/core/-/core-7.17.9.tgz"},
+					"node_modules/string-width-cjs": {URL: "https://registry.npmjs.org/string-width/-/string-width-4.2.3.tgz"},
+					"node_modules/@types/core":      {URL: "https://registry.npmjs.org/@babel/core/-/core-7.17.9.tgz"},
+					"node_modules/aguent":           {URL: "git+ssh://git@github.com/aguent/aguent.git"},
+				},
+			}
+			pinned, err := pjp.Replace(tc.Name, tc.Version)
+			assert.Equal(tc.Expected, pinned)
+			assert.Equal(tc.Error, fmt.Sprintf("%v", err))
+
+			unmatched := ""
+			if len(pjp.Unmatched) > 0 {
+				unmatched = pjp.Unmatched[0].String()
+			}
+			assert.Equal(tc.Unmatched, unmatched)
+		})
+	}
+}
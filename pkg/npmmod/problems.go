@@ -0,0 +1,41 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProblemsError is an error made up of a list of problems, e.g. every line in
+// `npm.sum` that does not agree with `.npm-mod.tidy.json`.
+type ProblemsError struct {
+	Summary  string
+	Problems []string
+}
+
+// Error formats the summary followed by one line per problem.
+func (pe *ProblemsError) Error() string {
+	return pe.Summary + ":\n- " + strings.Join(pe.Problems, "\n- ")
+}
+
+// ProblemCount describes a number of problems, e.g. `1 problem` or
+// `3 problems`.
+func ProblemCount(n int) string {
+	if n == 1 {
+		return "1 problem"
+	}
+	return fmt.Sprintf("%d problems", n)
+}
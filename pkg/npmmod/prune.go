@@ -0,0 +1,95 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/semver"
+)
+
+// Orphan is a package archive in `vendor/` that is not tracked in the tidy
+// file, e.g. one left behind after a package was upgraded.
+type Orphan struct {
+	Filename string
+	Size     int64
+}
+
+// FindOrphans lists the package archives in `vendor/` that are not tracked in
+// `packages`, sorted by filename. Only regular files that `IsArchiveFilename()`
+// recognizes are considered; anything else in `vendor/` (including
+// `vendor/npm-mod.txt`) is never reported.
+func FindOrphans(root string, packages []Package) ([]Orphan, error) {
+	entries, err := os.ReadDir(filepath.Join(root, "vendor"))
+	if err != nil && os.IsNotExist(err) {
+		return []Orphan{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := TrackedFilenames(packages)
+
+	orphans := []Orphan{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if tracked[name] || !entry.Type().IsRegular() || !IsArchiveFilename(name) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, Orphan{Filename: name, Size: info.Size()})
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Filename < orphans[j].Filename
+	})
+	return orphans, nil
+}
+
+// IsArchiveFilename determines if `filename` has the form of a vendored
+// package archive (see `FilenameFromURL()`), i.e.
+// `[{SCOPE}__]{BASENAME}-{VERSION}.tgz` with a valid semver version.
+func IsArchiveFilename(filename string) bool {
+	withoutExt := strings.TrimSuffix(filename, ".tgz")
+	if withoutExt == filename || strings.ContainsAny(filename, `/\`) {
+		return false
+	}
+
+	if i := strings.Index(withoutExt, "__"); i != -1 {
+		withoutExt = withoutExt[i+2:]
+	}
+
+	// NOTE: The basename may itself contain `-` (e.g. `compat-data-7.17.7`)
+	//       and so may the version (e.g. `1.0.0-beta.1`), so try every
+	//       split from the left.
+	for i := 1; i < len(withoutExt); i++ {
+		if withoutExt[i] != '-' {
+			continue
+		}
+		_, err := semver.Parse(withoutExt[i+1:])
+		if err == nil {
+			return true
+		}
+	}
+
+	return false
+}
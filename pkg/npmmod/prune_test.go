@@ -0,0 +1,89 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestFindOrphans(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := tempDir(t, assert)
+	orphans, err := npmmod.FindOrphans(root, nil)
+	assert.Nil(err)
+	assert.Equal([]npmmod.Orphan{}, orphans)
+
+	vendorDir := filepath.Join(root, "vendor")
+	err = os.Mkdir(vendorDir, 0755)
+	assert.Nil(err)
+	files := map[string]string{
+		"a-1.0.0.tgz":                   "tracked",
+		"a-0.9.0.tgz":                   "old",
+		"babel__compat-data-7.17.6.tgz": "old scoped",
+		"npm-mod.txt":                   "manifest",
+		"notes.tgz":                     "not an archive",
+		"README.md":                     "not an archive",
+	}
+	for name, contents := range files {
+		err = os.WriteFile(filepath.Join(vendorDir, name), []byte(contents), 0644)
+		assert.Nil(err)
+	}
+	err = os.Mkdir(filepath.Join(vendorDir, "b-1.0.0.tgz"), 0755)
+	assert.Nil(err)
+
+	packages := []npmmod.Package{{Archive: "a-1.0.0.tgz"}}
+	orphans, err = npmmod.FindOrphans(root, packages)
+	assert.Nil(err)
+	expected := []npmmod.Orphan{
+		{Filename: "a-0.9.0.tgz", Size: 3},
+		{Filename: "babel__compat-data-7.17.6.tgz", Size: 10},
+	}
+	assert.Equal(expected, orphans)
+}
+
+func TestIsArchiveFilename(outer *testing.T) {
+	outer.Parallel()
+
+	cases := []struct {
+		Filename string
+		Expected bool
+	}{
+		{Filename: "builtins-1.0.3.tgz", Expected: true},
+		{Filename: "babel__compat-data-7.17.7.tgz", Expected: true},
+		{Filename: "typescript-5.0.0-beta.tgz", Expected: true},
+		{Filename: "builtins-1.0.3.tar.gz", Expected: false},
+		{Filename: "builtins.tgz", Expected: false},
+		{Filename: "builtins-latest.tgz", Expected: false},
+		{Filename: "-1.0.3.tgz", Expected: false},
+		{Filename: "npm-mod.txt", Expected: false},
+	}
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Filename, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			assert.Equal(tc.Expected, npmmod.IsArchiveFilename(tc.Filename))
+		})
+	}
+}
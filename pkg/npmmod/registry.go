@@ -39,32 +39,65 @@ func (rp RegistryPackage) Filename() (string, error) {
 
 // FilenameFromURL creates a normalized filename from an `npm` registry URL.
 func FilenameFromURL(url string) (string, error) {
-	u, err := neturl.Parse(url)
+	packageName, filename, err := splitRegistryURL(url)
 	if err != nil {
 		return "", err
 	}
-	// Normalize path
-	path := strings.TrimPrefix(u.Path, "/")
 
-	// Paths are of the form `/{PACKAGE_NAME}/-/{FILENAME}.tgz`.
-	parts := strings.Split(path, "/-/")
-	if len(parts) != 2 {
-		err = fmt.Errorf("npm url in unexpected format; url: %s", url)
-		return "", err
-	}
-
-	scope, err := getPackageScope(parts[0])
+	scope, err := getPackageScope(packageName)
 	if err != nil {
 		return "", err
 	}
 
 	if scope == "" {
-		return parts[1], nil
+		return filename, nil
 	}
 
 	// NOTE: We could go a step further and validate that the filename matches
 	//       the package name.
-	return scope + "__" + parts[1], nil
+	return scope + "__" + filename, nil
+}
+
+// VersionFromURL determines the package version from an `npm` registry URL,
+// e.g. `https://registry.npmjs.org/@babel/cli/-/cli-7.15.7.tgz` has version
+// `7.15.7`.
+func VersionFromURL(url string) (string, error) {
+	packageName, filename, err := splitRegistryURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	// Filenames are of the form `{BASENAME}-{VERSION}.tgz` where the basename
+	// is the package name without the scope.
+	basename := packageName[strings.LastIndex(packageName, "/")+1:]
+	withoutExt := strings.TrimSuffix(filename, ".tgz")
+	version := strings.TrimPrefix(withoutExt, basename+"-")
+	if withoutExt == filename || version == withoutExt || version == "" {
+		err = fmt.Errorf("npm url filename does not match package name; url: %s", url)
+		return "", err
+	}
+
+	return version, nil
+}
+
+// splitRegistryURL splits an `npm` registry URL into the package name and the
+// archive filename.
+func splitRegistryURL(url string) (string, string, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", "", err
+	}
+	// Normalize path
+	path := strings.TrimPrefix(u.Path, "/")
+
+	// Paths are of the form `/{PACKAGE_NAME}/-/{FILENAME}.tgz`.
+	parts := strings.Split(path, "/-/")
+	if len(parts) != 2 {
+		err = fmt.Errorf("npm url in unexpected format; url: %s", url)
+		return "", "", err
+	}
+
+	return parts[0], parts[1], nil
 }
 
 func getPackageScope(packageName string) (string, error) {
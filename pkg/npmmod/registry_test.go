@@ -56,3 +56,38 @@ func TestFilenameFromURL(outer *testing.T) {
 		})
 	}
 }
+
+func TestVersionFromURL(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		URL     string
+		Version string
+		Error   string
+	}
+
+	cases := []testCase{
+		{URL: "https://registry.npmjs.org/builtins/-/builtins-1.0.3.tgz", Version: "1.0.3"},
+		{URL: "https://registry.npmjs.org/@babel/cli/-/cli-7.15.7.tgz", Version: "7.15.7"},
+		{URL: "https://registry.npmjs.org/@types/node/-/node-18.0.0-rc.1.tgz", Version: "18.0.0-rc.1"},
+		{URL: "https://registry.npmjs.org/missing.tgz", Error: "npm url in unexpected format; url: https://registry.npmjs.org/missing.tgz"},
+		{URL: "https://registry.npmjs.org/builtins/-/other-1.0.3.tgz", Error: "npm url filename does not match package name; url: https://registry.npmjs.org/builtins/-/other-1.0.3.tgz"},
+		{URL: "https://registry.npmjs.org/builtins/-/builtins-1.0.3.zip", Error: "npm url filename does not match package name; url: https://registry.npmjs.org/builtins/-/builtins-1.0.3.zip"},
+	}
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.URL, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			version, err := npmmod.VersionFromURL(tc.URL)
+			assert.Equal(tc.Version, version, tc.URL)
+			if tc.Error == "" {
+				assert.Nil(err, tc.URL)
+			} else {
+				assert.NotNil(err, tc.URL)
+				assert.Equal(tc.Error, fmt.Sprintf("%v", err), tc.URL)
+			}
+		})
+	}
+}
@@ -16,20 +16,26 @@ package npmmod
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/hardfinhq/npm-mod/pkg/ordered"
+	"github.com/hardfinhq/npm-mod/pkg/semver"
 )
 
 // NOTE: Ensure that
 //       * `ReplaceDependency{}.Visit` satisfies `VisitorFunc`.
 //       * `ReplaceResolved{}.Visit` satisfies `VisitorFunc`.
+//       * `ReplaceRanges{}.Visit` satisfies `VisitorFunc`.
 //       * `PackageJSONReplace{}.Replace` satisfies `ReplacePairFunc`.
 //       * `PackageLockReplace{}.Replace` satisfies `ReplaceFunc`.
+//       * `RangeReplace{}.Replace` satisfies `ReplacePairFunc`.
 var (
 	_ VisitorFunc     = (&ReplaceDependency{}).Visit
 	_ VisitorFunc     = (&ReplaceResolved{}).Visit
+	_ VisitorFunc     = (&ReplaceRanges{}).Visit
 	_ ReplacePairFunc = (&PackageJSONReplace{}).Replace
 	_ ReplaceFunc     = (&PackageLockReplace{}).Replace
+	_ ReplacePairFunc = (&RangeReplace{}).Replace
 )
 
 // ReplaceDependency produces a visitor function that **replaces** a
@@ -47,7 +53,11 @@ func (rd *ReplaceDependency) Visit(deps *ordered.OrderedMap, k string, v any) er
 		return fmt.Errorf("dependency %q is not a string", packageName)
 	}
 
-	newPackageVersion := rd.Replace(packageName, packageVersion)
+	newPackageVersion, err := rd.Replace(packageName, packageVersion)
+	if err != nil {
+		return err
+	}
+
 	deps.Set(packageName, newPackageVersion)
 	return nil
 }
@@ -57,6 +67,10 @@ func (rd *ReplaceDependency) Visit(deps *ordered.OrderedMap, k string, v any) er
 type ReplaceResolved struct {
 	Replace   ReplaceFunc
 	ParentKey string
+	// KeepVersion determines if the `version` key should be left as-is. When
+	// vendoring, this is only supported for the `packages` map; the legacy
+	// `dependencies` map uses `version` for the `file:` reference.
+	KeepVersion bool
 }
 
 // Visit is a visitor function that **replaces** a package `resolved` (and
@@ -78,65 +92,221 @@ func (rr *ReplaceResolved) Visit(deps *ordered.OrderedMap, k string, v any) erro
 		return fmt.Errorf(`package %q "resolved" is not a string`, name)
 	}
 
-	newResolved := rr.Replace(resolved)
+	newResolved, err := rr.Replace(resolved)
+	if err != nil {
+		return err
+	}
+
 	m.Set("resolved", newResolved)
-	m.Set("version", newResolved)
+	if !rr.KeepVersion {
+		m.Set("version", newResolved)
+	}
+	return nil
+}
+
+// ReplaceRanges produces a visitor function that **replaces** the dependency
+// ranges of a package in `package-lock.json` that resolve to a vendored
+// package.
+//
+// Once a package is vendored its `version` is a `file:vendor/...` reference,
+// so `npm` no longer considers a range like `^1.0.0` satisfied by it and would
+// fetch the package from the registry instead.
+type ReplaceRanges struct {
+	Packages *ordered.OrderedMap
+}
+
+// Visit is a visitor function that **replaces** the dependency ranges of a
+// package in `package-lock.json` that resolve to a vendored package.
+func (rr *ReplaceRanges) Visit(_ *ordered.OrderedMap, k string, v any) error {
+	location := k
+	if location == "" {
+		return nil
+	}
+
+	m, ok := v.(*ordered.OrderedMap)
+	if !ok {
+		return fmt.Errorf("package %q does not point at a map", location)
+	}
+
+	r := RangeReplace{Packages: rr.Packages, Location: location, Dir: requestorDir(location, m)}
+	rd := ReplaceDependency{Replace: r.Replace}
+	for _, key := range lockDependencyKeys {
+		err := walkPackageJSON(m, key, rd.Visit)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Unmatched describes a dependency specifier that was left as-is during
+// tidy, i.e. one that will still require a fetch from the registry.
+type Unmatched struct {
+	File      string
+	Name      string
+	Specifier string
+	Reason    string
+}
+
+// String describes the unmatched specifier, e.g.
+// `package.json "react": "^18.0.0" (reason)`.
+func (u Unmatched) String() string {
+	if u.Name == "" {
+		return fmt.Sprintf("%s %q (%s)", u.File, u.Specifier, u.Reason)
+	}
+	return fmt.Sprintf("%s %q: %q (%s)", u.File, u.Name, u.Specifier, u.Reason)
+}
+
 // PackageJSONReplace provides a `replace` helper that replaces a `package.json`
 // package version with a local `file:` reference.
 type PackageJSONReplace struct {
 	ByNodeModulesPath map[string]RegistryPackage
+	// Unmatched collects every package version that could not be replaced.
+	Unmatched []Unmatched
 }
 
 // Replace replaces a `package.json` package version with a local `file:`
 // reference. In the case that the package name or version can't be matched
-// or the filename can't be determined, this just returns the `version`.
-func (pjr *PackageJSONReplace) Replace(name, version string) string {
+// or the filename can't be determined, this just returns the `version` and
+// records it in `Unmatched`. If the vendored version does not satisfy the
+// version range in `package.json`, this returns an error.
+func (pjr *PackageJSONReplace) Replace(name, version string) (string, error) {
 	key := fmt.Sprintf("node_modules/%s", name)
 	rp, ok := pjr.ByNodeModulesPath[key]
 	if !ok {
-		// NOTE: This isn't great, the `ReplacePairFunc` should probably allow
-		//       an error too.
-		return version
+		pjr.unmatched(name, version, fmt.Sprintf("%s not in package-lock.json", key))
+		return version, nil
 	}
 
 	filename, err := rp.Filename()
 	if err != nil {
-		// NOTE: This isn't great, the `ReplacePairFunc` should probably allow
-		//       an error too.
-		return version
+		pjr.unmatched(name, version, err.Error())
+		return version, nil
+	}
+
+	err = checkRange("vendored version", name, version, rp)
+	if err != nil {
+		return "", err
+	}
+
+	return vendorPrefix + filename, nil
+}
+
+// checkRange ensures the version vendored (or resolved) for `name` satisfies
+// the version range from `package.json`; `noun` describes that version in
+// errors, e.g. `vendored version`. Specifiers that are not version ranges
+// (e.g. dist-tags like `latest`) can't be checked and are accepted as-is.
+func checkRange(noun, name, specifier string, rp RegistryPackage) error {
+	r, err := semver.ParseRange(rangeFromSpecifier(specifier))
+	if err != nil {
+		return nil
+	}
+
+	version, err := VersionFromURL(rp.URL)
+	if err != nil {
+		return err
+	}
+	v, err := semver.Parse(version)
+	if err != nil {
+		return fmt.Errorf("%s of %q is invalid; %w", noun, name, err)
+	}
+
+	if r.Contains(v) {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"%s %s of %q does not satisfy %q (%s) in package.json",
+		noun, version, name, specifier, r,
+	)
+}
+
+// rangeFromSpecifier strips the alias from an `npm:` specifier, e.g.
+// `npm:string-width@^4.2.0` becomes `^4.2.0`.
+func rangeFromSpecifier(specifier string) string {
+	alias := strings.TrimPrefix(specifier, "npm:")
+	if alias == specifier {
+		return specifier
 	}
 
-	// NOTE: We only validate the key in `ByNodeModulesPath` but don't check
-	//       anything about the specified version / version range.
-	return fmt.Sprintf("file:vendor/%s", filename)
+	i := strings.LastIndex(alias, "@")
+	if i <= 0 {
+		return "*"
+	}
+	return alias[i+1:]
+}
+
+func (pjr *PackageJSONReplace) unmatched(name, version, reason string) {
+	u := Unmatched{File: "package.json", Name: name, Specifier: version, Reason: reason}
+	pjr.Unmatched = append(pjr.Unmatched, u)
 }
 
 // PackageLockReplace provides a `replace` helper that replaces a `resolved` URL
 // with a local `file:` reference.
 type PackageLockReplace struct {
 	ByURL map[string]RegistryPackage
+	// Unmatched collects every `resolved` URL that could not be replaced.
+	// Each URL is only recorded once, even if it is resolved more than once
+	// in the package lock.
+	Unmatched []Unmatched
 }
 
 // Replace replaces a `resolved` URL with a local `file:` reference. In the case
 // that the URL can't be matched or the filename can't be determined, this just
-// returns the `resolved`.
-func (plr *PackageLockReplace) Replace(resolved string) string {
+// returns the `resolved` and records it in `Unmatched`.
+func (plr *PackageLockReplace) Replace(resolved string) (string, error) {
 	rp, ok := plr.ByURL[resolved]
 	if !ok {
-		// NOTE: This isn't great, the `ReplaceFunc` should probably allow an
-		//       error too.
-		return resolved
+		plr.unmatched(resolved, "not in package-lock.json packages")
+		return resolved, nil
 	}
 
 	filename, err := rp.Filename()
 	if err != nil {
-		// NOTE: This isn't great, the `ReplaceFunc` should probably allow an
-		//       error too.
-		return resolved
+		plr.unmatched(resolved, err.Error())
+		return resolved, nil
+	}
+
+	return vendorPrefix + filename, nil
+}
+
+func (plr *PackageLockReplace) unmatched(resolved, reason string) {
+	for _, u := range plr.Unmatched {
+		if u.Specifier == resolved {
+			return
+		}
+	}
+
+	u := Unmatched{File: "package-lock.json", Specifier: resolved, Reason: reason}
+	plr.Unmatched = append(plr.Unmatched, u)
+}
+
+// RangeReplace provides a `replace` helper that replaces a dependency range of
+// the package at `Location` in `package-lock.json` with a `file:` reference,
+// if the range resolves to a vendored package.
+type RangeReplace struct {
+	Packages *ordered.OrderedMap
+	Location string
+	// Dir is the directory (relative to the project root) that `npm` resolves
+	// `file:` references against for the package at `Location`.
+	Dir string
+}
+
+// Replace replaces a dependency range with a `file:` reference to a vendored
+// package archive. The reference is relative to `Dir`, e.g. `file:b-1.0.0.tgz`
+// when the package at `Location` is itself vendored. Ranges that don't resolve
+// to a vendored package are returned unchanged.
+func (rr *RangeReplace) Replace(name, version string) (string, error) {
+	_, target, ok := lookupPackage(rr.Packages, rr.Location, name)
+	if !ok {
+		return version, nil
+	}
+
+	resolved, _ := target.Get("resolved").(string)
+	if !isVendorReference(resolved) {
+		return version, nil
 	}
 
-	return fmt.Sprintf("file:vendor/%s", filename)
+	return fileSpec(rr.Dir, strings.TrimPrefix(resolved, "file:"))
 }
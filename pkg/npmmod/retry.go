@@ -0,0 +1,157 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	jitterMutex  sync.Mutex
+	jitterSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// RetryPolicy determines how often (and after how long) a transient failure
+// is retried. A transient failure is a connection error (e.g. a reset), a
+// `5xx` response, a `408` or a `429`.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first one.
+	Attempts int
+	// InitialDelay is the delay before the first retry; the delay doubles
+	// after every attempt (with jitter) up to `MaxDelay`.
+	InitialDelay time.Duration
+	// MaxDelay caps the exponential backoff between two attempts. It does
+	// not apply to a delay requested by the server with `Retry-After`.
+	MaxDelay time.Duration
+	// MaxRetryAfter is the longest `Retry-After` that is honored; if the
+	// server requests a longer delay, the download fails instead of
+	// waiting. If zero, any `Retry-After` is honored.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used by `npm-mod vendor`.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{Attempts: 5, InitialDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second, MaxRetryAfter: 5 * time.Minute}
+}
+
+func (rp RetryPolicy) attempts() int {
+	if rp.Attempts < 1 {
+		return 1
+	}
+	return rp.Attempts
+}
+
+// delay determines how long to wait after a failed `attempt` (counting from
+// 1). The exponential backoff uses "equal jitter", i.e. a random delay
+// between half and all of the backoff, so that concurrent downloads that
+// failed at the same time don't all retry at the same time. A longer
+// `Retry-After` from the server takes precedence (even over `MaxDelay`);
+// if it is longer than `MaxRetryAfter`, this returns an error instead.
+func (rp RetryPolicy) delay(attempt int, retryAfter time.Duration) (time.Duration, error) {
+	if rp.MaxRetryAfter > 0 && retryAfter > rp.MaxRetryAfter {
+		return 0, fmt.Errorf("server asked to retry after %s, longer than the limit of %s", retryAfter, rp.MaxRetryAfter)
+	}
+
+	backoff := rp.InitialDelay
+	for i := 1; i < attempt && backoff < rp.MaxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > rp.MaxDelay {
+		backoff = rp.MaxDelay
+	}
+
+	d := backoff/2 + jitter(backoff/2)
+	if retryAfter > d {
+		d = retryAfter
+	}
+	return d, nil
+}
+
+// jitter returns a random duration in `[0, d]`.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	jitterMutex.Lock()
+	defer jitterMutex.Unlock()
+	return time.Duration(jitterSource.Int63n(int64(d) + 1))
+}
+
+// sleep waits for `d` or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// transientError is a failure that is worth retrying.
+type transientError struct {
+	Err error
+	// RetryAfter is the delay requested by the server (if any).
+	RetryAfter time.Duration
+}
+
+// Error returns the message of the wrapped error.
+func (te *transientError) Error() string {
+	return te.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (te *transientError) Unwrap() error {
+	return te.Err
+}
+
+func isTransientStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// parseRetryAfter parses a `Retry-After` header, either in seconds or as an
+// HTTP date. An absent or invalid header is treated as no delay.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if err == nil {
+		return 0
+	}
+
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0
+	}
+	d := time.Until(t)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
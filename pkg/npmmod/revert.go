@@ -0,0 +1,251 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+)
+
+// NOTE: Ensure that
+//       * `FindVendored{}.Visit` satisfies `VisitorFunc`.
+//       * `RevertDependency{}.Visit` satisfies `VisitorFunc`.
+//       * `RevertResolved{}.Visit` satisfies `VisitorFunc`.
+//       * `RevertRanges{}.Visit` satisfies `VisitorFunc`.
+//       * `RevertRange{}.Visit` satisfies `VisitorFunc`.
+var (
+	_ VisitorFunc = (&FindVendored{}).Visit
+	_ VisitorFunc = (&RevertDependency{}).Visit
+	_ VisitorFunc = (&RevertResolved{}).Visit
+	_ VisitorFunc = (&RevertRanges{}).Visit
+	_ VisitorFunc = (&RevertRange{}).Visit
+)
+
+const (
+	vendorPrefix = "file:vendor/"
+)
+
+// FindVendored produces a visitor function that collects `file:vendor/...`
+// references in either `package.json` dependencies or `package-lock.json`
+// packages.
+type FindVendored struct {
+	Filenames map[string]bool
+}
+
+// Visit is a visitor function that **collects** a `file:vendor/...` package
+// version (in `package.json`) or `resolved` key (in `package-lock.json`).
+func (fv *FindVendored) Visit(_ *ordered.OrderedMap, _ string, v any) error {
+	reference, _ := v.(string)
+	if m, ok := v.(*ordered.OrderedMap); ok {
+		reference, _ = m.Get("resolved").(string)
+	}
+
+	if isVendorReference(reference) {
+		fv.Filenames[strings.TrimPrefix(reference, vendorPrefix)] = true
+	}
+	return nil
+}
+
+// RevertDependency produces a visitor function that **reverts** a
+// `file:vendor/...` package version in `package.json` to the version (range)
+// stored in an `Original` snapshot.
+type RevertDependency struct {
+	Original  *ordered.OrderedMap
+	ParentKey string
+}
+
+// Visit is a visitor function that **reverts** a `file:vendor/...` package
+// version to the original version (range). Package versions that don't refer
+// to `vendor/` are left unchanged.
+func (rd *RevertDependency) Visit(deps *ordered.OrderedMap, k string, v any) error {
+	packageName := k
+	packageVersion, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("dependency %q is not a string", packageName)
+	}
+
+	if !isVendorReference(packageVersion) {
+		return nil
+	}
+
+	original, ok := originalDependency(rd.Original, rd.ParentKey, packageName)
+	if !ok {
+		return fmt.Errorf("dependency %q in %q refers to %s but has no original version in .npm-mod.tidy.json", packageName, rd.ParentKey, packageVersion)
+	}
+
+	deps.Set(packageName, original)
+	return nil
+}
+
+// RevertResolved produces a visitor function that **reverts** a package
+// `resolved` (and `version`) key that refers to `file:vendor/...` back to the
+// registry URL for the package archive.
+type RevertResolved struct {
+	ByFilename map[string]RegistryPackage
+	ParentKey  string
+}
+
+// Visit is a visitor function that **reverts** a package `resolved` (and
+// `version`) key that refers to `file:vendor/...`. Packages that don't refer
+// to `vendor/` are left unchanged.
+func (rr *RevertResolved) Visit(deps *ordered.OrderedMap, k string, v any) error {
+	name := k
+	if rr.ParentKey == "packages" && name == "" {
+		return nil
+	}
+
+	m, ok := v.(*ordered.OrderedMap)
+	if !ok {
+		return fmt.Errorf("package %q does not point at a map", name)
+	}
+
+	resolvedAny := m.Get("resolved")
+	resolved, ok := resolvedAny.(string)
+	if !ok {
+		return fmt.Errorf(`package %q "resolved" is not a string`, name)
+	}
+
+	if !isVendorReference(resolved) {
+		return nil
+	}
+
+	filename := strings.TrimPrefix(resolved, vendorPrefix)
+	rp, ok := rr.ByFilename[filename]
+	if !ok {
+		return fmt.Errorf("package %q refers to %s but it is not tracked in .npm-mod.tidy.json", name, resolved)
+	}
+
+	m.Set("resolved", rp.URL)
+	version, _ := m.Get("version").(string)
+	if !isVendorReference(version) {
+		return nil
+	}
+
+	version, err := VersionFromURL(rp.URL)
+	if err != nil {
+		return err
+	}
+	m.Set("version", version)
+	return nil
+}
+
+// RevertRanges produces a visitor function that **reverts** the dependency
+// ranges of a package in `package-lock.json` that were replaced with `file:`
+// references to the ranges stored in the `Original` packages map.
+type RevertRanges struct {
+	Original *ordered.OrderedMap
+}
+
+// Visit is a visitor function that **reverts** the dependency ranges of a
+// package in `package-lock.json`.
+func (rr *RevertRanges) Visit(_ *ordered.OrderedMap, k string, v any) error {
+	location := k
+	if location == "" {
+		return nil
+	}
+
+	m, ok := v.(*ordered.OrderedMap)
+	if !ok {
+		return fmt.Errorf("package %q does not point at a map", location)
+	}
+
+	var original *ordered.OrderedMap
+	if rr.Original != nil {
+		original, _ = rr.Original.Get(location).(*ordered.OrderedMap)
+	}
+
+	for _, key := range lockDependencyKeys {
+		r := RevertRange{Original: original, ParentKey: key}
+		err := walkPackageJSON(m, key, r.Visit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevertRange produces a visitor function that **reverts** a `file:`
+// dependency of a package in `package-lock.json` to the range stored in the
+// `Original` package.
+type RevertRange struct {
+	Original  *ordered.OrderedMap
+	ParentKey string
+}
+
+// Visit is a visitor function that **reverts** a `file:` dependency to the
+// original range. Dependencies that aren't `file:` references, or that have no
+// original range (e.g. because they were added after the last tidy), are left
+// unchanged.
+func (rr *RevertRange) Visit(deps *ordered.OrderedMap, k string, v any) error {
+	packageName := k
+	packageVersion, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("dependency %q is not a string", packageName)
+	}
+
+	if !strings.HasPrefix(packageVersion, "file:") {
+		return nil
+	}
+
+	original, ok := originalDependency(rr.Original, rr.ParentKey, packageName)
+	if !ok {
+		return nil
+	}
+
+	deps.Set(packageName, original)
+	return nil
+}
+
+func isVendorReference(value string) bool {
+	return strings.HasPrefix(value, vendorPrefix)
+}
+
+// originalDependency looks up a package version (range) in one of the
+// dependencies maps of an original `package.json` (or of a package in an
+// original `package-lock.json`).
+func originalDependency(packageJSON *ordered.OrderedMap, key, packageName string) (string, bool) {
+	if packageJSON == nil {
+		return "", false
+	}
+
+	deps, ok := packageJSON.Get(key).(*ordered.OrderedMap)
+	if !ok {
+		return "", false
+	}
+
+	version, ok := deps.Get(packageName).(string)
+	if !ok || isVendorReference(version) {
+		return "", false
+	}
+
+	return version, true
+}
+
+// packagesByFilename indexes registry packages by their (normalized) vendored
+// filename.
+func packagesByFilename(packages []Package) (map[string]RegistryPackage, error) {
+	byFilename := map[string]RegistryPackage{}
+	for _, p := range packages {
+		filename, err := p.ArchiveFilename()
+		if err != nil {
+			return nil, err
+		}
+		byFilename[filename] = p.RegistryPackage
+	}
+	return byFilename, nil
+}
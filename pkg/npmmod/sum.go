@@ -0,0 +1,168 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sumFilename = "npm.sum"
+)
+
+// SumLine is a single line in an `npm.sum` file, i.e. the checksum of one
+// vendored package archive.
+type SumLine struct {
+	Name      string
+	Version   string
+	Filename  string
+	Integrity string
+}
+
+// NewSumLine creates the `npm.sum` line for a vendored package.
+func NewSumLine(p Package) SumLine {
+	return SumLine{
+		Name:      p.Name,
+		Version:   p.Version,
+		Filename:  p.Archive,
+		Integrity: p.Algorithm + "-" + p.Hash,
+	}
+}
+
+// String formats the line as it appears in `npm.sum`.
+func (sl SumLine) String() string {
+	return strings.Join([]string{sl.Name, sl.Version, sl.Filename, sl.Integrity}, " ")
+}
+
+// PersistSum writes an `npm.sum` (with one line per package in the
+// `.npm-mod.tidy.json`) via a file writer.
+func (tf *TidyFile) PersistSum(w FileWriter) error {
+	var b bytes.Buffer
+	for _, p := range tf.Packages {
+		sl := NewSumLine(p)
+		for _, field := range []string{sl.Name, sl.Version, sl.Filename, sl.Integrity} {
+			if field == "" || strings.ContainsAny(field, " \t\n") {
+				return fmt.Errorf("cannot write %s line for %s; %q", sumFilename, p.URL, sl)
+			}
+		}
+
+		b.WriteString(sl.String())
+		b.WriteString("\n")
+	}
+
+	target := filepath.Join(tf.Root, sumFilename)
+	return w.WriteFile(target, b.Bytes())
+}
+
+// RemoveStaleSum removes the `npm.sum` in `root` (if any) via a file remover.
+// This is used outside of `ModeVendor`, where there are no package archives
+// for `npm.sum` to describe. Returns `true` if there was a file to remove.
+func RemoveStaleSum(w FileRemover, root string) (bool, error) {
+	target := filepath.Join(root, sumFilename)
+	_, err := os.Stat(target)
+	if err != nil && os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = w.RemoveFile(target)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CheckSum cross-checks the lines in an `npm.sum` against the packages in the
+// `.npm-mod.tidy.json`. Any disagreement (a missing, extra or different line)
+// is an error.
+func (tf *TidyFile) CheckSum(lines []SumLine) error {
+	problems := []string{}
+	byFilename := map[string]SumLine{}
+	for _, sl := range lines {
+		if _, ok := byFilename[sl.Filename]; ok {
+			problems = append(problems, fmt.Sprintf("duplicate %q", sl))
+		}
+		byFilename[sl.Filename] = sl
+	}
+
+	for _, p := range tf.Packages {
+		expected := NewSumLine(p)
+		actual, ok := byFilename[expected.Filename]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing %q", expected))
+			continue
+		}
+		delete(byFilename, expected.Filename)
+
+		if actual != expected {
+			problems = append(problems, fmt.Sprintf("has %q; expected %q", actual, expected))
+		}
+	}
+
+	for _, sl := range lines {
+		if _, ok := byFilename[sl.Filename]; ok {
+			problems = append(problems, fmt.Sprintf("unexpected %q", sl))
+			delete(byFilename, sl.Filename)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ProblemsError{Summary: sumFilename + " does not agree with .npm-mod.tidy.json", Problems: problems}
+}
+
+// ReadSumFile reads and parses the `npm.sum` file in `root`.
+func ReadSumFile(root string) ([]SumLine, error) {
+	target := filepath.Join(root, sumFilename)
+	data, err := os.ReadFile(target)
+	if err != nil && os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s does not exist; run npm-mod tidy to create it", target)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSumFile(data)
+}
+
+// ParseSumFile parses the contents of an `npm.sum` file.
+func ParseSumFile(data []byte) ([]SumLine, error) {
+	lines := []SumLine{}
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: expected 4 fields; %q", sumFilename, i+1, line)
+		}
+		if _, _, err := splitIntegrity(fields[3]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", sumFilename, i+1, err)
+		}
+
+		sl := SumLine{Name: fields[0], Version: fields[1], Filename: fields[2], Integrity: fields[3]}
+		lines = append(lines, sl)
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestTidyFile_PersistSum(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	tf, err := npmmod.GenerateTidyFile("testdata")
+	assert.Nil(err)
+	txn := npmmod.NewTransaction()
+	err = tf.PersistSum(txn)
+	assert.Nil(err)
+
+	actual, err := txn.ReadFile(filepath.Join("testdata", "npm.sum"))
+	assert.Nil(err)
+	expected, err := os.ReadFile(filepath.Join("testdata", "golden.npm.sum"))
+	assert.Nil(err)
+	assert.True(bytes.Equal(expected, actual), "golden.npm.sum")
+
+	lines, err := npmmod.ParseSumFile(actual)
+	assert.Nil(err)
+	assert.Len(lines, len(tf.Packages))
+	assert.Nil(tf.CheckSum(lines))
+}
+
+func TestRemoveStaleSum(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := tempDir(t, assert)
+	txn := npmmod.NewTransaction()
+	removed, err := npmmod.RemoveStaleSum(txn, root)
+	assert.Nil(err)
+	assert.False(removed)
+	assert.Len(txn.Staged(), 0)
+
+	err = os.WriteFile(filepath.Join(root, "npm.sum"), []byte("stale\n"), 0644)
+	assert.Nil(err)
+	removed, err = npmmod.RemoveStaleSum(txn, root)
+	assert.Nil(err)
+	assert.True(removed)
+
+	// The removal is only staged until commit.
+	_, err = os.Stat(filepath.Join(root, "npm.sum"))
+	assert.Nil(err)
+	err = txn.Commit()
+	assert.Nil(err)
+	_, err = os.Stat(filepath.Join(root, "npm.sum"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestParseSumFile_Error(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Name  string
+		Data  string
+		Error string
+	}
+
+	cases := []testCase{
+		{
+			Name:  "fields",
+			Data:  "a 1.0.0 a-1.0.0.tgz sha512-YQ==\nb 2.0.0 sha512-Yg==\n",
+			Error: `npm.sum:2: expected 4 fields; "b 2.0.0 sha512-Yg=="`,
+		},
+		{
+			Name:  "algorithm",
+			Data:  "a 1.0.0 a-1.0.0.tgz md5-YQ==\n",
+			Error: "npm.sum:1: unknown integrity algorithm; md5-YQ==",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			lines, err := npmmod.ParseSumFile([]byte(tc.Data))
+			assert.Nil(lines)
+			assert.Equal(tc.Error, fmt.Sprintf("%v", err))
+		})
+	}
+}
+
+func TestTidyFile_CheckSum(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	tf := npmmod.TidyFile{
+		Packages: []npmmod.Package{
+			{
+				Name:            "a",
+				Version:         "1.0.0",
+				Archive:         "a-1.0.0.tgz",
+				RegistryPackage: npmmod.RegistryPackage{Algorithm: "sha512", Hash: "YQ=="},
+			},
+			{
+				Name:            "b",
+				Version:         "2.0.0",
+				Archive:         "b-2.0.0.tgz",
+				RegistryPackage: npmmod.RegistryPackage{Algorithm: "sha512", Hash: "Yg=="},
+			},
+		},
+	}
+	data := "a 1.0.0 a-1.0.0.tgz sha512-Yw==\nc 3.0.0 c-3.0.0.tgz sha512-ZA==\n"
+	lines, err := npmmod.ParseSumFile([]byte(data))
+	assert.Nil(err)
+
+	err = tf.CheckSum(lines)
+	expected := `npm.sum does not agree with .npm-mod.tidy.json:
+- has "a 1.0.0 a-1.0.0.tgz sha512-Yw=="; expected "a 1.0.0 a-1.0.0.tgz sha512-YQ=="
+- missing "b 2.0.0 b-2.0.0.tgz sha512-Yg=="
+- unexpected "c 3.0.0 c-3.0.0.tgz sha512-ZA=="`
+	assert.Equal(expected, fmt.Sprintf("%v", err))
+}
@@ -16,31 +16,109 @@ package npmmod
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/hardfinhq/npm-mod/pkg/ordered"
 )
 
 const (
-	tidyFileVersion = "22.05"
+	tidyFileVersion = "26.11"
+	// snapshotTidyFileVersion is the version of `.npm-mod.tidy.json` files
+	// that have a full (base64 encoded) snapshot of the original
+	// `package.json` and `package-lock.json`. These are migrated
+	// automatically when read.
+	snapshotTidyFileVersion = "26.10"
+	// legacyTidyFileVersion is the version of `.npm-mod.tidy.json` files that
+	// (in addition to the snapshots) only have the URL and hash of each
+	// package. These are migrated automatically when read.
+	legacyTidyFileVersion = "22.05"
+)
+
+const (
+	// ModeVendor is the tidy mode that replaces every registry package with
+	// a `file:vendor/...` reference to a package archive in `vendor/`.
+	ModeVendor = "vendor"
+	// ModeRegistry is the tidy mode that rewrites every `resolved` URL in
+	// `package-lock.json` to a mirror registry (and leaves `package.json`
+	// as-is).
+	ModeRegistry = "registry"
+	// ModePin is the tidy mode that replaces every version range in
+	// `package.json` with the exact version resolved in `package-lock.json`
+	// (and leaves the `resolved` URLs as-is).
+	ModePin = "pin"
 )
 
 // TidyFile represents a `.npm-mod.tidy.json`
 type TidyFile struct {
+	Version  string    `json:"version"`
+	Packages []Package `json:"packages"`
+	// KeepVersions determines if tidy keeps the real `version` of every
+	// vendored package in the `package-lock.json` packages map (instead of
+	// replacing it with the `file:vendor/...` reference).
+	KeepVersions bool `json:"keep-versions,omitempty"`
+	// Mode is the tidy mode, e.g. `ModeRegistry` or `ModePin`. It is empty for
+	// `ModeVendor` (the default).
+	Mode string `json:"mode,omitempty"`
+	// Mirror is the registry base URL that `resolved` URLs are rewritten to
+	// in `ModeRegistry`.
+	Mirror string `json:"mirror,omitempty"`
+	// VendorHash is the `VendorHash()` of the package archives in `vendor/`.
+	// It is empty if some of the package archives have not been vendored yet.
+	VendorHash string `json:"vendor-hash,omitempty"`
+	// Patch is populated by `TidyPackageJSON()` and `TidyPackageLockJSON()`
+	// with the delta needed to restore the original files.
+	Patch Patch `json:"patch"`
+
+	Root string `json:"-"`
+	// PackageJSON and PackageLockJSON are the original files (and
+	// PackageParsed and PackageLockParsed the parsed equivalents). These are
+	// only present after `GenerateTidyFile()` or when a `.npm-mod.tidy.json`
+	// with snapshots was migrated by `ReadTidyFile()`.
+	PackageJSON       []byte              `json:"-"`
+	PackageLockJSON   []byte              `json:"-"`
+	PackageParsed     *ordered.OrderedMap `json:"-"`
+	PackageLockParsed *ordered.OrderedMap `json:"-"`
+	// Unmatched is populated by `TidyPackageJSON()` and
+	// `TidyPackageLockJSON()` with every dependency specifier that was left
+	// as-is (i.e. that will still require a fetch from the registry).
+	Unmatched []Unmatched `json:"-"`
+	// Drifted is populated by `Restore()` with the files that have changed
+	// since the last tidy.
+	Drifted []string `json:"-"`
+	// Unverified is populated by `Restore()` with the files that can't be
+	// checked for changes since the last tidy (see `FilePatch.Unverified()`).
+	Unverified []string `json:"-"`
+	// MigratedFrom is the version of the `.npm-mod.tidy.json` on disk if it
+	// was migrated when read by `ReadTidyFile()`.
+	MigratedFrom string `json:"-"`
+}
+
+// snapshotTidyFile represents a `.npm-mod.tidy.json` with version `26.10`.
+type snapshotTidyFile struct {
+	Version         string    `json:"version"`
+	PackageJSON     []byte    `json:"package.json"`
+	PackageLockJSON []byte    `json:"package-lock.json"`
+	Packages        []Package `json:"packages"`
+	KeepVersions    bool      `json:"keep-versions,omitempty"`
+}
+
+// legacyTidyFile represents a `.npm-mod.tidy.json` with version `22.05`.
+type legacyTidyFile struct {
 	Version         string            `json:"version"`
 	PackageJSON     []byte            `json:"package.json"`
 	PackageLockJSON []byte            `json:"package-lock.json"`
 	Packages        []RegistryPackage `json:"packages"`
-
-	Root              string              `json:"-"`
-	PackageParsed     *ordered.OrderedMap `json:"-"`
-	PackageLockParsed *ordered.OrderedMap `json:"-"`
 }
 
-// Persist writes a `.npm-mod.tidy.json` to disk.
-func (tf *TidyFile) Persist() error {
+// Persist writes a `.npm-mod.tidy.json` via a file writer. This must be called
+// after `TidyPackageJSON()` and `TidyPackageLockJSON()` so that the patch is
+// populated.
+func (tf *TidyFile) Persist(w FileWriter) error {
 	asJSON, err := json.MarshalIndent(tf, "", "  ")
 	if err != nil {
 		return err
@@ -48,36 +126,105 @@ func (tf *TidyFile) Persist() error {
 	asJSON = append(asJSON, '\n')
 
 	target := filepath.Join(tf.Root, ".npm-mod.tidy.json")
-	return os.WriteFile(target, asJSON, 0644)
+	return w.WriteFile(target, asJSON)
 }
 
-// Restore writes back a `package.json` and `package-lock.json` based on the
-// contents of a `.npm-mod.tidy.json` file.
-func (tf *TidyFile) Restore() error {
-	// NOTE: This should use the **existing** permissions of the `package.json`
-	//       instead of just hardcoding `0644`.
-	err := os.WriteFile(filepath.Join(tf.Root, "package.json"), tf.PackageJSON, 0644)
+// Restore writes back a `package.json` and `package-lock.json` (via a file
+// writer) by applying the patch in `.npm-mod.tidy.json` in reverse to the
+// current files. Any other changes made since the last tidy are kept (and the
+// affected files are recorded in `Drifted`), but a rewritten key that has
+// been changed since the last tidy is an error.
+func (tf *TidyFile) Restore(w FileWriter) error {
+	pjFilename := filepath.Join(tf.Root, "package.json")
+	pj, pjDrifted, err := tf.restoreFile(pjFilename, tf.Patch.PackageJSON)
+	if err != nil {
+		return err
+	}
+
+	plFilename := filepath.Join(tf.Root, "package-lock.json")
+	pl, plDrifted, err := tf.restoreFile(plFilename, tf.Patch.PackageLockJSON)
+	if err != nil {
+		return err
+	}
+
+	vendored, err := hasVendorReferences(pj, pl)
+	if err != nil {
+		return err
+	}
+	if vendored {
+		return fmt.Errorf("package.json or package-lock.json still refer to vendor/ after restoring; run npm-mod tidy first; %s", tf.Root)
+	}
+
+	mirrored, err := PackageLockMirroredURLs(pl, tf.Packages)
+	if err != nil {
+		return err
+	}
+	if len(mirrored) > 0 {
+		return fmt.Errorf("package-lock.json still refers to the mirror %s after restoring; run npm-mod tidy first; %s", tf.Mirror, tf.Root)
+	}
+
+	err = tf.writeRestored(w, pjFilename, tf.Patch.PackageJSON, pj, pjDrifted)
 	if err != nil {
 		return err
 	}
 
-	// NOTE: This should use the **existing** permissions of the `package-lock.json`
-	//       instead of just hardcoding `0644`.
-	return os.WriteFile(filepath.Join(tf.Root, "package-lock.json"), tf.PackageLockJSON, 0644)
+	return tf.writeRestored(w, plFilename, tf.Patch.PackageLockJSON, pl, plDrifted)
 }
 
-// TidyPackageJSON updates (and writes) a `package.json` file with the
-// vendored dependencies.
+// restoreFile reads a tidied file and applies a patch in reverse. It also
+// determines if the file has changed since the last tidy.
+func (tf *TidyFile) restoreFile(filename string, fp FilePatch) (*ordered.OrderedMap, bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	drifted := fp.Drifted(data)
+	if fp.Unverified() {
+		tf.Unverified = append(tf.Unverified, filepath.Base(filename))
+	} else if drifted {
+		tf.Drifted = append(tf.Drifted, filepath.Base(filename))
+	}
+
+	m := ordered.NewOrderedMap()
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = fp.Revert(m, true)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot restore %s; %w", filepath.Base(filename), err)
+	}
+
+	return m, drifted, nil
+}
+
+// writeRestored serializes a restored file in its original format and writes
+// it via a file writer.
+func (tf *TidyFile) writeRestored(w FileWriter, filename string, fp FilePatch, m *ordered.OrderedMap, drifted bool) error {
+	data, err := fp.Rebuild(m, drifted)
+	if err != nil {
+		return fmt.Errorf("cannot restore %s; %w", filepath.Base(filename), err)
+	}
+
+	return w.WriteFile(filename, data)
+}
+
+// TidyPackageJSON updates (and writes via a file writer) a `package.json` file
+// with the vendored dependencies.
 //
 // This is a bit hacky. The algorithm is as follows:
-// - Iterate over every package in `dependencies`, `devDependencies` and
-//   `peerDependencies`
+// - Iterate over every package in `dependencies`, `devDependencies`,
+//   `optionalDependencies` and `peerDependencies`
 // - Find the package in `packages` in the `package-lock.json`, for example the
 //   `node_modules/@testing-library/jest-dom` key corresponds to the
 //   `@testing-library/jest-dom` dependency
 // - Use the `resolved` URL for the `node_modules/...` match to determine the
 //   local filename to use
-func (tf *TidyFile) TidyPackageJSON() error {
+//
+// In `ModeRegistry` the dependencies are left as-is and in `ModePin` each
+// version range is replaced with the exact version instead.
+func (tf *TidyFile) TidyPackageJSON(w FileWriter) error {
 	// Re-parse package JSON so we can modify it without mutating the value
 	// stored on `tf`.
 	pj := ordered.NewOrderedMap()
@@ -86,30 +233,49 @@ func (tf *TidyFile) TidyPackageJSON() error {
 		return err
 	}
 
-	byNodeModulesPath, _, err := PackageLockExtractDependencies(tf.PackageLockParsed)
-	if err != nil {
-		return err
+	if tf.PinMode() {
+		err = tf.pinPackageJSON(pj)
+		if err != nil {
+			return err
+		}
+	} else if !tf.RegistryMode() {
+		byNodeModulesPath, _, err := PackageLockExtractDependencies(tf.PackageLockParsed)
+		if err != nil {
+			return err
+		}
+
+		pjr := PackageJSONReplace{ByNodeModulesPath: byNodeModulesPath}
+		err = PackageJSONReplaceDependencies(pj, pjr.Replace)
+		if err != nil {
+			return err
+		}
+		tf.Unmatched = append(tf.Unmatched, pjr.Unmatched...)
 	}
 
-	pjr := PackageJSONReplace{ByNodeModulesPath: byNodeModulesPath}
-	err = PackageJSONReplaceDependencies(pj, pjr.Replace)
-	if err != nil {
-		return err
-	}
-
-	asJSON, err := marshalWithoutHTMLEscape(pj)
+	fp, asJSON, err := NewFilePatch(tf.PackageParsed, pj, tf.PackageJSON)
 	if err != nil {
 		return err
 	}
+	tf.Patch.PackageJSON = fp
 
 	filename := filepath.Join(tf.Root, "package.json")
-	// NOTE: This should re-use the existing file permissions.
-	return os.WriteFile(filename, asJSON, 0644)
+	return w.WriteFile(filename, asJSON)
 }
 
-// TidyPackageJSON updates (and writes) a `package-lock.json` file with the
-// vendored dependencies.
-func (tf *TidyFile) TidyPackageLockJSON() error {
+// TidyPackageLockJSON updates (and writes via a file writer) a
+// `package-lock.json` file with the vendored dependencies.
+//
+// In addition to the `resolved` URLs, this keeps the root package (i.e.
+// `packages[""]`) in agreement with the tidied `package.json`. Unless
+// `KeepVersions` is set, the `version` of each vendored package is replaced
+// as well, so any dependency range that resolves to a vendored package is
+// replaced with a `file:` reference so that `npm ci` uses the vendored
+// package rather than fetching from the registry.
+//
+// In `ModeRegistry`, only the `resolved` URLs are replaced (with the URLs in
+// the mirror registry, which are recorded in `Packages`). In `ModePin`, only
+// the root package is updated to agree with the pinned `package.json`.
+func (tf *TidyFile) TidyPackageLockJSON(w FileWriter) error {
 	// Re-parse package lock so we can modify it without mutating the value
 	// stored on `tf`.
 	pl := ordered.NewOrderedMap()
@@ -120,29 +286,98 @@ func (tf *TidyFile) TidyPackageLockJSON() error {
 
 	// Just re-compute `resolved` mapping by URL (it should also be stored in
 	// `tf.Packages` but not as a map-by-URL).
-	_, byURL, err := PackageLockExtractDependencies(pl)
+	byNodeModulesPath, byURL, err := PackageLockExtractDependencies(pl)
 	if err != nil {
 		return err
 	}
 
-	plr := PackageLockReplace{ByURL: byURL}
-	err = PackageLockReplaceDependencies(pl, plr.Replace)
+	if tf.RegistryMode() {
+		err = tf.mirrorPackageLockJSON(pl, byURL)
+	} else if tf.PinMode() {
+		err = tf.pinPackageLockJSON(pl, byNodeModulesPath)
+	} else {
+		err = tf.vendorPackageLockJSON(pl, byNodeModulesPath, byURL)
+	}
 	if err != nil {
 		return err
 	}
 
-	asJSON, err := marshalWithoutHTMLEscape(pl)
+	fp, asJSON, err := NewFilePatch(tf.PackageLockParsed, pl, tf.PackageLockJSON)
 	if err != nil {
 		return err
 	}
+	tf.Patch.PackageLockJSON = fp
 
 	filename := filepath.Join(tf.Root, "package-lock.json")
-	// NOTE: This should re-use the existing file permissions.
-	return os.WriteFile(filename, asJSON, 0644)
+	return w.WriteFile(filename, asJSON)
+}
+
+// mirrorPackageLockJSON replaces every `resolved` URL in a `package-lock.json`
+// (in place) with the URL in the mirror registry and records the mirrored
+// URLs in `Packages`.
+func (tf *TidyFile) mirrorPackageLockJSON(pl *ordered.OrderedMap, byURL map[string]RegistryPackage) error {
+	mr := MirrorReplace{ByURL: byURL, Mirror: tf.Mirror}
+	err := PackageLockReplaceResolved(pl, mr.Replace)
+	if err != nil {
+		return err
+	}
+	tf.Unmatched = append(tf.Unmatched, mr.Unmatched...)
+
+	for i, p := range tf.Packages {
+		// NOTE: A package that can't be mirrored has already been reported
+		//       as unmatched.
+		tf.Packages[i].Mirrored, _ = MirroredURL(tf.Mirror, p.URL)
+	}
+	return nil
+}
+
+// vendorPackageLockJSON replaces every `resolved` URL in a `package-lock.json`
+// (in place) with a `file:vendor/...` reference, along with the root package
+// dependencies and (unless `KeepVersions` is set) the versions and dependency
+// ranges that refer to vendored packages.
+func (tf *TidyFile) vendorPackageLockJSON(pl *ordered.OrderedMap, byNodeModulesPath, byURL map[string]RegistryPackage) error {
+	for i := range tf.Packages {
+		tf.Packages[i].Mirrored = ""
+	}
+
+	plr := PackageLockReplace{ByURL: byURL}
+	err := PackageLockReplaceDependencies(pl, plr.Replace, tf.KeepVersions)
+	if err != nil {
+		return err
+	}
+	tf.Unmatched = append(tf.Unmatched, plr.Unmatched...)
+
+	// NOTE: The root package mirrors the `package.json` dependencies, so it
+	//       gets the same replacements as `TidyPackageJSON()` (which already
+	//       reports the unmatched dependencies).
+	pjr := PackageJSONReplace{ByNodeModulesPath: byNodeModulesPath}
+	err = PackageLockReplaceRoot(pl, pjr.Replace)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: When the real versions are kept, the dependency ranges are still
+	//       satisfied by the vendored packages and must not be replaced.
+	if tf.KeepVersions {
+		return nil
+	}
+	return PackageLockReplaceRanges(pl)
 }
 
 // GenerateTidyFile generates a `.npm-mod.tidy.json` by reading files from
 // a `package.json` and `package-lock.json`.
+//
+// If the `package.json` or `package-lock.json` have already been tidied (i.e.
+// they contain `file:vendor/...` references), the vendored references are
+// reverted using the patch in the existing `.npm-mod.tidy.json`.
+// This way re-running `npm-mod tidy` (e.g. after `npm install` added or
+// removed packages) never loses the original semver ranges or URLs. The
+// `KeepVersions` setting is also carried over from the existing
+// `.npm-mod.tidy.json`.
+//
+// Likewise, if the existing `.npm-mod.tidy.json` is in `ModeRegistry` (or
+// `ModePin`), the mirrored `resolved` URLs (or pinned versions) are reverted
+// and the mode and mirror are carried over.
 func GenerateTidyFile(root string) (*TidyFile, error) {
 	packageJSON, err := os.ReadFile(filepath.Join(root, "package.json"))
 	if err != nil {
@@ -165,16 +400,73 @@ func GenerateTidyFile(root string) (*TidyFile, error) {
 		return nil, err
 	}
 
+	vendored, err := hasVendorReferences(pj, pl)
+	if err != nil {
+		return nil, err
+	}
+	keepVersions := false
+	var previousPackages []Package
+	previousVendorHash := ""
+	if vendored {
+		previous, err := readPreviousTidyFile(root)
+		if err != nil {
+			return nil, err
+		}
+
+		packageJSON, packageLock, err = revertVendored(previous, pj, pl)
+		if err != nil {
+			return nil, err
+		}
+		keepVersions = previous.KeepVersions
+		previousPackages, previousVendorHash = previous.Packages, previous.VendorHash
+	}
+	mode, mirror := "", ""
+	if !vendored {
+		previous, err := readModeTidyFile(root)
+		if err != nil {
+			return nil, err
+		}
+
+		if previous != nil && previous.PinMode() {
+			packageJSON, packageLock, err = revertPinned(previous, pj, pl)
+		} else if previous != nil {
+			packageLock, err = revertMirrored(previous, pl)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil {
+			mode, mirror = previous.Mode, previous.Mirror
+		}
+	}
+
 	_, byURL, err := PackageLockExtractDependencies(pl)
 	if err != nil {
 		return nil, err
 	}
 
+	packages, err := describePackages(pl, byURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: `vendor/` is not hashed here (that's up to `npm-mod vendor`);
+	//       the previous hash still applies if the package archives are
+	//       unchanged.
+	vendorHash := ""
+	if vendored && sameArchives(previousPackages, packages) {
+		vendorHash = previousVendorHash
+	}
+
 	tf := TidyFile{
 		Version:         tidyFileVersion,
 		PackageJSON:     packageJSON,
 		PackageLockJSON: packageLock,
-		Packages:        sortedPackages(byURL),
+		Packages:        packages,
+		KeepVersions:    keepVersions,
+		Mode:            mode,
+		Mirror:          mirror,
+		VendorHash:      vendorHash,
 
 		Root:              root,
 		PackageParsed:     pj,
@@ -183,7 +475,53 @@ func GenerateTidyFile(root string) (*TidyFile, error) {
 	return &tf, nil
 }
 
-// ReadTidyFile reads a `.npm-mod.tidy.json` file.
+// ReadVendoredFilenames reads the `package-lock.json` in `root` and collects
+// the filenames of all package archives that it already refers to via
+// `file:vendor/...`.
+func ReadVendoredFilenames(root string) (map[string]bool, error) {
+	pl, err := ReadPackageLock(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return PackageLockVendoredFilenames(pl)
+}
+
+// ReadPackageJSON reads and parses the `package.json` in `root`.
+func ReadPackageJSON(root string) (*ordered.OrderedMap, error) {
+	packageJSON, err := os.ReadFile(filepath.Join(root, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pj := ordered.NewOrderedMap()
+	err = json.Unmarshal(packageJSON, &pj)
+	if err != nil {
+		return nil, err
+	}
+
+	return pj, nil
+}
+
+// ReadPackageLock reads and parses the `package-lock.json` in `root`.
+func ReadPackageLock(root string) (*ordered.OrderedMap, error) {
+	packageLock, err := os.ReadFile(filepath.Join(root, "package-lock.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pl := ordered.NewOrderedMap()
+	err = json.Unmarshal(packageLock, &pl)
+	if err != nil {
+		return nil, err
+	}
+
+	return pl, nil
+}
+
+// ReadTidyFile reads a `.npm-mod.tidy.json` file. A file with an older
+// version (i.e. with snapshots of the original files) is migrated to the
+// current version (in memory only; see `MigratedFrom`).
 func ReadTidyFile(root string) (*TidyFile, error) {
 	target := filepath.Join(root, ".npm-mod.tidy.json")
 	data, err := os.ReadFile(target)
@@ -191,16 +529,43 @@ func ReadTidyFile(root string) (*TidyFile, error) {
 		return nil, err
 	}
 
-	tf := TidyFile{
-		Root:              root,
-		PackageParsed:     ordered.NewOrderedMap(),
-		PackageLockParsed: ordered.NewOrderedMap(),
+	header := struct {
+		Version string `json:"version"`
+	}{}
+	err = json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	tf := TidyFile{Root: root}
+	var legacy *legacyTidyFile
+	switch header.Version {
+	case tidyFileVersion:
+		err = json.Unmarshal(data, &tf)
+		return &tf, err
+	case snapshotTidyFileVersion:
+		snapshot := snapshotTidyFile{}
+		err = json.Unmarshal(data, &snapshot)
+		tf.PackageJSON = snapshot.PackageJSON
+		tf.PackageLockJSON = snapshot.PackageLockJSON
+		tf.Packages = snapshot.Packages
+		tf.KeepVersions = snapshot.KeepVersions
+	case legacyTidyFileVersion:
+		legacy = &legacyTidyFile{}
+		err = json.Unmarshal(data, legacy)
+		tf.PackageJSON = legacy.PackageJSON
+		tf.PackageLockJSON = legacy.PackageLockJSON
+	default:
+		err = unsupportedVersionError(target, header.Version)
 	}
-	err = json.Unmarshal(data, &tf)
 	if err != nil {
 		return nil, err
 	}
 
+	tf.Version = tidyFileVersion
+	tf.MigratedFrom = header.Version
+	tf.PackageParsed = ordered.NewOrderedMap()
+	tf.PackageLockParsed = ordered.NewOrderedMap()
 	err = json.Unmarshal(tf.PackageJSON, &tf.PackageParsed)
 	if err != nil {
 		return nil, err
@@ -211,9 +576,256 @@ func ReadTidyFile(root string) (*TidyFile, error) {
 		return nil, err
 	}
 
+	if legacy != nil {
+		tf.Packages, err = migratePackages(tf.PackageLockParsed, legacy.Packages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tf.migratePatch()
+	if err != nil {
+		return nil, err
+	}
+
 	return &tf, nil
 }
 
+// migratePackages adds the metadata from the original `package-lock.json` to
+// the packages in a legacy `.npm-mod.tidy.json`.
+func migratePackages(packageLock *ordered.OrderedMap, legacy []RegistryPackage) ([]Package, error) {
+	byURL := map[string]RegistryPackage{}
+	for _, rp := range legacy {
+		byURL[rp.URL] = rp
+	}
+
+	return describePackages(packageLock, byURL)
+}
+
+// migratePatch computes the patch for a `.npm-mod.tidy.json` that has
+// snapshots of the original files by tidying the snapshots in memory.
+func (tf *TidyFile) migratePatch() error {
+	discard := NewTransaction()
+	err := tf.TidyPackageJSON(discard)
+	if err != nil {
+		return err
+	}
+
+	err = tf.TidyPackageLockJSON(discard)
+	if err != nil {
+		return err
+	}
+
+	tf.Unmatched = nil
+	return tf.migrateChecksums()
+}
+
+// migrateChecksums replaces the checksums in a migrated patch. The patch is
+// computed with the current tidy rules, so its checksums would never match the
+// files written by an older `npm-mod` (and every migrated project would appear
+// to have changed since the last tidy). For a 22.05 `.npm-mod.tidy.json` the
+// tidied files are reproduced from the snapshots with the legacy rules. The
+// rules for a 26.10 `.npm-mod.tidy.json` can't be reproduced, so its patch is
+// left without checksums (i.e. unverified) until the next tidy.
+func (tf *TidyFile) migrateChecksums() error {
+	if tf.MigratedFrom != legacyTidyFileVersion {
+		tf.Patch.PackageJSON.Checksum = ""
+		tf.Patch.PackageLockJSON.Checksum = ""
+		return nil
+	}
+
+	packageJSON, packageLock, err := legacyTidy(tf.PackageJSON, tf.PackageLockJSON)
+	if err != nil {
+		return err
+	}
+
+	tf.Patch.PackageJSON.Checksum = checksum(packageJSON)
+	tf.Patch.PackageLockJSON.Checksum = checksum(packageLock)
+	return nil
+}
+
+// unsupportedVersionError explains why a `.npm-mod.tidy.json` can't be read
+// based on its version.
+func unsupportedVersionError(filename, version string) error {
+	if version == "" {
+		return fmt.Errorf("%s has no version; expected %q", filename, tidyFileVersion)
+	}
+	if newerVersion(version, tidyFileVersion) {
+		return fmt.Errorf("%s has version %q, which is newer than the supported version %q; upgrade npm-mod", filename, version, tidyFileVersion)
+	}
+	return fmt.Errorf("%s has unsupported version %q; expected %q (or %q or %q, which are migrated automatically)", filename, version, tidyFileVersion, snapshotTidyFileVersion, legacyTidyFileVersion)
+}
+
+// newerVersion determines if a `YY.MM` tidy file version is newer than
+// `than`. The parts are compared as integers, so e.g. `100.01` is newer than
+// `26.11` and `9.01` is not. A version that is not in the `YY.MM` format is
+// never newer.
+func newerVersion(version, than string) bool {
+	year, month, ok := splitVersion(version)
+	if !ok {
+		return false
+	}
+	thanYear, thanMonth, ok := splitVersion(than)
+	if !ok {
+		return false
+	}
+
+	if year != thanYear {
+		return year > thanYear
+	}
+	return month > thanMonth
+}
+
+// splitVersion splits a `YY.MM` tidy file version into its parts.
+func splitVersion(version string) (int, int, bool) {
+	yearPart, monthPart, ok := strings.Cut(version, ".")
+	if !ok {
+		return 0, 0, false
+	}
+
+	year, err := strconv.Atoi(yearPart)
+	if err != nil || year < 0 {
+		return 0, 0, false
+	}
+	month, err := strconv.Atoi(monthPart)
+	if err != nil || month < 0 {
+		return 0, 0, false
+	}
+
+	return year, month, true
+}
+
+// hasVendorReferences determines if a `package.json` or `package-lock.json`
+// contain any `file:vendor/...` references, i.e. if they have already been
+// tidied.
+func hasVendorReferences(pj, pl *ordered.OrderedMap) (bool, error) {
+	pjFilenames, err := PackageJSONVendoredFilenames(pj)
+	if err != nil {
+		return false, err
+	}
+
+	plFilenames, err := PackageLockVendoredFilenames(pl)
+	if err != nil {
+		return false, err
+	}
+
+	return len(pjFilenames) > 0 || len(plFilenames) > 0, nil
+}
+
+// readPreviousTidyFile reads the `.npm-mod.tidy.json` for a project that has
+// already been tidied.
+func readPreviousTidyFile(root string) (*TidyFile, error) {
+	previous, err := ReadTidyFile(root)
+	if err != nil && os.IsNotExist(err) {
+		return nil, fmt.Errorf("package.json or package-lock.json refer to vendor/ but .npm-mod.tidy.json does not exist; %s", root)
+	}
+	return previous, err
+}
+
+// readModeTidyFile reads the `.npm-mod.tidy.json` for a project that may have
+// been tidied in `ModeRegistry` or `ModePin`. If there is no
+// `.npm-mod.tidy.json` or it is in `ModeVendor`, this returns `nil`.
+func readModeTidyFile(root string) (*TidyFile, error) {
+	previous, err := ReadTidyFile(root)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !previous.RegistryMode() && !previous.PinMode() {
+		return nil, nil
+	}
+	return previous, nil
+}
+
+// revertVendored reverts all `file:vendor/...` references in a tidied
+// `package.json` and `package-lock.json` (in place) based on the existing
+// `.npm-mod.tidy.json`. The patch is applied in reverse first; any references
+// it doesn't cover (e.g. because `npm install` rewrote an entry) are then
+// reverted based on the original values in the patch and the tracked packages.
+// Any packages that have been added since the last tidy (i.e. that still refer
+// to the registry) are left untouched and packages that have been removed are
+// no longer present.
+func revertVendored(previous *TidyFile, pj, pl *ordered.OrderedMap) ([]byte, []byte, error) {
+	err := previous.Patch.PackageJSON.Revert(pj, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = previous.Patch.PackageLockJSON.Revert(pl, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	originalPackageJSON := previous.Patch.PackageJSON.Originals()
+	for _, key := range packageJSONDependencyKeys {
+		rd := RevertDependency{Original: originalPackageJSON, ParentKey: key}
+		err := walkPackageJSON(pj, key, rd.Visit)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	byFilename, err := packagesByFilename(previous.Packages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rr := RevertResolved{ByFilename: byFilename, ParentKey: "packages"}
+	err = walkPackageLockPackages(pl, rr.Visit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rr = RevertResolved{ByFilename: byFilename, ParentKey: "dependencies"}
+	err = walkPackageLockDependencies(pl, rr.Visit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = revertPackageLockRanges(previous.Patch.PackageLockJSON.Originals(), pl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return previous.Patch.rebuild(pj, pl)
+}
+
+// revertPackageLockRanges reverts the dependencies of the root package and the
+// dependency ranges of every other package in a tidied `package-lock.json` (in
+// place) based on the `original` package lock.
+func revertPackageLockRanges(original, pl *ordered.OrderedMap) error {
+	originalRoot, err := lockPackage(original, "")
+	if err != nil {
+		return err
+	}
+
+	root, err := lockPackage(pl, "")
+	if err != nil {
+		return err
+	}
+
+	if root != nil {
+		for _, key := range packageJSONDependencyKeys {
+			rd := RevertDependency{Original: originalRoot, ParentKey: key}
+			err = walkPackageJSON(root, key, rd.Visit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	originalPackages, err := lockPackages(original)
+	if err != nil {
+		return err
+	}
+
+	rr := RevertRanges{Original: originalPackages}
+	return walkPackageLockPackages(pl, rr.Visit)
+}
+
 func resolvedKeys(byURL map[string]RegistryPackage) []string {
 	keys := []string{}
 	for k := range byURL {
@@ -222,12 +834,3 @@ func resolvedKeys(byURL map[string]RegistryPackage) []string {
 	sort.Strings(keys)
 	return keys
 }
-
-func sortedPackages(byURL map[string]RegistryPackage) []RegistryPackage {
-	keys := resolvedKeys(byURL)
-	resolved := make([]RegistryPackage, len(keys))
-	for i, k := range keys {
-		resolved[i] = byURL[k]
-	}
-	return resolved
-}
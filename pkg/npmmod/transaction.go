@@ -0,0 +1,303 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// NOTE: Ensure that
+//       * `Transaction{}` satisfies `FileWriter`.
+//       * `Transaction{}` satisfies `FileRemover`.
+var (
+	_ FileWriter  = (*Transaction)(nil)
+	_ FileRemover = (*Transaction)(nil)
+)
+
+const (
+	defaultFileMode os.FileMode = 0644
+)
+
+// Transaction stages a set of file writes (and removals) so that they can be
+// applied (or not) as a single unit. Writes are held in memory until `Commit()`
+// is called.
+type Transaction struct {
+	staged []StagedFile
+}
+
+// StagedFile is a file write (or removal) that has been staged in a
+// transaction.
+type StagedFile struct {
+	Filename string
+	Data     []byte
+	// Remove is set if the file should be removed rather than written.
+	Remove bool
+}
+
+// NewTransaction creates a new (empty) transaction.
+func NewTransaction() *Transaction {
+	return &Transaction{}
+}
+
+// WriteFile stages a write of `data` to `filename`. Staging the same filename
+// more than once replaces the previously staged contents (or removal).
+func (t *Transaction) WriteFile(filename string, data []byte) error {
+	return t.stage(StagedFile{Filename: filename, Data: data})
+}
+
+// RemoveFile stages the removal of `filename`; it is not an error if the file
+// does not exist. Staging the same filename more than once replaces the
+// previously staged contents.
+func (t *Transaction) RemoveFile(filename string) error {
+	return t.stage(StagedFile{Filename: filename, Remove: true})
+}
+
+func (t *Transaction) stage(sf StagedFile) error {
+	filename, err := filepath.Abs(sf.Filename)
+	if err != nil {
+		return err
+	}
+	sf.Filename = filename
+
+	for i, existing := range t.staged {
+		if existing.Filename == filename {
+			t.staged[i] = sf
+			return nil
+		}
+	}
+
+	t.staged = append(t.staged, sf)
+	return nil
+}
+
+// Staged returns all staged writes (and removals), in the order they were
+// first staged.
+func (t *Transaction) Staged() []StagedFile {
+	return t.staged
+}
+
+// ReadFile returns the staged contents of `filename` or, if no write has been
+// staged for it, the current contents on disk. If the removal of `filename`
+// has been staged, the error satisfies `os.IsNotExist()`.
+func (t *Transaction) ReadFile(filename string) ([]byte, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sw := range t.staged {
+		if sw.Filename == filename && sw.Remove {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+		}
+		if sw.Filename == filename {
+			return sw.Data, nil
+		}
+	}
+
+	return os.ReadFile(filename)
+}
+
+// Commit applies all staged writes and removals. Each file is first written to
+// a temporary file in the same directory as the target (with the mode and
+// ownership of the existing target, if any) and then all temporary files are
+// renamed into place (and staged removals are removed) in the order they were
+// staged. If any step fails, every target that was already replaced (or
+// removed) is rolled back to its previous contents and all temporary files
+// are removed.
+func (t *Transaction) Commit() error {
+	prepared := make([]*preparedWrite, 0, len(t.staged))
+	for _, sw := range t.staged {
+		pw, err := prepareWrite(sw)
+		if err != nil {
+			return maybeMultiError(err, cleanupPrepared(prepared))
+		}
+		// NOTE: There is nothing to do when removing a file that doesn't
+		//       exist.
+		if pw.Remove && !pw.Existed {
+			continue
+		}
+		prepared = append(prepared, pw)
+	}
+
+	for i, pw := range prepared {
+		err := applyPrepared(pw)
+		if err != nil {
+			return maybeMultiError(err, rollbackPrepared(prepared[:i]), cleanupPrepared(prepared[i:]))
+		}
+	}
+
+	t.staged = nil
+	return nil
+}
+
+// preparedWrite is a staged write that has been written to a temporary file
+// and is ready to be renamed into place, or a staged removal (which has no
+// temporary file).
+type preparedWrite struct {
+	Filename  string
+	Temporary string
+	Remove    bool
+	Mode      os.FileMode
+	Existed   bool
+	Previous  []byte
+	// Info describes the existing target (if any); it is used to restore the
+	// ownership of a removed file during a rollback.
+	Info os.FileInfo
+}
+
+func prepareWrite(sf StagedFile) (*preparedWrite, error) {
+	pw := preparedWrite{Filename: sf.Filename, Remove: sf.Remove, Mode: defaultFileMode}
+	fi, err := os.Stat(sf.Filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		pw.Existed = true
+		pw.Mode = fi.Mode().Perm()
+		pw.Info = fi
+		pw.Previous, err = os.ReadFile(sf.Filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if sf.Remove {
+		return &pw, nil
+	}
+
+	pw.Temporary, err = writeTemporary(sf.Filename, sf.Data, pw.Mode, fi)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pw, nil
+}
+
+// applyPrepared renames a prepared write into place, or removes the target of
+// a prepared removal.
+func applyPrepared(pw *preparedWrite) error {
+	if pw.Remove {
+		err := os.Remove(pw.Filename)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s; %w", pw.Filename, err)
+		}
+		return nil
+	}
+
+	err := os.Rename(pw.Temporary, pw.Filename)
+	if err != nil {
+		return fmt.Errorf("failed to replace %s; %w", pw.Filename, err)
+	}
+	return nil
+}
+
+// writeTemporary writes `data` to a temporary file in the same directory as
+// `filename` so that it can be atomically renamed into place. The temporary
+// file uses the given mode and the ownership from `existing` (if not `nil`).
+func writeTemporary(filename string, data []byte, mode os.FileMode, existing os.FileInfo) (string, error) {
+	dir, base := filepath.Split(filename)
+	f, err := os.CreateTemp(dir, "."+base+".npm-mod-*")
+	if err != nil {
+		return "", err
+	}
+	temporary := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temporary, mode)
+	}
+	if err == nil && existing != nil {
+		err = chownLike(temporary, existing)
+	}
+	if err != nil {
+		return "", maybeMultiError(err, os.Remove(temporary))
+	}
+
+	return temporary, nil
+}
+
+// rollbackPrepared restores the previous contents of every target in
+// `prepared`; these are expected to have already been renamed into place (or
+// removed).
+func rollbackPrepared(prepared []*preparedWrite) error {
+	errs := []error{}
+	for _, pw := range prepared {
+		if !pw.Existed {
+			errs = append(errs, os.Remove(pw.Filename))
+			continue
+		}
+
+		fi := pw.Info
+		var err error
+		if !pw.Remove {
+			fi, err = os.Stat(pw.Filename)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		temporary, err := writeTemporary(pw.Filename, pw.Previous, pw.Mode, fi)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, os.Rename(temporary, pw.Filename))
+	}
+
+	return maybeMultiError(errs...)
+}
+
+// cleanupPrepared removes the temporary file for every write in `prepared`;
+// these are expected to **not** have been renamed into place.
+func cleanupPrepared(prepared []*preparedWrite) error {
+	errs := []error{}
+	for _, pw := range prepared {
+		if pw.Remove {
+			continue
+		}
+
+		err := os.Remove(pw.Temporary)
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	return maybeMultiError(errs...)
+}
+
+// maybeMultiError combines a slice of errors (each of which may be `nil`).
+//
+// This **only** uses a `multierror` if two or more errors are not `nil`.
+func maybeMultiError(errors ...error) error {
+	err := multierror.Append(nil, errors...)
+	if len(err.Errors) == 0 {
+		return nil
+	}
+	if len(err.Errors) == 1 {
+		return err.Errors[0]
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestTransaction_Commit(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	destination := tempDir(t, assert)
+	existing := filepath.Join(destination, "existing.json")
+	err := os.WriteFile(existing, []byte("old"), 0600)
+	assert.Nil(err)
+	err = os.Chmod(existing, 0640)
+	assert.Nil(err)
+	created := filepath.Join(destination, "created.json")
+
+	txn := npmmod.NewTransaction()
+	err = txn.WriteFile(existing, []byte("first"))
+	assert.Nil(err)
+	err = txn.WriteFile(created, []byte("created"))
+	assert.Nil(err)
+	err = txn.WriteFile(existing, []byte("second"))
+	assert.Nil(err)
+
+	// Nothing is written until commit.
+	data, err := os.ReadFile(existing)
+	assert.Nil(err)
+	assert.Equal("old", string(data))
+	_, err = os.Stat(created)
+	assert.True(os.IsNotExist(err))
+
+	err = txn.Commit()
+	assert.Nil(err)
+
+	data, err = os.ReadFile(existing)
+	assert.Nil(err)
+	assert.Equal("second", string(data))
+	fi, err := os.Stat(existing)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0640), fi.Mode().Perm())
+
+	data, err = os.ReadFile(created)
+	assert.Nil(err)
+	assert.Equal("created", string(data))
+	fi, err = os.Stat(created)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0644), fi.Mode().Perm())
+
+	assert.Equal([]string{"created.json", "existing.json"}, dirNames(assert, destination))
+}
+
+func TestTransaction_Commit_Failure(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	destination := tempDir(t, assert)
+	existing := filepath.Join(destination, "existing.json")
+	err := os.WriteFile(existing, []byte("old"), 0644)
+	assert.Nil(err)
+	directory := filepath.Join(destination, "directory")
+	err = os.Mkdir(directory, 0755)
+	assert.Nil(err)
+
+	txn := npmmod.NewTransaction()
+	err = txn.WriteFile(existing, []byte("new"))
+	assert.Nil(err)
+	err = txn.WriteFile(filepath.Join(destination, "created.json"), []byte("created"))
+	assert.Nil(err)
+	// Writing over a directory fails after the first two writes are staged.
+	err = txn.WriteFile(directory, []byte("oops"))
+	assert.Nil(err)
+
+	err = txn.Commit()
+	assert.NotNil(err)
+
+	data, err := os.ReadFile(existing)
+	assert.Nil(err)
+	assert.Equal("old", string(data))
+	assert.Equal([]string{"directory", "existing.json"}, dirNames(assert, destination))
+}
+
+func TestTransaction_RemoveFile(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	destination := tempDir(t, assert)
+	existing := filepath.Join(destination, "existing.json")
+	err := os.WriteFile(existing, []byte("old"), 0644)
+	assert.Nil(err)
+	replaced := filepath.Join(destination, "replaced.json")
+
+	txn := npmmod.NewTransaction()
+	err = txn.RemoveFile(existing)
+	assert.Nil(err)
+	err = txn.RemoveFile(filepath.Join(destination, "missing.json"))
+	assert.Nil(err)
+	// A staged write replaces a staged removal.
+	err = txn.RemoveFile(replaced)
+	assert.Nil(err)
+	err = txn.WriteFile(replaced, []byte("replaced"))
+	assert.Nil(err)
+
+	// Nothing is removed until commit, but reads see the staged removal.
+	_, err = txn.ReadFile(existing)
+	assert.True(os.IsNotExist(err))
+	assert.Equal([]string{"existing.json"}, dirNames(assert, destination))
+
+	err = txn.Commit()
+	assert.Nil(err)
+	assert.Equal([]string{"replaced.json"}, dirNames(assert, destination))
+}
+
+func TestTransaction_RemoveFile_Failure(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	destination := tempDir(t, assert)
+	existing := filepath.Join(destination, "existing.json")
+	err := os.WriteFile(existing, []byte("old"), 0600)
+	assert.Nil(err)
+	directory := filepath.Join(destination, "directory")
+	err = os.Mkdir(directory, 0755)
+	assert.Nil(err)
+
+	txn := npmmod.NewTransaction()
+	err = txn.RemoveFile(existing)
+	assert.Nil(err)
+	// Writing over a directory fails after the removal.
+	err = txn.WriteFile(directory, []byte("oops"))
+	assert.Nil(err)
+
+	err = txn.Commit()
+	assert.NotNil(err)
+
+	// The removed file is restored, along with its mode.
+	data, err := os.ReadFile(existing)
+	assert.Nil(err)
+	assert.Equal("old", string(data))
+	fi, err := os.Stat(existing)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0600), fi.Mode().Perm())
+	assert.Equal([]string{"directory", "existing.json"}, dirNames(assert, destination))
+}
+
+func tempDir(t *testing.T, assert *testifyassert.Assertions) string {
+	destination, err := os.MkdirTemp("", "")
+	assert.Nil(err)
+	t.Cleanup(func() {
+		err = os.RemoveAll(destination)
+		assert.Nil(err)
+	})
+	return destination
+}
+
+func dirNames(assert *testifyassert.Assertions, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
@@ -20,45 +20,72 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"hash"
+	"io"
+	"os"
 )
 
 // ValidateIntegrity checks the hash of a downloaded package.
 func ValidateIntegrity(data []byte, algorithm, hash string) error {
-	if algorithm == "sha1" {
-		return validateIntegritySHA1(data, hash)
+	h, err := newIntegrityHash(algorithm)
+	if err != nil {
+		return err
 	}
-	if algorithm == "sha512" {
-		return validateIntegritySHA512(data, hash)
+
+	_, err = h.Write(data)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("unexpected integrity format; %s", algorithm)
+
+	return validateDigest(algorithm, hash, h.Sum(nil))
 }
 
-func validateIntegritySHA1(data []byte, hashBase64 string) error {
-	expected, err := base64.StdEncoding.DecodeString(hashBase64)
+// ValidateFileIntegrity checks the hash of a package archive on disk, without
+// reading the whole file into memory.
+func ValidateFileIntegrity(filename, algorithm, hash string) error {
+	h, err := newIntegrityHash(algorithm)
 	if err != nil {
 		return err
 	}
 
-	actual := sha1.Sum(data)
-	if subtle.ConstantTimeCompare(expected, actual[:]) == 1 {
-		return nil
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return err
 	}
 
-	actualBase64 := base64.StdEncoding.EncodeToString(actual[:])
-	return fmt.Errorf("sha1 hashes do not match; expected: %s; actual: %s", hashBase64, actualBase64)
+	return validateDigest(algorithm, hash, h.Sum(nil))
+}
+
+// newIntegrityHash creates a hash for one of the integrity algorithms used
+// by `npm`, so that a package archive can be hashed as it is streamed.
+func newIntegrityHash(algorithm string) (hash.Hash, error) {
+	if algorithm == "sha1" {
+		return sha1.New(), nil
+	}
+	if algorithm == "sha512" {
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unexpected integrity format; %s", algorithm)
 }
 
-func validateIntegritySHA512(data []byte, hashBase64 string) error {
+// validateDigest checks an `actual` digest (computed with `newIntegrityHash()`)
+// against the expected (base64 encoded) hash.
+func validateDigest(algorithm, hashBase64 string, actual []byte) error {
 	expected, err := base64.StdEncoding.DecodeString(hashBase64)
 	if err != nil {
 		return err
 	}
 
-	actual := sha512.Sum512(data)
-	if subtle.ConstantTimeCompare(expected, actual[:]) == 1 {
+	if subtle.ConstantTimeCompare(expected, actual) == 1 {
 		return nil
 	}
 
-	actualBase64 := base64.StdEncoding.EncodeToString(actual[:])
-	return fmt.Errorf("sha512 hashes do not match; expected: %s; actual: %s", hashBase64, actualBase64)
+	actualBase64 := base64.StdEncoding.EncodeToString(actual)
+	return fmt.Errorf("%s hashes do not match; expected: %s; actual: %s", algorithm, hashBase64, actualBase64)
 }
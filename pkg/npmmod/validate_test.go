@@ -15,6 +15,7 @@
 package npmmod_test
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -45,3 +46,18 @@ func TestValidateIntegrity_SHA512(t *testing.T) {
 	err = npmmod.ValidateIntegrity(data, "sha512", "7++dFhtcx3353uBaq8DDR4NuxBetBzC7ZQOhmTQInHEd6bSrXdiEyzCvG07Z44UYdLShWUyXt5M/yhz8ekcb1A==")
 	assert.Nil(err)
 }
+
+func TestValidateFileIntegrity(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	filename := filepath.Join("testdata", "shebang-regex-3.0.0.tgz")
+	err := npmmod.ValidateFileIntegrity(filename, "sha512", "7++dFhtcx3353uBaq8DDR4NuxBetBzC7ZQOhmTQInHEd6bSrXdiEyzCvG07Z44UYdLShWUyXt5M/yhz8ekcb1A==")
+	assert.Nil(err)
+
+	err = npmmod.ValidateFileIntegrity(filename, "sha1", "y5T662HIaWRR2zZTThQi+U8K7og=")
+	assert.Equal("sha1 hashes do not match; expected: y5T662HIaWRR2zZTThQi+U8K7og=; actual: rhbxZE2HPsrYQ7AwexQzYtTEIXI=", fmt.Sprintf("%v", err))
+
+	err = npmmod.ValidateFileIntegrity(filepath.Join("testdata", "missing.tgz"), "sha1", "y5T662HIaWRR2zZTThQi+U8K7og=")
+	assert.True(os.IsNotExist(err))
+}
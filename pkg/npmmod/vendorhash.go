@@ -0,0 +1,228 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	vendorHashPrefix = "h1:"
+)
+
+var (
+	// vendorCacheFilename is the cache of the `vendor/` hash used by
+	// `CachedVendorHash()`. It lives in `node_modules/` since it only
+	// applies to this checkout (and so must never be committed).
+	vendorCacheFilename = filepath.Join("node_modules", ".cache", "npm-mod", "vendor-hash.json")
+)
+
+// ArchiveDigest is the result of hashing a single package archive in
+// `vendor/` with `HashArchive()`.
+type ArchiveDigest struct {
+	Package Package
+	// SHA256 is the SHA-256 of the contents, used by `VendorHash()`.
+	SHA256 []byte
+	// Integrity is the digest of the contents with the algorithm of the
+	// file integrity, e.g. SHA-512.
+	Integrity []byte
+}
+
+// HashArchive hashes a package archive in `vendor/` once (streaming it rather
+// than reading it into memory) for both `VendorHash()` and the file integrity
+// check in `ArchiveDigest.Validate()`.
+//
+// If the archive is missing, the error satisfies `os.IsNotExist()`.
+func HashArchive(root string, p Package) (ArchiveDigest, error) {
+	integrity, err := newIntegrityHash(p.Algorithm)
+	if err != nil {
+		return ArchiveDigest{}, err
+	}
+
+	f, err := os.Open(filepath.Join(root, "vendor", p.Archive))
+	if err != nil {
+		return ArchiveDigest{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(h, integrity), f)
+	if err != nil {
+		return ArchiveDigest{}, err
+	}
+
+	ad := ArchiveDigest{Package: p, SHA256: h.Sum(nil), Integrity: integrity.Sum(nil)}
+	return ad, nil
+}
+
+// Validate checks the package archive against its expected file integrity.
+func (ad ArchiveDigest) Validate() error {
+	return validateDigest(ad.Package.Algorithm, ad.Package.Hash, ad.Integrity)
+}
+
+// VendorHash computes a deterministic hash of the package archives in
+// `vendor/` (similar to the `h1:` hash Go uses for module directories). The
+// hash covers the filename, the expected integrity and the SHA-256 of the
+// contents of each archive, sorted by filename, so a single comparison is
+// enough to determine if `vendor/` has changed.
+//
+// If any archive is missing, the error satisfies `os.IsNotExist()`.
+func VendorHash(root string, packages []Package) (string, error) {
+	digests := make([]ArchiveDigest, len(packages))
+	for i, p := range packages {
+		ad, err := HashArchive(root, p)
+		if err != nil {
+			return "", err
+		}
+		digests[i] = ad
+	}
+
+	return VendorHashFromDigests(digests), nil
+}
+
+// VendorHashFromDigests computes the `VendorHash()` from the digests of every
+// package archive, e.g. after they have been hashed concurrently.
+func VendorHashFromDigests(digests []ArchiveDigest) string {
+	sorted := make([]ArchiveDigest, len(digests))
+	copy(sorted, digests)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Package.Archive < sorted[j].Package.Archive
+	})
+
+	h := sha256.New()
+	for _, ad := range sorted {
+		p := ad.Package
+		fmt.Fprintf(h, "%x  %s  %s-%s\n", ad.SHA256, p.Archive, p.Algorithm, p.Hash)
+	}
+
+	return vendorHashPrefix + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// sameArchives determines if two lists of packages have the same package
+// archives (i.e. filenames and file integrity), in which case a
+// `VendorHash()` of one also applies to the other.
+func sameArchives(packages, other []Package) bool {
+	describe := func(packages []Package) map[string]string {
+		described := map[string]string{}
+		for _, p := range packages {
+			described[p.Archive] = p.Algorithm + "-" + p.Hash
+		}
+		return described
+	}
+
+	a, b := describe(packages), describe(other)
+	if len(a) != len(b) {
+		return false
+	}
+	for archive, integrity := range a {
+		if b[archive] != integrity {
+			return false
+		}
+	}
+	return true
+}
+
+// vendorCache is the cache of the `vendor/` hash, keyed on the size and
+// modification time of every package archive.
+type vendorCache struct {
+	VendorHash string `json:"vendor-hash"`
+	// Written is when the cache was written; an archive modified at (or
+	// after) that time can't be told apart from an unmodified one.
+	Written  time.Time             `json:"written"`
+	Archives map[string]vendorStat `json:"archives"`
+}
+
+type vendorStat struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// CachedVendorHash returns the `VendorHash()` recorded by `CacheVendorHash()`
+// if no package archive has changed size or modification time since (and so
+// without reading any of them). It returns an empty string if there is no
+// such cache or it no longer applies.
+func CachedVendorHash(root string, packages []Package) string {
+	data, err := os.ReadFile(filepath.Join(root, vendorCacheFilename))
+	if err != nil {
+		return ""
+	}
+
+	cache := vendorCache{}
+	err = json.Unmarshal(data, &cache)
+	if err != nil || len(cache.Archives) != len(packages) {
+		return ""
+	}
+
+	for _, p := range packages {
+		stat, ok := cache.Archives[p.Archive]
+		if !ok {
+			return ""
+		}
+
+		info, err := os.Stat(filepath.Join(root, "vendor", p.Archive))
+		if err != nil || info.Size() != stat.Size || !info.ModTime().Equal(stat.ModTime) {
+			return ""
+		}
+		// NOTE: Much like a "racily clean" file in `git`, an archive that
+		//       was modified in the same second the cache was written may
+		//       have been modified again without changing its mtime.
+		if info.ModTime().Unix() >= cache.Written.Unix() {
+			return ""
+		}
+	}
+
+	return cache.VendorHash
+}
+
+// CacheVendorHash records the `VendorHash()` of the package archives in
+// `vendor/` along with their sizes and modification times, so that
+// `CachedVendorHash()` can skip reading them. The cache is only an
+// optimization, so failing to write it is not an error.
+func CacheVendorHash(root string, packages []Package, vendorHash string) {
+	cache := vendorCache{VendorHash: vendorHash, Written: time.Now(), Archives: map[string]vendorStat{}}
+	for _, p := range packages {
+		info, err := os.Stat(filepath.Join(root, "vendor", p.Archive))
+		if err != nil {
+			return
+		}
+		cache.Archives[p.Archive] = vendorStat{Size: info.Size(), ModTime: info.ModTime()}
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+
+	filename := filepath.Join(root, vendorCacheFilename)
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return
+	}
+
+	txn := NewTransaction()
+	err = txn.WriteFile(filename, data)
+	if err != nil {
+		return
+	}
+	_ = txn.Commit()
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package npmmod_test
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+func TestVendorHash(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := tempDir(t, assert)
+	err := os.Mkdir(filepath.Join(root, "vendor"), 0755)
+	assert.Nil(err)
+	packages := []npmmod.Package{
+		{Archive: "b-2.0.0.tgz", RegistryPackage: npmmod.RegistryPackage{Algorithm: "sha512", Hash: "Yg=="}},
+		{Archive: "a-1.0.0.tgz", RegistryPackage: npmmod.RegistryPackage{Algorithm: "sha512", Hash: "YQ=="}},
+	}
+	for _, p := range packages {
+		err = os.WriteFile(filepath.Join(root, "vendor", p.Archive), []byte(p.Archive), 0644)
+		assert.Nil(err)
+	}
+
+	vendorHash, err := npmmod.VendorHash(root, packages)
+	assert.Nil(err)
+	assert.Equal("h1:SpcMCvYUKvwc/G/LMe/mGOG1yZKF3ukL+CZs+yoqLBk=", vendorHash)
+
+	// The order of the packages doesn't matter.
+	reversed, err := npmmod.VendorHash(root, []npmmod.Package{packages[1], packages[0]})
+	assert.Nil(err)
+	assert.Equal(vendorHash, reversed)
+
+	// Changing the contents of an archive changes the hash.
+	err = os.WriteFile(filepath.Join(root, "vendor", "a-1.0.0.tgz"), []byte("changed"), 0644)
+	assert.Nil(err)
+	changed, err := npmmod.VendorHash(root, packages)
+	assert.Nil(err)
+	assert.NotEqual(vendorHash, changed)
+
+	err = os.Remove(filepath.Join(root, "vendor", "b-2.0.0.tgz"))
+	assert.Nil(err)
+	_, err = npmmod.VendorHash(root, packages)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestHashArchive(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := tempDir(t, assert)
+	err := os.Mkdir(filepath.Join(root, "vendor"), 0755)
+	assert.Nil(err)
+	contents := []byte("a-1.0.0.tgz")
+	sum := sha512.Sum512(contents)
+	p := npmmod.Package{
+		Archive:         "a-1.0.0.tgz",
+		RegistryPackage: npmmod.RegistryPackage{Algorithm: "sha512", Hash: base64.StdEncoding.EncodeToString(sum[:])},
+	}
+
+	_, err = npmmod.HashArchive(root, p)
+	assert.True(os.IsNotExist(err))
+
+	err = os.WriteFile(filepath.Join(root, "vendor", p.Archive), contents, 0644)
+	assert.Nil(err)
+	ad, err := npmmod.HashArchive(root, p)
+	assert.Nil(err)
+	assert.Nil(ad.Validate())
+	assert.Equal(sum[:], ad.Integrity)
+
+	// The digests agree with `VendorHash()`.
+	vendorHash, err := npmmod.VendorHash(root, []npmmod.Package{p})
+	assert.Nil(err)
+	assert.Equal(vendorHash, npmmod.VendorHashFromDigests([]npmmod.ArchiveDigest{ad}))
+
+	err = os.WriteFile(filepath.Join(root, "vendor", p.Archive), []byte("corrupted"), 0644)
+	assert.Nil(err)
+	ad, err = npmmod.HashArchive(root, p)
+	assert.Nil(err)
+	assert.NotNil(ad.Validate())
+}
+
+func TestCachedVendorHash(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	root := tempDir(t, assert)
+	err := os.Mkdir(filepath.Join(root, "vendor"), 0755)
+	assert.Nil(err)
+	packages := []npmmod.Package{
+		{Archive: "a-1.0.0.tgz", RegistryPackage: npmmod.RegistryPackage{Algorithm: "sha512", Hash: "YQ=="}},
+		{Archive: "b-2.0.0.tgz", RegistryPackage: npmmod.RegistryPackage{Algorithm: "sha512", Hash: "Yg=="}},
+	}
+	// NOTE: Archives modified in the same second the cache is written are
+	//       never trusted, so backdate them.
+	past := time.Now().Add(-time.Hour)
+	for _, p := range packages {
+		filename := filepath.Join(root, "vendor", p.Archive)
+		err = os.WriteFile(filename, []byte(p.Archive), 0644)
+		assert.Nil(err)
+		err = os.Chtimes(filename, past, past)
+		assert.Nil(err)
+	}
+
+	assert.Equal("", npmmod.CachedVendorHash(root, packages))
+
+	vendorHash, err := npmmod.VendorHash(root, packages)
+	assert.Nil(err)
+	npmmod.CacheVendorHash(root, packages, vendorHash)
+	assert.Equal(vendorHash, npmmod.CachedVendorHash(root, packages))
+
+	// A different set of packages doesn't use the cache.
+	assert.Equal("", npmmod.CachedVendorHash(root, packages[:1]))
+
+	// Changing the modification time invalidates the cache.
+	filename := filepath.Join(root, "vendor", "a-1.0.0.tgz")
+	earlier := past.Add(-time.Hour)
+	err = os.Chtimes(filename, earlier, earlier)
+	assert.Nil(err)
+	assert.Equal("", npmmod.CachedVendorHash(root, packages))
+
+	// So does changing the size (even with the same modification time).
+	npmmod.CacheVendorHash(root, packages, vendorHash)
+	assert.Equal(vendorHash, npmmod.CachedVendorHash(root, packages))
+	err = os.WriteFile(filename, []byte("changed"), 0644)
+	assert.Nil(err)
+	err = os.Chtimes(filename, earlier, earlier)
+	assert.Nil(err)
+	assert.Equal("", npmmod.CachedVendorHash(root, packages))
+
+	// An archive modified just now can't be trusted.
+	npmmod.CacheVendorHash(root, packages, vendorHash)
+	now := time.Now()
+	err = os.Chtimes(filename, now, now)
+	assert.Nil(err)
+	assert.Equal("", npmmod.CachedVendorHash(root, packages))
+}
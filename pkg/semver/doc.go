@@ -0,0 +1,24 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package semver implements parsing and matching of semantic versions and
+// version ranges, compatible with the `node-semver` package used by `npm`.
+//
+// Ranges support everything `npm` accepts in a `package.json`, e.g.
+// comparators (`>=1.2.3 <2.0.0`), caret (`^1.2.3`) and tilde (`~1.2.3`)
+// ranges, x-ranges (`1.x`, `1.2.*`), hyphen ranges (`1.2.3 - 2.3.4`) and
+// unions (`^1.0.0 || ^2.0.0`). As with `node-semver`, a version with a
+// prerelease tag only satisfies a range if some comparator in the range has a
+// prerelease tag for the same `[major, minor, patch]` tuple.
+package semver
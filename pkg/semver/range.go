@@ -0,0 +1,437 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	xIdentifier = `(?:` + numericIdentifier + `|[xX*])`
+	partial     = `[v=]*(` + xIdentifier + `)(?:\.(` + xIdentifier + `)(?:\.(` + xIdentifier + `)` +
+		`(?:-(` + prerelease + `))?(?:\+` + build + `)?)?)?`
+)
+
+var (
+	unionRE    = regexp.MustCompile(`\s*\|\|\s*`)
+	hyphenRE   = regexp.MustCompile(`^\s*(` + partial + `)\s+-\s+(` + partial + `)\s*$`)
+	operatorRE = regexp.MustCompile(`(~>|~|\^|>=|<=|>|<|=)\s+`)
+	partialRE  = regexp.MustCompile(`^` + partial + `$`)
+)
+
+// Range is a `node-semver` range, i.e. a union (`||`) of comparator sets. A
+// version satisfies the range if it satisfies **every** comparator in **any**
+// one of the sets.
+type Range struct {
+	sets [][]comparator
+}
+
+// comparator is a single primitive comparison such as `>=1.2.3`; `Any` is
+// used for `*`, which matches every (non-prerelease) version.
+type comparator struct {
+	Operator string
+	Version  Version
+	Any      bool
+}
+
+// partialVersion is a version where the minor and patch may be missing or
+// wildcards (`x`, `X` or `*`).
+type partialVersion struct {
+	Major      string
+	Minor      string
+	Patch      string
+	Prerelease string
+}
+
+// ParseRange parses a `node-semver` range.
+func ParseRange(r string) (Range, error) {
+	parts := unionRE.Split(strings.TrimSpace(r), -1)
+	sets := make([][]comparator, len(parts))
+	for i, part := range parts {
+		set, err := parseSet(part)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid range %q; %w", r, err)
+		}
+		sets[i] = set
+	}
+
+	return Range{sets: sets}, nil
+}
+
+// Satisfies determines if `version` satisfies the range `r`.
+func Satisfies(version, r string) (bool, error) {
+	v, err := Parse(version)
+	if err != nil {
+		return false, err
+	}
+
+	parsed, err := ParseRange(r)
+	if err != nil {
+		return false, err
+	}
+
+	return parsed.Contains(v), nil
+}
+
+// Contains determines if a version satisfies the range.
+func (r Range) Contains(v Version) bool {
+	for _, set := range r.sets {
+		if setContains(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the range in its desugared form (matching `node-semver`),
+// e.g. `^1.2.3` is formatted as `>=1.2.3 <2.0.0-0`.
+func (r Range) String() string {
+	sets := make([]string, len(r.sets))
+	for i, set := range r.sets {
+		comparators := make([]string, len(set))
+		for j, c := range set {
+			comparators[j] = c.String()
+		}
+		sets[i] = strings.Join(comparators, " ")
+	}
+	return strings.Join(sets, "||")
+}
+
+func (c comparator) String() string {
+	if c.Any {
+		return "*"
+	}
+	if c.Operator == "=" {
+		return c.Version.String()
+	}
+	return c.Operator + c.Version.String()
+}
+
+// unbounded determines if the comparator is `>=0.0.0`, i.e. if it allows every
+// (non-prerelease) version.
+func (c comparator) unbounded() bool {
+	return c.Operator == ">=" && c.Version.Compare(Version{}) == 0
+}
+
+func (c comparator) test(v Version) bool {
+	if c.Any {
+		return true
+	}
+
+	cmp := v.Compare(c.Version)
+	switch c.Operator {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	default:
+		return cmp == 0
+	}
+}
+
+// setContains determines if a version satisfies every comparator in a set. A
+// prerelease version is only allowed if some comparator has a prerelease on
+// the same `[major, minor, patch]` tuple, e.g. `1.2.3-beta.2` satisfies
+// `>1.2.3-beta.1` but `3.4.5-beta.1` does not.
+func setContains(set []comparator, v Version) bool {
+	for _, c := range set {
+		if !c.test(v) {
+			return false
+		}
+	}
+
+	if len(v.Prerelease) == 0 {
+		return true
+	}
+
+	for _, c := range set {
+		if c.Any || len(c.Version.Prerelease) == 0 {
+			continue
+		}
+		if c.Version.sameTuple(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSet parses a single comparator set (i.e. a range without `||`) into
+// primitive comparators.
+func parseSet(s string) ([]comparator, error) {
+	m := hyphenRE.FindStringSubmatch(s)
+	if m != nil {
+		from := partialVersion{Major: m[2], Minor: m[3], Patch: m[4], Prerelease: m[5]}
+		to := partialVersion{Major: m[7], Minor: m[8], Patch: m[9], Prerelease: m[10]}
+		return hyphenRange(from, to)
+	}
+
+	// Remove whitespace between an operator and a version, e.g. `>= 1.2.3`.
+	s = operatorRE.ReplaceAllString(s, "$1")
+	set := []comparator{}
+	for _, field := range strings.Fields(s) {
+		comparators, err := parseComparator(field)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, comparators...)
+	}
+
+	// NOTE: As in `node-semver`, `*` (and the equivalent `>=0.0.0`) is
+	//       dropped from a set that has other comparators since it doesn't
+	//       restrict the set any further.
+	restricted := []comparator{}
+	for _, c := range set {
+		if !c.Any && !c.unbounded() {
+			restricted = append(restricted, c)
+		}
+	}
+	if len(restricted) == 0 {
+		return []comparator{{Any: true}}, nil
+	}
+	return restricted, nil
+}
+
+func parseComparator(s string) ([]comparator, error) {
+	operator := ""
+	for _, candidate := range []string{"~>", "~", "^", ">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(s, candidate) {
+			operator = candidate
+			break
+		}
+	}
+
+	m := partialRE.FindStringSubmatch(strings.TrimPrefix(s, operator))
+	if m == nil {
+		return nil, fmt.Errorf("invalid comparator %q", s)
+	}
+	p := partialVersion{Major: m[1], Minor: m[2], Patch: m[3], Prerelease: m[4]}
+
+	switch operator {
+	case "~", "~>":
+		return tildeRange(p)
+	case "^":
+		return caretRange(p)
+	default:
+		return xRange(operator, p)
+	}
+}
+
+// tildeRange desugars a tilde range, which allows patch-level changes if a
+// minor version is specified and minor-level changes if not, e.g.
+// `~1.2.3` is `>=1.2.3 <1.3.0-0` and `~1` is `>=1.0.0 <2.0.0-0`.
+func tildeRange(p partialVersion) ([]comparator, error) {
+	major, minor, patch, err := p.numbers()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isX(p.Major):
+		return []comparator{{Any: true}}, nil
+	case isX(p.Minor):
+		return between(major, 0, 0, major+1, 0, 0), nil
+	case isX(p.Patch):
+		return between(major, minor, 0, major, minor+1, 0), nil
+	}
+
+	lower := p.version(major, minor, patch)
+	return []comparator{
+		{Operator: ">=", Version: lower},
+		upperBound(major, minor+1, 0),
+	}, nil
+}
+
+// caretRange desugars a caret range, which allows changes that do not modify
+// the left-most non-zero component, e.g. `^1.2.3` is `>=1.2.3 <2.0.0-0` and
+// `^0.2.3` is `>=0.2.3 <0.3.0-0`.
+func caretRange(p partialVersion) ([]comparator, error) {
+	major, minor, patch, err := p.numbers()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isX(p.Major):
+		return []comparator{{Any: true}}, nil
+	case isX(p.Minor):
+		return between(major, 0, 0, major+1, 0, 0), nil
+	case isX(p.Patch):
+		if major == 0 {
+			return between(major, minor, 0, major, minor+1, 0), nil
+		}
+		return between(major, minor, 0, major+1, 0, 0), nil
+	}
+
+	lower := p.version(major, minor, patch)
+	upper := upperBound(major+1, 0, 0)
+	if major == 0 && minor == 0 {
+		upper = upperBound(major, minor, patch+1)
+	} else if major == 0 {
+		upper = upperBound(major, minor+1, 0)
+	}
+	return []comparator{{Operator: ">=", Version: lower}, upper}, nil
+}
+
+// xRange desugars a (possibly partial) version with a primitive operator, e.g.
+// `1.2.x` is `>=1.2.0 <1.3.0-0` and `>1.2` is `>=1.3.0`.
+func xRange(operator string, p partialVersion) ([]comparator, error) {
+	major, minor, patch, err := p.numbers()
+	if err != nil {
+		return nil, err
+	}
+
+	anyX := isX(p.Major) || isX(p.Minor) || isX(p.Patch)
+	if operator == "=" && anyX {
+		operator = ""
+	}
+
+	switch {
+	case isX(p.Major):
+		if operator == "<" || operator == ">" {
+			// Nothing is allowed.
+			return []comparator{upperBound(0, 0, 0)}, nil
+		}
+		return []comparator{{Any: true}}, nil
+	case operator != "" && anyX:
+		if isX(p.Minor) {
+			minor = 0
+		}
+		patch = 0
+
+		switch operator {
+		case ">":
+			// `>1` is `>=2.0.0` and `>1.2` is `>=1.3.0`
+			if isX(p.Minor) {
+				return []comparator{{Operator: ">=", Version: Version{Major: major + 1}}}, nil
+			}
+			return []comparator{{Operator: ">=", Version: Version{Major: major, Minor: minor + 1}}}, nil
+		case "<=":
+			// `<=1` is `<2.0.0-0` and `<=1.2` is `<1.3.0-0`
+			if isX(p.Minor) {
+				return []comparator{upperBound(major+1, 0, 0)}, nil
+			}
+			return []comparator{upperBound(major, minor+1, 0)}, nil
+		case "<":
+			return []comparator{upperBound(major, minor, patch)}, nil
+		}
+		return []comparator{{Operator: operator, Version: Version{Major: major, Minor: minor, Patch: patch}}}, nil
+	case isX(p.Minor):
+		return between(major, 0, 0, major+1, 0, 0), nil
+	case isX(p.Patch):
+		return between(major, minor, 0, major, minor+1, 0), nil
+	}
+
+	v := p.version(major, minor, patch)
+	if operator == "" {
+		operator = "="
+	}
+	return []comparator{{Operator: operator, Version: v}}, nil
+}
+
+// hyphenRange desugars an inclusive hyphen range, e.g. `1.2.3 - 2.3.4` is
+// `>=1.2.3 <=2.3.4` and `1.2 - 2.3` is `>=1.2.0 <2.4.0-0`.
+func hyphenRange(from, to partialVersion) ([]comparator, error) {
+	set := []comparator{}
+
+	major, minor, patch, err := from.numbers()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isX(from.Major):
+		// No lower bound.
+	case isX(from.Minor):
+		set = append(set, comparator{Operator: ">=", Version: Version{Major: major}})
+	case isX(from.Patch):
+		set = append(set, comparator{Operator: ">=", Version: Version{Major: major, Minor: minor}})
+	default:
+		set = append(set, comparator{Operator: ">=", Version: from.version(major, minor, patch)})
+	}
+
+	major, minor, patch, err = to.numbers()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isX(to.Major):
+		// No upper bound.
+	case isX(to.Minor):
+		set = append(set, upperBound(major+1, 0, 0))
+	case isX(to.Patch):
+		set = append(set, upperBound(major, minor+1, 0))
+	default:
+		set = append(set, comparator{Operator: "<=", Version: to.version(major, minor, patch)})
+	}
+
+	if len(set) == 0 {
+		return []comparator{{Any: true}}, nil
+	}
+	return set, nil
+}
+
+// between produces the comparators `>=M.m.p <M'.m'.p'-0`.
+func between(major, minor, patch, upperMajor, upperMinor, upperPatch uint64) []comparator {
+	return []comparator{
+		{Operator: ">=", Version: Version{Major: major, Minor: minor, Patch: patch}},
+		upperBound(upperMajor, upperMinor, upperPatch),
+	}
+}
+
+// upperBound produces an exclusive upper bound `<M.m.p-0` that also excludes
+// all prereleases of `M.m.p`.
+func upperBound(major, minor, patch uint64) comparator {
+	v := Version{Major: major, Minor: minor, Patch: patch, Prerelease: []string{"0"}}
+	return comparator{Operator: "<", Version: v}
+}
+
+// numbers parses the numeric components of a partial version; wildcard or
+// missing components are `0`.
+func (p partialVersion) numbers() (uint64, uint64, uint64, error) {
+	components := []string{p.Major, p.Minor, p.Patch}
+	parsed := make([]uint64, len(components))
+	for i, component := range components {
+		if isX(component) {
+			continue
+		}
+
+		n, err := parseNumeric(component)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		parsed[i] = n
+	}
+
+	return parsed[0], parsed[1], parsed[2], nil
+}
+
+// version produces a full version from the partial version (along with its
+// prerelease).
+func (p partialVersion) version(major, minor, patch uint64) Version {
+	v := Version{Major: major, Minor: minor, Patch: patch}
+	if p.Prerelease != "" {
+		v.Prerelease = strings.Split(p.Prerelease, ".")
+	}
+	return v
+}
+
+func isX(component string) bool {
+	return component == "" || component == "x" || component == "X" || component == "*"
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package semver_test
+
+import (
+	"fmt"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/semver"
+)
+
+func TestRange_Contains(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Range     string
+		Version   string
+		Satisfies bool
+		Desugared string
+	}
+
+	// NOTE: These cases were cross-checked with `node-semver` (e.g.
+	//       `semver.satisfies(version, range)` and `new Range(range).range`).
+	cases := []testCase{
+		{Range: "^18.0.0", Version: "18.2.0", Satisfies: true, Desugared: ">=18.0.0 <19.0.0-0"},
+		{Range: "^18.0.0", Version: "17.0.2", Satisfies: false, Desugared: ">=18.0.0 <19.0.0-0"},
+		{Range: "^18.0.0", Version: "19.0.0-rc.1", Satisfies: false, Desugared: ">=18.0.0 <19.0.0-0"},
+		{Range: "^18.0.0", Version: "18.3.0-beta.1", Satisfies: false, Desugared: ">=18.0.0 <19.0.0-0"},
+		{Range: "^1.2.3-beta.2", Version: "1.2.3-beta.4", Satisfies: true, Desugared: ">=1.2.3-beta.2 <2.0.0-0"},
+		{Range: "^1.2.3-beta.2", Version: "1.2.4-beta.1", Satisfies: false, Desugared: ">=1.2.3-beta.2 <2.0.0-0"},
+		{Range: "^0.2.3", Version: "0.2.5", Satisfies: true, Desugared: ">=0.2.3 <0.3.0-0"},
+		{Range: "^0.2.3", Version: "0.3.0", Satisfies: false, Desugared: ">=0.2.3 <0.3.0-0"},
+		{Range: "^0.0.3", Version: "0.0.3", Satisfies: true, Desugared: ">=0.0.3 <0.0.4-0"},
+		{Range: "^0.0.3", Version: "0.0.4", Satisfies: false, Desugared: ">=0.0.3 <0.0.4-0"},
+		{Range: "^0.0", Version: "0.0.9", Satisfies: true, Desugared: "<0.1.0-0"},
+		{Range: "^0.x", Version: "0.9.1", Satisfies: true, Desugared: "<1.0.0-0"},
+		{Range: "^1.x", Version: "1.9.1", Satisfies: true, Desugared: ">=1.0.0 <2.0.0-0"},
+		{Range: "~1.2.3", Version: "1.2.9", Satisfies: true, Desugared: ">=1.2.3 <1.3.0-0"},
+		{Range: "~1.2.3", Version: "1.3.0", Satisfies: false, Desugared: ">=1.2.3 <1.3.0-0"},
+		{Range: "~1.2", Version: "1.2.0", Satisfies: true, Desugared: ">=1.2.0 <1.3.0-0"},
+		{Range: "~1", Version: "1.9.9", Satisfies: true, Desugared: ">=1.0.0 <2.0.0-0"},
+		{Range: "~>1.2", Version: "1.2.5", Satisfies: true, Desugared: ">=1.2.0 <1.3.0-0"},
+		{Range: "~0.2.3-beta.1", Version: "0.2.3-beta.2", Satisfies: true, Desugared: ">=0.2.3-beta.1 <0.3.0-0"},
+		{Range: "1.x", Version: "1.5.0", Satisfies: true, Desugared: ">=1.0.0 <2.0.0-0"},
+		{Range: "1.x", Version: "2.0.0", Satisfies: false, Desugared: ">=1.0.0 <2.0.0-0"},
+		{Range: "1.2.*", Version: "1.2.7", Satisfies: true, Desugared: ">=1.2.0 <1.3.0-0"},
+		{Range: "*", Version: "1.2.3", Satisfies: true, Desugared: "*"},
+		{Range: "*", Version: "1.2.3-beta", Satisfies: false, Desugared: "*"},
+		{Range: "", Version: "0.0.1", Satisfies: true, Desugared: "*"},
+		{Range: "x", Version: "3.0.0", Satisfies: true, Desugared: "*"},
+		{Range: ">1", Version: "2.0.0", Satisfies: true, Desugared: ">=2.0.0"},
+		{Range: ">1", Version: "1.9.9", Satisfies: false, Desugared: ">=2.0.0"},
+		{Range: ">1.2", Version: "1.3.0", Satisfies: true, Desugared: ">=1.3.0"},
+		{Range: ">1.2", Version: "1.2.9", Satisfies: false, Desugared: ">=1.3.0"},
+		{Range: "<=1", Version: "1.9.9", Satisfies: true, Desugared: "<2.0.0-0"},
+		{Range: "<=1", Version: "2.0.0-beta", Satisfies: false, Desugared: "<2.0.0-0"},
+		{Range: "<=1.2", Version: "1.2.9", Satisfies: true, Desugared: "<1.3.0-0"},
+		{Range: "<1.2", Version: "1.1.9", Satisfies: true, Desugared: "<1.2.0-0"},
+		{Range: "<1.2", Version: "1.2.0-beta", Satisfies: false, Desugared: "<1.2.0-0"},
+		{Range: ">=1.2", Version: "1.2.0", Satisfies: true, Desugared: ">=1.2.0"},
+		{Range: ">*", Version: "1.0.0", Satisfies: false, Desugared: "<0.0.0-0"},
+		{Range: "<*", Version: "1.0.0", Satisfies: false, Desugared: "<0.0.0-0"},
+		{Range: ">= 2.1.2 < 3", Version: "2.5.0", Satisfies: true, Desugared: ">=2.1.2 <3.0.0-0"},
+		{Range: ">= 2.1.2 < 3", Version: "3.0.0", Satisfies: false, Desugared: ">=2.1.2 <3.0.0-0"},
+		{Range: "1.2.3 - 2.3.4", Version: "2.3.4", Satisfies: true, Desugared: ">=1.2.3 <=2.3.4"},
+		{Range: "1.2.3 - 2.3.4", Version: "2.3.5", Satisfies: false, Desugared: ">=1.2.3 <=2.3.4"},
+		{Range: "1.2 - 2.3", Version: "2.3.9", Satisfies: true, Desugared: ">=1.2.0 <2.4.0-0"},
+		{Range: "1.2 - 2.3", Version: "1.2.0", Satisfies: true, Desugared: ">=1.2.0 <2.4.0-0"},
+		{Range: "1.2.3 - 2", Version: "2.9.9", Satisfies: true, Desugared: ">=1.2.3 <3.0.0-0"},
+		{Range: "* - 2", Version: "2.0.0", Satisfies: true, Desugared: "<3.0.0-0"},
+		{Range: "1.2.3", Version: "1.2.3", Satisfies: true, Desugared: "1.2.3"},
+		{Range: "=1.2.3", Version: "1.2.3", Satisfies: true, Desugared: "1.2.3"},
+		{Range: "v1.2.3", Version: "1.2.3", Satisfies: true, Desugared: "1.2.3"},
+		{Range: "1.2.3", Version: "1.2.4", Satisfies: false, Desugared: "1.2.3"},
+		{Range: "1.2.3-beta.1", Version: "1.2.3-beta.1", Satisfies: true, Desugared: "1.2.3-beta.1"},
+		{Range: "<1.2.3-rc.2", Version: "1.2.3-rc.10", Satisfies: false, Desugared: "<1.2.3-rc.2"},
+		{Range: ">1.2.3-alpha.1", Version: "1.2.3-alpha.beta", Satisfies: true, Desugared: ">1.2.3-alpha.1"},
+		{Range: ">1.2.3-alpha.1", Version: "1.2.3-1", Satisfies: false, Desugared: ">1.2.3-alpha.1"},
+		{Range: "=1", Version: "1.4.0", Satisfies: true, Desugared: ">=1.0.0 <2.0.0-0"},
+		{Range: ">=1.2.3 <2.0.0 || >=3.0.0", Version: "3.1.0", Satisfies: true, Desugared: ">=1.2.3 <2.0.0||>=3.0.0"},
+		{Range: ">=1.2.3 <2.0.0 || >=3.0.0", Version: "2.5.0", Satisfies: false, Desugared: ">=1.2.3 <2.0.0||>=3.0.0"},
+		{Range: "^1.0.0 || ^2.0.0", Version: "2.4.0", Satisfies: true, Desugared: ">=1.0.0 <2.0.0-0||>=2.0.0 <3.0.0-0"},
+	}
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		name := fmt.Sprintf("%s satisfies %s", tc.Version, tc.Range)
+		outer.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			r, err := semver.ParseRange(tc.Range)
+			assert.Nil(err)
+			assert.Equal(tc.Desugared, r.String())
+
+			v, err := semver.Parse(tc.Version)
+			assert.Nil(err)
+			assert.Equal(tc.Satisfies, r.Contains(v))
+		})
+	}
+}
+
+func TestParseRange_Invalid(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Range string
+		Error string
+	}
+
+	cases := []testCase{
+		{Range: "latest", Error: `invalid range "latest"; invalid comparator "latest"`},
+		{Range: "^1.2.3.4", Error: `invalid range "^1.2.3.4"; invalid comparator "^1.2.3.4"`},
+		{Range: "github:user/repo", Error: `invalid range "github:user/repo"; invalid comparator "github:user/repo"`},
+		{Range: ">=01.2.3", Error: `invalid range ">=01.2.3"; invalid comparator ">=01.2.3"`},
+	}
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Range, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			_, err := semver.ParseRange(tc.Range)
+			assert.NotNil(err)
+			assert.Equal(tc.Error, fmt.Sprintf("%v", err))
+		})
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	ok, err := semver.Satisfies("18.2.0", "^18.0.0")
+	assert.Nil(err)
+	assert.True(ok)
+
+	ok, err = semver.Satisfies("17.0.2", "^18.0.0")
+	assert.Nil(err)
+	assert.False(ok)
+
+	_, err = semver.Satisfies("17.0", "^18.0.0")
+	assert.NotNil(err)
+	assert.Equal(`invalid version; "17.0"`, fmt.Sprintf("%v", err))
+}
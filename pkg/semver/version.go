@@ -0,0 +1,168 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	numericIdentifier    = `0|[1-9]\d*`
+	prereleaseIdentifier = `(?:0|[1-9]\d*|\d*[a-zA-Z-][a-zA-Z0-9-]*)`
+	prerelease           = prereleaseIdentifier + `(?:\.` + prereleaseIdentifier + `)*`
+	build                = `[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*`
+)
+
+var (
+	versionRE = regexp.MustCompile(`^[v=]*(` + numericIdentifier + `)\.(` + numericIdentifier + `)\.(` + numericIdentifier + `)` +
+		`(?:-(` + prerelease + `))?(?:\+(` + build + `))?$`)
+)
+
+// Version is a semantic version, e.g. `1.2.3-beta.1+build.5`.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      []string
+}
+
+// Parse parses a semantic version. As with `node-semver`, a leading `v` or `=`
+// is allowed.
+func Parse(version string) (Version, error) {
+	m := versionRE.FindStringSubmatch(strings.TrimSpace(version))
+	if m == nil {
+		return Version{}, fmt.Errorf("invalid version; %q", version)
+	}
+
+	v := Version{}
+	var err error
+	v.Major, err = parseNumeric(m[1])
+	if err != nil {
+		return Version{}, err
+	}
+	v.Minor, err = parseNumeric(m[2])
+	if err != nil {
+		return Version{}, err
+	}
+	v.Patch, err = parseNumeric(m[3])
+	if err != nil {
+		return Version{}, err
+	}
+	if m[4] != "" {
+		v.Prerelease = strings.Split(m[4], ".")
+	}
+	if m[5] != "" {
+		v.Build = strings.Split(m[5], ".")
+	}
+
+	return v, nil
+}
+
+// String formats the version; as with `node-semver` build metadata is not
+// included.
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	return s
+}
+
+// Compare returns `-1`, `0` or `1` if `v` is less than, equal to or greater
+// than `other`. Build metadata is ignored.
+func (v Version) Compare(other Version) int {
+	c := compareNumeric(v.Major, other.Major)
+	if c != 0 {
+		return c
+	}
+	c = compareNumeric(v.Minor, other.Minor)
+	if c != 0 {
+		return c
+	}
+	c = compareNumeric(v.Patch, other.Patch)
+	if c != 0 {
+		return c
+	}
+
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+// sameTuple determines if two versions have the same `[major, minor, patch]`.
+func (v Version) sameTuple(other Version) bool {
+	return v.Major == other.Major && v.Minor == other.Minor && v.Patch == other.Patch
+}
+
+func parseNumeric(s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid version component; %q", s)
+	}
+	return n, nil
+}
+
+func compareNumeric(a, b uint64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// comparePrerelease compares prerelease identifiers; a version without a
+// prerelease has a **higher** precedence than one with a prerelease.
+func comparePrerelease(a, b []string) int {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	if len(a) == 0 {
+		return 1
+	}
+	if len(b) == 0 {
+		return -1
+	}
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		c := compareIdentifier(a[i], b[i])
+		if c != 0 {
+			return c
+		}
+	}
+
+	return compareNumeric(uint64(len(a)), uint64(len(b)))
+}
+
+// compareIdentifier compares prerelease identifiers; numeric identifiers are
+// compared numerically and always have lower precedence than alphanumeric
+// identifiers.
+func compareIdentifier(a, b string) int {
+	aN, aErr := strconv.ParseUint(a, 10, 64)
+	bN, bErr := strconv.ParseUint(b, 10, 64)
+	if aErr == nil && bErr == nil {
+		return compareNumeric(aN, bN)
+	}
+	if aErr == nil {
+		return -1
+	}
+	if bErr == nil {
+		return 1
+	}
+	return strings.Compare(a, b)
+}
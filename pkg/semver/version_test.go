@@ -0,0 +1,111 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package semver_test
+
+import (
+	"fmt"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/semver"
+)
+
+func TestParse(outer *testing.T) {
+	outer.Parallel()
+
+	type testCase struct {
+		Input   string
+		Version semver.Version
+		String  string
+		Error   string
+	}
+
+	cases := []testCase{
+		{Input: "1.2.3", Version: semver.Version{Major: 1, Minor: 2, Patch: 3}, String: "1.2.3"},
+		{Input: "v1.2.3", Version: semver.Version{Major: 1, Minor: 2, Patch: 3}, String: "1.2.3"},
+		{Input: " =1.2.3 ", Version: semver.Version{Major: 1, Minor: 2, Patch: 3}, String: "1.2.3"},
+		{
+			Input:   "1.2.3-beta.1+build.5",
+			Version: semver.Version{Major: 1, Minor: 2, Patch: 3, Prerelease: []string{"beta", "1"}, Build: []string{"build", "5"}},
+			String:  "1.2.3-beta.1",
+		},
+		{Input: "1.2", Error: `invalid version; "1.2"`},
+		{Input: "1.2.3.4", Error: `invalid version; "1.2.3.4"`},
+		{Input: "01.2.3", Error: `invalid version; "01.2.3"`},
+		{Input: "1.2.3-01", Error: `invalid version; "1.2.3-01"`},
+		{Input: "file:vendor/react-18.0.0.tgz", Error: `invalid version; "file:vendor/react-18.0.0.tgz"`},
+	}
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Input, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			v, err := semver.Parse(tc.Input)
+			assert.Equal(tc.Version, v)
+			if tc.Error == "" {
+				assert.Nil(err)
+				assert.Equal(tc.String, v.String())
+			} else {
+				assert.NotNil(err)
+				assert.Equal(tc.Error, fmt.Sprintf("%v", err))
+			}
+		})
+	}
+}
+
+func TestVersion_Compare(t *testing.T) {
+	t.Parallel()
+	assert := testifyassert.New(t)
+
+	// Sorted in increasing order of precedence, per https://semver.org/#spec-item-11
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+		"10.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, err := semver.Parse(ordered[i])
+			assert.Nil(err)
+			b, err := semver.Parse(ordered[j])
+			assert.Nil(err)
+
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			assert.Equal(expected, a.Compare(b), "%s <=> %s", ordered[i], ordered[j])
+		}
+	}
+
+	a, err := semver.Parse("1.0.0+build.1")
+	assert.Nil(err)
+	b, err := semver.Parse("1.0.0+build.2")
+	assert.Nil(err)
+	assert.Equal(0, a.Compare(b))
+}
@@ -16,13 +16,38 @@ package tidycmd
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/hardfinhq/npm-mod/pkg/npmmod"
 )
 
+// Options configures the `npm-mod tidy` command.
+type Options struct {
+	// DryRun determines if changes should only be printed (as a unified
+	// diff) instead of written to disk.
+	DryRun bool
+	// Strict determines if tidy should fail when any dependency would still
+	// require a fetch from the registry.
+	Strict bool
+	// KeepVersions determines if the real `version` of each vendored package
+	// is kept in the `package-lock.json`. If `nil`, the setting from the
+	// previous tidy (if any) is used.
+	KeepVersions *bool
+	// Mode is the tidy mode, i.e. `vendor`, `registry` or `pin`. If empty,
+	// the mode from the previous tidy (if any) is used.
+	Mode string
+	// MirrorURL is the registry base URL that `resolved` URLs are rewritten
+	// to in `registry` mode. If empty, the mirror from the previous tidy (if
+	// any) is used.
+	MirrorURL string
+}
+
 // Run executes the `npm-mod tidy` command.
-func Run(_ context.Context) error {
+func Run(_ context.Context, opts Options) error {
 	here, err := os.Getwd()
 	if err != nil {
 		return err
@@ -33,20 +58,220 @@ func Run(_ context.Context) error {
 		return err
 	}
 
+	// NOTE: A dry run doesn't write anything, so it doesn't need to hold
+	//       the lock either.
+	if opts.DryRun {
+		return tidy(root, opts)
+	}
+
+	release, err := npmmod.Lock(root)
+	if err != nil {
+		return err
+	}
+
+	err = tidy(root, opts)
+	releaseErr := release()
+	if err != nil {
+		return err
+	}
+	return releaseErr
+}
+
+// tidy stages the `package.json`, `package-lock.json`, `.npm-mod.tidy.json`
+// and `npm.sum` writes and then commits them together (or prints them in a
+// dry run).
+func tidy(root string, opts Options) error {
 	tf, err := npmmod.GenerateTidyFile(root)
 	if err != nil {
 		return err
 	}
+	if opts.KeepVersions != nil {
+		tf.KeepVersions = *opts.KeepVersions
+	}
+	err = setMode(tf, opts)
+	if err != nil {
+		return err
+	}
+
+	txn := npmmod.NewTransaction()
+	err = tf.TidyPackageJSON(txn)
+	if err != nil {
+		return err
+	}
+
+	err = tf.TidyPackageLockJSON(txn)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: The patch in `.npm-mod.tidy.json` is only known once the
+	//       `package.json` and `package-lock.json` have been tidied.
+	err = tf.Persist(txn)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Outside of vendor mode there are no package archives for
+	//       `npm.sum` to describe, so a stale one is removed instead.
+	removedSum := false
+	if tf.VendorMode() {
+		err = tf.PersistSum(txn)
+	} else {
+		removedSum, err = npmmod.RemoveStaleSum(txn, root)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.Strict && len(tf.Unmatched) > 0 {
+		return unmatchedError(tf.Unmatched)
+	}
+	printUnmatched(tf)
+
+	err = validate(root, txn)
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		return dryRun(tf, txn)
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+	if removedSum {
+		fmt.Printf("Removed npm.sum; it is not used in %s mode\n", tf.Mode)
+	}
+	return nil
+}
+
+// setMode sets the tidy mode and mirror from `opts`, falling back to the ones
+// from the previous tidy.
+func setMode(tf *npmmod.TidyFile, opts Options) error {
+	mode := opts.Mode
+	if mode == "" {
+		mode = tf.Mode
+		if mode == "" {
+			mode = npmmod.ModeVendor
+		}
+	}
+
+	mirror := opts.MirrorURL
+	if mirror == "" && mode == npmmod.ModeRegistry {
+		mirror = tf.Mirror
+	}
+
+	return tf.SetMode(mode, mirror)
+}
 
-	err = tf.Persist()
+// validate checks that the staged `package-lock.json` agrees with the staged
+// `package.json`, i.e. that `npm ci` will install exactly the vendored
+// packages described by the `package-lock.json`.
+func validate(root string, txn *npmmod.Transaction) error {
+	packageJSON, err := txn.ReadFile(filepath.Join(root, "package.json"))
 	if err != nil {
 		return err
 	}
 
-	err = tf.TidyPackageJSON()
+	packageLock, err := txn.ReadFile(filepath.Join(root, "package-lock.json"))
 	if err != nil {
 		return err
 	}
 
-	return tf.TidyPackageLockJSON()
+	disagreements, err := npmmod.ValidatePackageLockJSON(packageJSON, packageLock)
+	if err != nil {
+		return err
+	}
+	if len(disagreements) == 0 {
+		return nil
+	}
+
+	lines := make([]string, len(disagreements))
+	for i, d := range disagreements {
+		lines[i] = fmt.Sprintf("- %s", d)
+	}
+	return fmt.Errorf("tidied package-lock.json does not agree with package.json; npm ci would not use it as-is:\n%s", strings.Join(lines, "\n"))
+}
+
+// dryRun prints a unified diff of every file that would be written along with
+// a summary of the package archives that would be vendored, left alone (i.e.
+// already vendored) or no longer referenced.
+func dryRun(tf *npmmod.TidyFile, txn *npmmod.Transaction) error {
+	diff, err := txn.Diff(tf.Root)
+	if err != nil {
+		return err
+	}
+
+	vendored, err := npmmod.ReadVendoredFilenames(tf.Root)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(diff)
+	if !tf.VendorMode() {
+		_, err = os.Stat(filepath.Join(tf.Root, "npm.sum"))
+		if err == nil {
+			fmt.Println("Would remove npm.sum")
+			return npmmod.ErrChangesPending
+		}
+		return pendingChanges(diff)
+	}
+
+	for _, p := range tf.Packages {
+		filename, err := p.ArchiveFilename()
+		if err != nil {
+			return err
+		}
+
+		if vendored[filename] {
+			fmt.Printf("Unchanged %s\n", filename)
+			delete(vendored, filename)
+			continue
+		}
+		fmt.Printf("Would vendor %s\n", filename)
+	}
+
+	removed := []string{}
+	for filename := range vendored {
+		removed = append(removed, filename)
+	}
+	sort.Strings(removed)
+	for _, filename := range removed {
+		fmt.Printf("Would no longer reference %s\n", filename)
+	}
+
+	return pendingChanges(diff)
+}
+
+func pendingChanges(diff string) error {
+	if diff != "" {
+		return npmmod.ErrChangesPending
+	}
+	return nil
+}
+
+// printUnmatched reports every dependency specifier that was left pointing at
+// the registry.
+func printUnmatched(tf *npmmod.TidyFile) {
+	verb := "vendored"
+	if tf.RegistryMode() {
+		verb = "mirrored"
+	}
+	if tf.PinMode() {
+		verb = "pinned"
+	}
+	for _, u := range tf.Unmatched {
+		fmt.Printf("Not %s %s\n", verb, u)
+	}
+}
+
+func unmatchedError(unmatched []npmmod.Unmatched) error {
+	lines := make([]string, len(unmatched))
+	for i, u := range unmatched {
+		lines[i] = fmt.Sprintf("- %s", u)
+	}
+
+	return fmt.Errorf("%d dependencies would still require a fetch from the registry:\n%s", len(unmatched), strings.Join(lines, "\n"))
 }
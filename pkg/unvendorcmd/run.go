@@ -16,13 +16,21 @@ package unvendorcmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 
 	"github.com/hardfinhq/npm-mod/pkg/npmmod"
 )
 
+// Options configures the `npm-mod unvendor` command.
+type Options struct {
+	// DryRun determines if changes should only be printed (as a unified
+	// diff) instead of written to disk.
+	DryRun bool
+}
+
 // Run executes the `npm-mod unvendor` command.
-func Run(_ context.Context) error {
+func Run(_ context.Context, opts Options) error {
 	here, err := os.Getwd()
 	if err != nil {
 		return err
@@ -33,10 +41,77 @@ func Run(_ context.Context) error {
 		return err
 	}
 
+	// NOTE: A dry run doesn't write anything, so it doesn't need to hold
+	//       the lock either.
+	if opts.DryRun {
+		return unvendor(root, opts)
+	}
+
+	release, err := npmmod.Lock(root)
+	if err != nil {
+		return err
+	}
+
+	err = unvendor(root, opts)
+	releaseErr := release()
+	if err != nil {
+		return err
+	}
+	return releaseErr
+}
+
+func unvendor(root string, opts Options) error {
 	tf, err := npmmod.ReadTidyFile(root)
 	if err != nil {
 		return err
 	}
 
-	return tf.Restore()
+	txn := npmmod.NewTransaction()
+	err = tf.Restore(txn)
+	if err != nil {
+		return err
+	}
+	for _, name := range tf.Drifted {
+		fmt.Printf("Kept changes to %s made since the last tidy\n", name)
+	}
+	for _, name := range tf.Unverified {
+		fmt.Printf("Kept any changes to %s made since the last tidy (.npm-mod.tidy.json has no checksum to detect them)\n", name)
+	}
+
+	if opts.DryRun {
+		return dryRun(tf, txn)
+	}
+
+	return txn.Commit()
+}
+
+// dryRun prints a unified diff of every file that would be written along with
+// a summary of the package archives that would no longer be vendored.
+func dryRun(tf *npmmod.TidyFile, txn *npmmod.Transaction) error {
+	diff, err := txn.Diff(tf.Root)
+	if err != nil {
+		return err
+	}
+
+	vendored, err := npmmod.ReadVendoredFilenames(tf.Root)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(diff)
+	for _, p := range tf.Packages {
+		filename, err := p.ArchiveFilename()
+		if err != nil {
+			return err
+		}
+
+		if vendored[filename] {
+			fmt.Printf("Would unvendor %s\n", filename)
+		}
+	}
+
+	if diff != "" {
+		return npmmod.ErrChangesPending
+	}
+	return nil
 }
@@ -19,88 +19,135 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
+	"time"
 
 	"github.com/hardfinhq/npm-mod/pkg/concurrency"
 	"github.com/hardfinhq/npm-mod/pkg/npmmod"
 )
 
-var (
-	poolSize = runtime.NumCPU()
-)
-
 // fetchPackageArchives runs `fetchPackageArchive.Do()` for every (deduplicated)
-// registry package.
-func fetchPackageArchives(ctx context.Context, tf *npmmod.TidyFile) error {
+// registry package, with at most `jobs` running at once. The digest of every
+// package archive is returned so that `vendor/` doesn't need to be read again
+// to compute its hash.
+func fetchPackageArchives(ctx context.Context, tf *npmmod.TidyFile, fetcher *npmmod.Fetcher, jobs int) ([]npmmod.ArchiveDigest, error) {
 	// Ensure vendor directory exists.
 	targetDir := filepath.Join(tf.Root, "vendor")
 	err := os.MkdirAll(targetDir, os.ModePerm)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Fan out check file / download tasks to a worker pool.
-	tasks := make([]*concurrency.Task, len(tf.Packages))
-	for i, rp := range tf.Packages {
-		fpa := fetchPackageArchive{
-			Context:         ctx,
-			RegistryPackage: rp,
-			Target:          targetDir,
+	// NOTE: Stop at the first failure; there's no point in downloading the
+	//       rest of the package archives if `vendor` will fail anyway.
+	start := time.Now()
+	fpa := fetchPackageArchive{Fetcher: fetcher, Root: tf.Root}
+	pool := concurrency.NewResultPool(tf.Packages, jobs, fpa.Do)
+	pool.Policy = concurrency.FailFast
+
+	s := fetchSummary{}
+	digests := []npmmod.ArchiveDigest{}
+	for r := range pool.Stream(ctx) {
+		if r.Error != nil {
+			continue
+		}
+
+		s.add(r.Value)
+		digests = append(digests, r.Value.Digest)
+		if r.Value.Cached {
+			fmt.Printf("Validated %s\n", r.Value.Filename)
+		} else if r.Value.ServedBy != "" {
+			fmt.Printf("Saved %s from %s\n", r.Value.Filename, r.Value.ServedBy)
+		} else {
+			fmt.Printf("Saved %s\n", r.Value.Filename)
 		}
-		tasks[i] = concurrency.NewTask(fpa.Do)
 	}
 
-	pool := concurrency.NewPool(tasks, poolSize)
-	return pool.Run()
+	err = pool.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf(
+		"Saved %d package archives (%d bytes) in %s; validated %d already in vendor/\n",
+		s.Saved, s.SavedBytes, time.Since(start).Round(time.Millisecond), s.Cached,
+	)
+	return digests, nil
+}
+
+// fetchResult is the outcome of `fetchPackageArchive.Do()` for a single
+// package archive.
+type fetchResult struct {
+	Filename string
+	Bytes    int64
+	// Cached is set if the package archive was already in `vendor/`.
+	Cached bool
+	Digest npmmod.ArchiveDigest
+	// ServedBy is the URL the package archive was downloaded from, if it
+	// isn't the URL in `.npm-mod.tidy.json` (i.e. after a rewrite or from a
+	// mirror).
+	ServedBy string
+}
+
+// fetchSummary tallies the outcomes of `fetchPackageArchive.Do()`.
+type fetchSummary struct {
+	Saved      int
+	SavedBytes int64
+	Cached     int
+}
+
+func (s *fetchSummary) add(fr fetchResult) {
+	if fr.Cached {
+		s.Cached++
+		return
+	}
+
+	s.Saved++
+	s.SavedBytes += fr.Bytes
 }
 
 type fetchPackageArchive struct {
-	Context         context.Context
-	RegistryPackage npmmod.RegistryPackage
-	Target          string
+	Fetcher *npmmod.Fetcher
+	Root    string
 }
 
 // Do either
 // - validates the checksum if the package archive file already exists
 // - downloads (and validates) the package archive file
-func (fpa *fetchPackageArchive) Do(_ int) error {
-	filename, err := fpa.RegistryPackage.Filename()
+//
+// and then determines the digest of the package archive.
+func (fpa *fetchPackageArchive) Do(ctx context.Context, p npmmod.Package) (fetchResult, error) {
+	rp := p.RegistryPackage
+	filename, err := p.ArchiveFilename()
 	if err != nil {
-		return err
+		return fetchResult{}, err
 	}
 
-	archiveFilename := filepath.Join(fpa.Target, filename)
-	data, err := os.ReadFile(archiveFilename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return fpa.loggedFetch()
-		}
-
-		return err
+	fr := fetchResult{Filename: filename, Cached: true}
+	archiveFilename := filepath.Join(fpa.Root, "vendor", filename)
+	fr.Digest, err = npmmod.HashArchive(fpa.Root, p)
+	if err == nil {
+		err = fr.Digest.Validate()
 	}
-
-	err = npmmod.ValidateIntegrity(data, fpa.RegistryPackage.Algorithm, fpa.RegistryPackage.Hash)
-	if err != nil {
-		return err
+	if err != nil && os.IsNotExist(err) {
+		fr.Cached = false
+		fr.ServedBy, err = fpa.Fetcher.Fetch(ctx, rp.URL, rp.Algorithm, rp.Hash, archiveFilename)
+		if fr.ServedBy == rp.URL {
+			fr.ServedBy = ""
+		}
+		if err == nil {
+			fr.Digest, err = npmmod.HashArchive(fpa.Root, p)
+		}
 	}
-
-	fmt.Printf("Validated %s\n", filename)
-	return nil
-}
-
-func (fpa *fetchPackageArchive) loggedFetch() error {
-	rp := fpa.RegistryPackage
-	filename, err := rp.Filename()
 	if err != nil {
-		return err
+		return fetchResult{}, err
 	}
 
-	downloadFilename := filepath.Join(fpa.Target, filename)
-	err = npmmod.Fetch(fpa.Context, rp.URL, rp.Algorithm, rp.Hash, downloadFilename)
+	fi, err := os.Stat(archiveFilename)
 	if err != nil {
-		return err
+		return fetchResult{}, err
 	}
 
-	fmt.Printf("Saved %s\n", filename)
-	return nil
+	fr.Bytes = fi.Size()
+	return fr, nil
 }
@@ -0,0 +1,61 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vendorcmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+// prune removes the package archives in `vendor/` that are not tracked in
+// `.npm-mod.tidy.json` (for a dry run, it only lists them).
+func prune(tf *npmmod.TidyFile, dryRun bool) error {
+	orphans, err := npmmod.FindOrphans(tf.Root, tf.Packages)
+	if err != nil {
+		return err
+	}
+
+	if len(orphans) == 0 {
+		fmt.Println("No package archives to prune in vendor/")
+		return nil
+	}
+
+	verb, summary := "Pruned", "Reclaimed"
+	if dryRun {
+		verb, summary = "Would prune", "Would reclaim"
+	}
+
+	total := int64(0)
+	for _, o := range orphans {
+		if !dryRun {
+			err = os.Remove(filepath.Join(tf.Root, "vendor", o.Filename))
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("%s vendor/%s (%d bytes)\n", verb, o.Filename, o.Size)
+		total += o.Size
+	}
+	fmt.Printf("%s %d bytes from %d package archives\n", summary, total, len(orphans))
+
+	if dryRun {
+		return npmmod.ErrChangesPending
+	}
+	return nil
+}
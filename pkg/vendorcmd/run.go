@@ -16,13 +16,84 @@ package vendorcmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 
+	"github.com/hardfinhq/npm-mod/pkg/concurrency"
 	"github.com/hardfinhq/npm-mod/pkg/npmmod"
 )
 
+const (
+	defaultJobsPerHost = 8
+)
+
+// Options configures the `npm-mod vendor` command.
+type Options struct {
+	// Prune determines if package archives in `vendor/` that are not tracked
+	// in `.npm-mod.tidy.json` should be removed.
+	Prune bool
+	// DryRun determines if the package archives that would be pruned should
+	// only be listed (without fetching or removing anything).
+	DryRun bool
+	// Retries is the number of times a transient download failure is
+	// retried (with exponential backoff).
+	Retries int
+	// Jobs is the number of package archives that are fetched concurrently.
+	Jobs int
+	// JobsPerHost is the number of concurrent requests to the same host.
+	JobsPerHost int
+	// RequestsPerSecond limits the rate of requests (across all hosts); zero
+	// means unlimited.
+	RequestsPerSecond float64
+	// BytesPerSecond limits the download rate (across all hosts); zero means
+	// unlimited.
+	BytesPerSecond float64
+	// Rewrites are `FROM => TO` rules applied to package archive URLs at
+	// download time.
+	Rewrites []string
+	// Mirrors are `FROM => TO` rules for mirrors that are tried in order if
+	// a download fails.
+	Mirrors []string
+}
+
+// DefaultOptions returns the default options for the `npm-mod vendor`
+// command.
+func DefaultOptions() Options {
+	return Options{
+		Retries:     npmmod.DefaultRetryPolicy().Attempts - 1,
+		Jobs:        runtime.NumCPU(),
+		JobsPerHost: defaultJobsPerHost,
+	}
+}
+
 // Run executes the `npm-mod vendor` command.
-func Run(ctx context.Context) error {
+func Run(ctx context.Context, opts Options) error {
+	if opts.DryRun && !opts.Prune {
+		return errors.New("--dry-run is only supported with --prune")
+	}
+	if opts.Jobs < 1 {
+		return fmt.Errorf("--jobs must be positive; %d", opts.Jobs)
+	}
+	if opts.JobsPerHost < 1 {
+		return fmt.Errorf("--jobs-per-host must be positive; %d", opts.JobsPerHost)
+	}
+	if opts.RequestsPerSecond < 0 {
+		return fmt.Errorf("--requests-per-second must not be negative; %v", opts.RequestsPerSecond)
+	}
+	if opts.BytesPerSecond < 0 {
+		return fmt.Errorf("--bytes-per-second must not be negative; %v", opts.BytesPerSecond)
+	}
+
+	// NOTE: An interrupt cancels the context, so in-flight downloads are
+	//       abandoned (and their temporary files removed) and the lock is
+	//       released before exiting.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	here, err := os.Getwd()
 	if err != nil {
 		return err
@@ -33,10 +104,186 @@ func Run(ctx context.Context) error {
 		return err
 	}
 
+	// NOTE: A dry run doesn't write anything, so it doesn't need to hold
+	//       the lock either.
+	if opts.DryRun {
+		return dryRun(root)
+	}
+
+	release, err := npmmod.Lock(root)
+	if err != nil {
+		return err
+	}
+
+	err = vendor(ctx, root, opts)
+	releaseErr := release()
+	if err != nil {
+		return err
+	}
+	return releaseErr
+}
+
+func vendor(ctx context.Context, root string, opts Options) error {
+	tf, err := npmmod.ReadTidyFile(root)
+	if err != nil {
+		return err
+	}
+	if tf.RegistryMode() {
+		return fmt.Errorf("there is nothing to vendor; .npm-mod.tidy.json is in %s mode (packages are installed from %s)", npmmod.ModeRegistry, tf.Mirror)
+	}
+	if tf.PinMode() {
+		return fmt.Errorf("there is nothing to vendor; .npm-mod.tidy.json is in %s mode (packages are installed from the registry)", npmmod.ModePin)
+	}
+
+	lines, err := npmmod.ReadSumFile(root)
+	if err != nil {
+		return err
+	}
+
+	err = tf.CheckSum(lines)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: With `--prune`, `vendor/` is made to agree with
+	//       `.npm-mod.tidy.json` regardless of the existing manifest.
+	if !opts.Prune {
+		err = npmmod.CheckVendorManifest(root)
+		if err != nil {
+			return err
+		}
+	}
+
+	fetcher, err := newFetcher(root, opts)
+	if err != nil {
+		return err
+	}
+
+	digests, err := fetchPackageArchives(ctx, tf, fetcher, opts.Jobs)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("vendor was interrupted; %w", ctx.Err())
+	}
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Prune only after every package archive has been fetched, so a
+	//       failed fetch leaves `vendor/` as it was.
+	if opts.Prune {
+		err = prune(tf, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return finish(tf, digests)
+}
+
+// newFetcher creates a fetcher with the retry policy and limits from `opts`
+// and the credentials, TLS and proxy settings from `.npmrc`.
+func newFetcher(root string, opts Options) (*npmmod.Fetcher, error) {
+	rc, err := npmmod.ReadNPMRC(root)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher := npmmod.NewFetcher()
+	fetcher.Client, err = rc.Client()
+	if err != nil {
+		return nil, err
+	}
+	fetcher.Retry.Attempts = opts.Retries + 1
+	fetcher.Hosts = concurrency.NewKeyedLimiter(opts.JobsPerHost)
+	fetcher.Rewrites, err = parseRewrites(opts.Rewrites)
+	if err != nil {
+		return nil, err
+	}
+	fetcher.Mirrors, err = parseRewrites(opts.Mirrors)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.RequestsPerSecond > 0 {
+		fetcher.Requests, err = concurrency.NewTokenBucket(opts.RequestsPerSecond, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.BytesPerSecond > 0 {
+		fetcher.Bytes, err = concurrency.NewTokenBucket(opts.BytesPerSecond, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fetcher, nil
+}
+
+func parseRewrites(rules []string) ([]npmmod.Rewrite, error) {
+	rewrites := []npmmod.Rewrite{}
+	for _, rule := range rules {
+		r, err := npmmod.ParseRewrite(rule)
+		if err != nil {
+			return nil, err
+		}
+		rewrites = append(rewrites, r)
+	}
+	return rewrites, nil
+}
+
+// dryRun lists the package archives that `vendor --prune` would remove.
+func dryRun(root string) error {
 	tf, err := npmmod.ReadTidyFile(root)
 	if err != nil {
 		return err
 	}
 
-	return fetchPackageArchives(ctx, tf)
+	return prune(tf, true)
+}
+
+// finish writes `vendor/npm-mod.txt` for the package archives in `vendor/`
+// (failing if `vendor/` has any archives the manifest doesn't describe) and
+// records the `vendor/` hash (computed from the `digests` of the fetched
+// package archives) in `.npm-mod.tidy.json`.
+func finish(tf *npmmod.TidyFile, digests []npmmod.ArchiveDigest) error {
+	pl, err := npmmod.ReadPackageLock(tf.Root)
+	if err != nil {
+		return err
+	}
+
+	entries, err := npmmod.NewManifest(tf, pl)
+	if err != nil {
+		return err
+	}
+
+	err = npmmod.CheckVendorDir(tf.Root, entries)
+	if err != nil {
+		return err
+	}
+
+	txn := npmmod.NewTransaction()
+	err = npmmod.PersistManifest(txn, tf.Root, entries)
+	if err != nil {
+		return err
+	}
+
+	vendorHash := npmmod.VendorHashFromDigests(digests)
+
+	// NOTE: A `.npm-mod.tidy.json` with an older version is left as-is
+	//       rather than being migrated as a side effect of `vendor`.
+	if vendorHash != tf.VendorHash && tf.MigratedFrom == "" {
+		tf.VendorHash = vendorHash
+		err = tf.Persist(txn)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+
+	npmmod.CacheVendorHash(tf.Root, tf.Packages, vendorHash)
+	return nil
 }
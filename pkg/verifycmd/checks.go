@@ -0,0 +1,297 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verifycmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hardfinhq/npm-mod/pkg/concurrency"
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+	"github.com/hardfinhq/npm-mod/pkg/ordered"
+)
+
+// hashArchives hashes every package archive in `.npm-mod.tidy.json` (once
+// each) to check that it exists in `vendor/` and matches its file integrity
+// and to compute the `vendor/` hash. With `quick`, nothing is read if the
+// cached `vendor/` hash (see `npmmod.CachedVendorHash()`) matches
+// `.npm-mod.tidy.json`.
+func hashArchives(ctx context.Context, tf *npmmod.TidyFile, r *Report, quick bool) error {
+	if quick && tf.VendorHash != "" && npmmod.CachedVendorHash(tf.Root, tf.Packages) == tf.VendorHash {
+		r.Quick = true
+		r.VendorHash = tf.VendorHash
+		return nil
+	}
+
+	pool := concurrency.NewResultPool(tf.Packages, poolSize, func(_ context.Context, p npmmod.Package) (archiveResult, error) {
+		return hashArchive(tf.Root, p), nil
+	})
+	results, err := pool.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	digests := []npmmod.ArchiveDigest{}
+	for i, result := range results {
+		if result.Value.Problem != "" {
+			r.add("archive", "vendor/"+tf.Packages[i].Archive, result.Value.Problem)
+			continue
+		}
+		digests = append(digests, result.Value.Digest)
+	}
+	// NOTE: The `vendor/` hash can't be computed if any archive is missing
+	//       (or can't be read).
+	if len(digests) != len(tf.Packages) {
+		return nil
+	}
+
+	r.VendorHash = npmmod.VendorHashFromDigests(digests)
+	if tf.VendorHash != "" && r.VendorHash != tf.VendorHash {
+		message := fmt.Sprintf("records vendor/ hash %s but vendor/ has %s; run npm-mod vendor", tf.VendorHash, r.VendorHash)
+		r.add("vendor-hash", ".npm-mod.tidy.json", message)
+		return nil
+	}
+	if r.VendorHash == tf.VendorHash && len(r.Problems) == 0 {
+		npmmod.CacheVendorHash(tf.Root, tf.Packages, r.VendorHash)
+	}
+	return nil
+}
+
+// archiveResult is the digest of a vendored package archive, or the problem
+// with it.
+type archiveResult struct {
+	Digest  npmmod.ArchiveDigest
+	Problem string
+}
+
+// hashArchive hashes a vendored package archive and checks its file
+// integrity.
+func hashArchive(root string, p npmmod.Package) archiveResult {
+	ad, err := npmmod.HashArchive(root, p)
+	if err != nil && os.IsNotExist(err) {
+		return archiveResult{Problem: "does not exist"}
+	}
+	if err == nil {
+		err = ad.Validate()
+	}
+	if err != nil {
+		return archiveResult{Problem: err.Error()}
+	}
+
+	return archiveResult{Digest: ad}
+}
+
+// checkOrphans checks that `vendor/` only has the package archives in
+// `.npm-mod.tidy.json` (and `vendor/npm-mod.txt`).
+func checkOrphans(_ context.Context, tf *npmmod.TidyFile, r *Report) error {
+	entries, err := os.ReadDir(filepath.Join(tf.Root, "vendor"))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tracked := npmmod.TrackedFilenames(tf.Packages)
+	for _, entry := range entries {
+		name := entry.Name()
+		if tracked[name] || name == "npm-mod.txt" {
+			continue
+		}
+		r.add("orphan", "vendor/"+name, "not tracked in .npm-mod.tidy.json")
+	}
+	return nil
+}
+
+// checkReferences checks that every `file:vendor/...` reference in
+// `package.json` and `package-lock.json` refers to a package archive that is
+// tracked in `.npm-mod.tidy.json` and exists in `vendor/`.
+func checkReferences(_ context.Context, tf *npmmod.TidyFile, r *Report) error {
+	pj, err := npmmod.ReadPackageJSON(tf.Root)
+	if err != nil {
+		return err
+	}
+	pjFilenames, err := npmmod.PackageJSONVendoredFilenames(pj)
+	if err != nil {
+		return err
+	}
+
+	pl, err := npmmod.ReadPackageLock(tf.Root)
+	if err != nil {
+		return err
+	}
+	plFilenames, err := npmmod.PackageLockVendoredFilenames(pl)
+	if err != nil {
+		return err
+	}
+
+	tracked := npmmod.TrackedFilenames(tf.Packages)
+	for _, name := range []string{"package.json", "package-lock.json"} {
+		filenames := pjFilenames
+		if name == "package-lock.json" {
+			filenames = plFilenames
+		}
+
+		for _, filename := range sortedKeys(filenames) {
+			if !tracked[filename] {
+				r.add("reference", name, fmt.Sprintf("refers to vendor/%s, which is not tracked in .npm-mod.tidy.json", filename))
+				continue
+			}
+
+			_, err := os.Stat(filepath.Join(tf.Root, "vendor", filename))
+			if err != nil && os.IsNotExist(err) {
+				r.add("reference", name, fmt.Sprintf("refers to vendor/%s, which does not exist", filename))
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkPatch checks that `package.json` and `package-lock.json` have not
+// changed since the last tidy, i.e. that the patch in `.npm-mod.tidy.json`
+// still restores the original files.
+func checkPatch(_ context.Context, tf *npmmod.TidyFile, r *Report) error {
+	patches := []struct {
+		Name  string
+		Patch npmmod.FilePatch
+		Read  func(root string) (*ordered.OrderedMap, error)
+	}{
+		{Name: "package.json", Patch: tf.Patch.PackageJSON, Read: npmmod.ReadPackageJSON},
+		{Name: "package-lock.json", Patch: tf.Patch.PackageLockJSON, Read: npmmod.ReadPackageLock},
+	}
+
+	for _, p := range patches {
+		data, err := os.ReadFile(filepath.Join(tf.Root, p.Name))
+		if err != nil {
+			return err
+		}
+
+		if p.Patch.Unverified() {
+			r.add("patch", p.Name, "cannot be checked for changes since the last tidy (.npm-mod.tidy.json was migrated without a checksum); run npm-mod tidy")
+		} else if p.Patch.Drifted(data) {
+			r.add("patch", p.Name, "changed since the last tidy (checksum does not match .npm-mod.tidy.json); run npm-mod tidy")
+		}
+
+		m, err := p.Read(tf.Root)
+		if err != nil {
+			return err
+		}
+
+		err = p.Patch.Revert(m, true)
+		if err != nil {
+			r.addError("patch", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkSum checks that `npm.sum` agrees with `.npm-mod.tidy.json`.
+func checkSum(_ context.Context, tf *npmmod.TidyFile, r *Report) error {
+	lines, err := npmmod.ReadSumFile(tf.Root)
+	if err != nil {
+		r.addError("sum", "npm.sum", err)
+		return nil
+	}
+
+	err = tf.CheckSum(lines)
+	if err != nil {
+		r.addError("sum", "npm.sum", err)
+	}
+	return nil
+}
+
+// checkStaleSum checks that there is no `npm.sum` for a project tidied in
+// registry or pin mode, where there are no package archives to describe.
+func checkStaleSum(_ context.Context, tf *npmmod.TidyFile, r *Report) error {
+	_, err := os.Stat(filepath.Join(tf.Root, "npm.sum"))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.add("sum", "npm.sum", fmt.Sprintf("is not used in %s mode; run npm-mod tidy to remove it", tf.Mode))
+	return nil
+}
+
+// checkManifest checks that `vendor/npm-mod.txt` describes the package
+// archives in `.npm-mod.tidy.json`.
+func checkManifest(_ context.Context, tf *npmmod.TidyFile, r *Report) error {
+	subject := "vendor/npm-mod.txt"
+	actual, err := npmmod.ReadManifest(tf.Root)
+	if err != nil && os.IsNotExist(err) {
+		r.add("manifest", subject, "does not exist; run npm-mod vendor")
+		return nil
+	}
+	if err != nil {
+		r.addError("manifest", subject, err)
+		return nil
+	}
+
+	pl, err := npmmod.ReadPackageLock(tf.Root)
+	if err != nil {
+		return err
+	}
+	expected, err := npmmod.NewManifest(tf, pl)
+	if err != nil {
+		return err
+	}
+
+	byFilename := manifestByFilename(actual)
+	for filename, entry := range manifestByFilename(expected) {
+		existing, ok := byFilename[filename]
+		delete(byFilename, filename)
+		if !ok {
+			r.add("manifest", subject, fmt.Sprintf("has no entry for %s", filename))
+			continue
+		}
+		if existing != entry {
+			r.add("manifest", subject, fmt.Sprintf("entry for %s is out of date; run npm-mod vendor", filename))
+		}
+	}
+	for filename := range byFilename {
+		r.add("manifest", subject, fmt.Sprintf("has an entry for %s, which is not tracked in .npm-mod.tidy.json", filename))
+	}
+
+	return nil
+}
+
+// manifestByFilename formats each manifest entry, indexed by filename.
+func manifestByFilename(entries []npmmod.ManifestEntry) map[string]string {
+	byFilename := map[string]string{}
+	for _, e := range entries {
+		byFilename[e.Filename] = string(npmmod.FormatManifest([]npmmod.ManifestEntry{e}))
+	}
+	return byFilename
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,20 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package verifycmd implements the `npm-mod verify` subcommand.
+//
+// With `--quick`, no package archive in `vendor/` is read if the cached
+// `vendor/` hash (keyed on the size and modification time of every package
+// archive) matches `.npm-mod.tidy.json`.
+package verifycmd
@@ -0,0 +1,145 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verifycmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+// NOTE: Ensure that
+//       * `checkOrphans` satisfies `check`.
+//       * `checkReferences` satisfies `check`.
+//       * `checkPatch` satisfies `check`.
+//       * `checkSum` satisfies `check`.
+//       * `checkStaleSum` satisfies `check`.
+//       * `checkManifest` satisfies `check`.
+var (
+	_ check = checkOrphans
+	_ check = checkReferences
+	_ check = checkPatch
+	_ check = checkSum
+	_ check = checkStaleSum
+	_ check = checkManifest
+)
+
+// Problem is a single problem found by `npm-mod verify`.
+type Problem struct {
+	// Check is the name of the check that found the problem, e.g. `archive`
+	// or `orphan`.
+	Check string `json:"check"`
+	// Subject is the file (or package archive) the problem is about.
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+// String formats the problem for the (non-JSON) report.
+func (p Problem) String() string {
+	return fmt.Sprintf("%s %s: %s", p.Check, p.Subject, p.Message)
+}
+
+// Report is the result of `npm-mod verify`.
+type Report struct {
+	Root string `json:"root"`
+	// Packages is the number of package archives in `.npm-mod.tidy.json`.
+	Packages int `json:"packages"`
+	// VendorHash is the hash of `vendor/` (empty if some package archives
+	// are missing).
+	VendorHash string `json:"vendor-hash"`
+	// Quick is set if the package archives were not read because the cached
+	// `vendor/` hash matched `.npm-mod.tidy.json`.
+	Quick bool `json:"quick"`
+	// Mirror is the registry that packages are installed from if the
+	// project was tidied in registry mode (i.e. there is no `vendor/`).
+	Mirror string `json:"mirror,omitempty"`
+	// Pinned is set if the project was tidied in pin mode (i.e. there is no
+	// `vendor/` and packages are installed from the registry).
+	Pinned   bool      `json:"pinned,omitempty"`
+	Problems []Problem `json:"problems"`
+}
+
+// check adds the problems it finds to a report.
+type check func(ctx context.Context, tf *npmmod.TidyFile, r *Report) error
+
+// add adds a problem to the report.
+func (r *Report) add(check, subject, message string) {
+	r.Problems = append(r.Problems, Problem{Check: check, Subject: subject, Message: message})
+}
+
+// addError adds a problem to the report for every problem in `err` (or a
+// single problem if `err` is not a `*npmmod.ProblemsError`).
+func (r *Report) addError(check, subject string, err error) {
+	var pe *npmmod.ProblemsError
+	if !errors.As(err, &pe) {
+		r.add(check, subject, err.Error())
+		return
+	}
+
+	for _, problem := range pe.Problems {
+		r.add(check, subject, problem)
+	}
+}
+
+// print writes the report to `w`, either as JSON or as text.
+func (r *Report) print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+		return nil
+	}
+
+	if len(r.Problems) > 0 {
+		return nil
+	}
+	if r.Mirror != "" {
+		fmt.Fprintf(w, "Verified %d packages installed from %s\n", r.Packages, r.Mirror)
+		return nil
+	}
+	if r.Pinned {
+		fmt.Fprintf(w, "Verified %d pinned packages\n", r.Packages)
+		return nil
+	}
+	if r.Quick {
+		fmt.Fprintf(w, "Verified vendor/ %s\n", r.VendorHash)
+		return nil
+	}
+	fmt.Fprintf(w, "Verified %d package archives in vendor/\n", r.Packages)
+	return nil
+}
+
+// err converts the problems in the report to an error.
+func (r *Report) err(asJSON bool) error {
+	if len(r.Problems) == 0 {
+		return nil
+	}
+	if asJSON {
+		return fmt.Errorf("verify found %s", npmmod.ProblemCount(len(r.Problems)))
+	}
+
+	lines := make([]string, len(r.Problems))
+	for i, p := range r.Problems {
+		lines[i] = fmt.Sprintf("- %s", p)
+	}
+	return fmt.Errorf("verify found %s:\n%s", npmmod.ProblemCount(len(r.Problems)), strings.Join(lines, "\n"))
+}
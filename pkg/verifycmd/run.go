@@ -0,0 +1,103 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verifycmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/hardfinhq/npm-mod/pkg/npmmod"
+)
+
+var (
+	poolSize = runtime.NumCPU()
+	checks   = []check{checkOrphans, checkReferences, checkPatch, checkSum, checkManifest}
+	// registryChecks are the checks for a project tidied in registry or pin
+	// mode, which has no package archives in `vendor/`.
+	registryChecks = []check{checkReferences, checkPatch, checkStaleSum}
+)
+
+// Options configures the `npm-mod verify` command.
+type Options struct {
+	// Quick determines if the package archives should only be read if their
+	// sizes or modification times have changed since the `vendor/` hash was
+	// last computed (by `npm-mod vendor` or `npm-mod verify`).
+	Quick bool
+	// JSON determines if the report should be written to stdout as JSON.
+	JSON bool
+}
+
+// Run executes the `npm-mod verify` command.
+func Run(ctx context.Context, opts Options) error {
+	here, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	root, err := npmmod.Locate(here)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Verifying doesn't write anything, so it doesn't need to hold the
+	//       lock.
+	return Verify(ctx, root, opts, os.Stdout)
+}
+
+// Verify runs every `npm-mod verify` check against the project in `root` and
+// writes the report to `w`. If any problems are found, this returns an error.
+func Verify(ctx context.Context, root string, opts Options, w io.Writer) error {
+	tf, err := npmmod.ReadTidyFile(root)
+	if err != nil {
+		return err
+	}
+
+	r := &Report{
+		Root:     root,
+		Packages: len(tf.Packages),
+		Mirror:   tf.Mirror,
+		Pinned:   tf.PinMode(),
+		Problems: []Problem{},
+	}
+
+	selected := checks
+	if tf.RegistryMode() || tf.PinMode() {
+		selected = registryChecks
+	} else {
+		err = hashArchives(ctx, tf, r, opts.Quick)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range selected {
+		err = c(ctx, tf, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.print(w, opts.JSON)
+	if err != nil {
+		return err
+	}
+	if tf.VendorHash == "" && !tf.RegistryMode() && !tf.PinMode() && !opts.JSON {
+		fmt.Fprintln(w, "No vendor/ hash in .npm-mod.tidy.json; run npm-mod vendor to record it")
+	}
+
+	return r.err(opts.JSON)
+}
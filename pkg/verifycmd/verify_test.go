@@ -0,0 +1,211 @@
+// Copyright 2022 Hardfin, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verifycmd_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	testifyassert "github.com/stretchr/testify/assert"
+
+	"github.com/hardfinhq/npm-mod/pkg/verifycmd"
+)
+
+func TestVerify(outer *testing.T) {
+	outer.Parallel()
+
+	cases := []struct {
+		Name       string
+		Change     func(assert *testifyassert.Assertions, root string)
+		Error      string
+		VendorHash string
+		Problems   []verifycmd.Problem
+	}{
+		{
+			Name:       "clean",
+			Change:     func(_ *testifyassert.Assertions, _ string) {},
+			VendorHash: "h1:ui+9libyiF//YpaS6TbCO4XYekdfxENiKAxcri41h+E=",
+			Problems:   []verifycmd.Problem{},
+		},
+		{
+			Name: "missing-archive",
+			Change: func(assert *testifyassert.Assertions, root string) {
+				err := os.Remove(filepath.Join(root, "vendor", "shebang-regex-3.0.0.tgz"))
+				assert.Nil(err)
+			},
+			Error: "verify found 3 problems",
+			Problems: []verifycmd.Problem{
+				{Check: "archive", Subject: "vendor/shebang-regex-3.0.0.tgz", Message: "does not exist"},
+				{Check: "reference", Subject: "package.json", Message: "refers to vendor/shebang-regex-3.0.0.tgz, which does not exist"},
+				{Check: "reference", Subject: "package-lock.json", Message: "refers to vendor/shebang-regex-3.0.0.tgz, which does not exist"},
+			},
+		},
+		{
+			Name: "corrupted-archive",
+			Change: func(assert *testifyassert.Assertions, root string) {
+				writeFile(assert, filepath.Join(root, "vendor", "builtins-1.0.3.tgz"), "corrupted")
+			},
+			Error: "verify found 1 problem",
+			Problems: []verifycmd.Problem{
+				{Check: "archive", Subject: "vendor/builtins-1.0.3.tgz", Message: "sha512 hashes do not match; expected: uYBjakWipfaO/bXI7E8rq6kpwHRZK5cNYrUv2OzZSI/FvmdMyXJ2tG9dKcjEC5YHmHpUAwsargWIZNWdxb/bnQ==; actual: jKEZfpiGTA+Ip4DDI+nhAP5K7j7NU61wmcSXUdeA2aQM6fPBj6Ev3ycwcwAGTnl6jy7AaZrhe7B+Rg/DWACO5A=="},
+			},
+		},
+		{
+			Name: "orphan",
+			Change: func(assert *testifyassert.Assertions, root string) {
+				writeFile(assert, filepath.Join(root, "vendor", "stray-1.0.0.tgz"), "stray")
+			},
+			Error:      "verify found 1 problem",
+			VendorHash: "h1:ui+9libyiF//YpaS6TbCO4XYekdfxENiKAxcri41h+E=",
+			Problems: []verifycmd.Problem{
+				{Check: "orphan", Subject: "vendor/stray-1.0.0.tgz", Message: "not tracked in .npm-mod.tidy.json"},
+			},
+		},
+		{
+			Name: "dangling-reference",
+			Change: func(assert *testifyassert.Assertions, root string) {
+				replaceInFile(assert, filepath.Join(root, "package.json"), "file:vendor/builtins-1.0.3.tgz", "file:vendor/builtins-1.0.4.tgz")
+			},
+			Error:      "verify found 3 problems",
+			VendorHash: "h1:ui+9libyiF//YpaS6TbCO4XYekdfxENiKAxcri41h+E=",
+			Problems: []verifycmd.Problem{
+				{Check: "reference", Subject: "package.json", Message: "refers to vendor/builtins-1.0.4.tgz, which is not tracked in .npm-mod.tidy.json"},
+				{Check: "patch", Subject: "package.json", Message: "changed since the last tidy (checksum does not match .npm-mod.tidy.json); run npm-mod tidy"},
+				{Check: "patch", Subject: "package.json", Message: `/dependencies/builtins is "file:vendor/builtins-1.0.4.tgz"; expected "file:vendor/builtins-1.0.3.tgz"`},
+			},
+		},
+		{
+			Name: "drifted-package-json",
+			Change: func(assert *testifyassert.Assertions, root string) {
+				replaceInFile(assert, filepath.Join(root, "package.json"), `"version": "1.0.0"`, `"version": "1.0.1"`)
+			},
+			Error:      "verify found 1 problem",
+			VendorHash: "h1:ui+9libyiF//YpaS6TbCO4XYekdfxENiKAxcri41h+E=",
+			Problems: []verifycmd.Problem{
+				{Check: "patch", Subject: "package.json", Message: "changed since the last tidy (checksum does not match .npm-mod.tidy.json); run npm-mod tidy"},
+			},
+		},
+		{
+			Name: "stale-manifest",
+			Change: func(assert *testifyassert.Assertions, root string) {
+				filename := filepath.Join(root, "vendor", "npm-mod.txt")
+				replaceInFile(assert, filename, "## required-by shebang-regex\n", "")
+			},
+			Error:      "verify found 1 problem",
+			VendorHash: "h1:ui+9libyiF//YpaS6TbCO4XYekdfxENiKAxcri41h+E=",
+			Problems: []verifycmd.Problem{
+				{Check: "manifest", Subject: "vendor/npm-mod.txt", Message: "entry for shebang-regex-3.0.0.tgz is out of date; run npm-mod vendor"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Copy to local to avoid closure around pointer
+		outer.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert := testifyassert.New(t)
+
+			root := copyProject(t, assert, "project")
+			tc.Change(assert, root)
+
+			var b bytes.Buffer
+			err := verifycmd.Verify(context.Background(), root, verifycmd.Options{JSON: true}, &b)
+			if tc.Error == "" {
+				assert.Nil(err)
+			} else {
+				assert.EqualError(err, tc.Error)
+			}
+
+			r := verifycmd.Report{}
+			err = json.Unmarshal(b.Bytes(), &r)
+			assert.Nil(err)
+			assert.Equal(root, r.Root)
+			assert.Equal(2, r.Packages)
+			assert.Equal(tc.VendorHash, r.VendorHash)
+			assert.False(r.Quick)
+			assert.Equal(tc.Problems, r.Problems)
+
+			// Without `--json`, the problems are in the error instead.
+			b.Reset()
+			err = verifycmd.Verify(context.Background(), root, verifycmd.Options{}, &b)
+			if tc.Error == "" {
+				assert.Nil(err)
+				assert.Equal("Verified 2 package archives in vendor/\n", b.String())
+				return
+			}
+			assert.NotNil(err)
+			lines := []string{tc.Error + ":"}
+			for _, p := range tc.Problems {
+				lines = append(lines, "- "+p.String())
+			}
+			assert.EqualError(err, strings.Join(lines, "\n"))
+			assert.Equal("", b.String())
+		})
+	}
+}
+
+// copyProject copies a `testdata/{fixture}` project fixture (including
+// `vendor/`) into a temporary directory.
+func copyProject(t *testing.T, assert *testifyassert.Assertions, fixture string) string {
+	destination, err := os.MkdirTemp("", "")
+	assert.Nil(err)
+	t.Cleanup(func() {
+		err = os.RemoveAll(destination)
+		assert.Nil(err)
+	})
+
+	source := filepath.Join("testdata", fixture)
+	err = filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, relative)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+	assert.Nil(err)
+
+	return destination
+}
+
+func writeFile(assert *testifyassert.Assertions, filename, contents string) {
+	err := os.WriteFile(filename, []byte(contents), 0644)
+	assert.Nil(err)
+}
+
+func replaceInFile(assert *testifyassert.Assertions, filename, old, new string) {
+	data, err := os.ReadFile(filename)
+	assert.Nil(err)
+	assert.Contains(string(data), old)
+	writeFile(assert, filename, strings.Replace(string(data), old, new, 1))
+}